@@ -8,10 +8,13 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"flag"
+	"fmt"
+	"io"
 	"math/big"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,10 +28,22 @@ var (
 	addr         = flag.String("addr", "localhost:4242", "Address to listen on (server) or connect to (client)")
 	configFile   = flag.String("config", "", "Path to JSON configuration file")
 	logLevel     = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	logFormat    = flag.String("log-format", "text", "Log format (text, json)")
+	logFile      = flag.String("log-file", "", "Log file (stderr if empty)")
 	multipath    = flag.Bool("multipath", false, "Enable multipath")
+	paths        = flag.String("paths", "", "Comma-separated server addresses of additional multipath paths")
 	obfs         = flag.Bool("obfs", false, "Enable obfuscation")
 	fecDataShards   = flag.Int("fec-data", 10, "Number of FEC data shards")
 	fecParityShards = flag.Int("fec-parity", 3, "Number of FEC parity shards")
+	fecInterleave   = flag.Int("fec-interleave", 1, "Number of writes whose FEC shards are interleaved (disabled if 1)")
+	metricsAddr     = flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (disabled if empty)")
+	debugAddr       = flag.String("debug-addr", "", "Local address to serve the telemetry history on (disabled if empty)")
+	qlogDir         = flag.String("qlog-dir", "", "Directory to write per-connection qlog traces to (disabled if empty)")
+	token           = flag.String("token", "", "Token the client identifies itself with for traffic accounting")
+	accountingFile  = flag.String("accounting-file", "", "File the server persists per-user traffic totals to (disabled if empty)")
+	rateControl     = flag.String("rate-control", "aimd", "Rate control algorithm (aimd, bbr, fixed)")
+	brutalUp        = flag.Uint64("brutal-up", 0, "Upload rate in bytes/s to declare (client) or grant at most (server) in brutal mode (disabled if 0)")
+	brutalDown      = flag.Uint64("brutal-down", 0, "Download rate in bytes/s to declare (client) or grant at most (server) in brutal mode (disabled if 0)")
 )
 
 func main() {
@@ -54,17 +69,32 @@ func main() {
 			Server:              *isServer,
 			Address:             *addr,
 			LogLevel:            *logLevel,
+			LogFormat:           *logFormat,
+			LogFile:             *logFile,
 			Multipath:           *multipath,
+			MultipathPaths:      pathConfigs(*paths),
 			Obfs:                *obfs,
 			FECData:             *fecDataShards,
 			FECParity:           *fecParityShards,
+			FECInterleaveDepth:  *fecInterleave,
 			TokenBucketRate:     1000000,   // Default 1 MB/s
 			TokenBucketCapacity: 5000000,  // Default 5 MB capacity
+			RateControl:         *rateControl,
+			BrutalUpRate:        *brutalUp,
+			BrutalDownRate:      *brutalDown,
+			MetricsAddress:      *metricsAddr,
+			DebugAddress:        *debugAddr,
+			QlogDir:             *qlogDir,
+			Token:               *token,
+			AccountingFile:      *accountingFile,
 		}
 	}
 
 	// Set up logging
-	core.InitLogger(config.LogLevel)
+	if err := core.ConfigureLogger(config.LogOptions()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		os.Exit(1)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -82,16 +112,36 @@ func main() {
 	tlsConfig := generateTLSConfig()
 
 	// Create core configuration
-	currentConfig := configManager.GetConfig()
+	// Without a config file, the configuration comes from the command-line flags
+	currentConfig := config
+	if configManager != nil {
+		currentConfig = configManager.GetConfig()
+	}
 	
 	coreConfig := &core.Config{
 		Address:   currentConfig.Address,
 		TLSConfig: tlsConfig,
 		IsServer:  currentConfig.Server,
+		Token:     []byte(currentConfig.Token),
+		Brutal:    core.BandwidthRates{Up: currentConfig.BrutalUpRate, Down: currentConfig.BrutalDownRate},
 	}
+	coreConfig.MultipathStreamBuffer = currentConfig.MultipathStreamBuffer
+	coreConfig.HeadOfLineTimeout = time.Duration(currentConfig.HeadOfLineTimeoutMs) * time.Millisecond
+	coreConfig.FECInterleaveDepth = currentConfig.FECInterleaveDepth
 
 	// Create token bucket
 	tokenBucket := core.NewTokenBucket(currentConfig.TokenBucketRate, currentConfig.TokenBucketCapacity)
+	coreConfig.RateLimiter = tokenBucket
+	coreConfig.RateLimitTree = core.NewRateLimitTree(tokenBucket)
+	coreConfig.RateLimitPolicy = currentConfig.RateLimitPolicy()
+	coreConfig.StreamPriorities = currentConfig.StreamPriorityMap()
+	
+	// Create the algorithm that adjusts the token bucket rate
+	rateController, err := core.NewRateController(currentConfig.RateControl, currentConfig.TokenBucketRate)
+	if err != nil {
+		core.Error("Failed to create rate controller: %v", err)
+		os.Exit(1)
+	}
 	
 	// Create adaptive FEC
 	adaptiveFEC, err := core.NewAdaptiveFEC(currentConfig.FECData, currentConfig.FECParity, 1, 10)
@@ -100,6 +150,60 @@ func main() {
 		os.Exit(1)
 	}
 	
+	// Start the metrics listener if enabled
+	if currentConfig.MetricsAddress != "" {
+		metricsServer, err := core.StartMetricsServer(currentConfig.MetricsAddress)
+		if err != nil {
+			core.Error("Failed to start metrics server: %v", err)
+			os.Exit(1)
+		}
+		defer metricsServer.Close()
+	}
+	core.DefaultMetrics().SetTokenBucketRate(currentConfig.TokenBucketRate)
+	
+	// Start the telemetry history listener if enabled
+	if currentConfig.DebugAddress != "" {
+		debugServer, err := core.StartDebugServer(currentConfig.DebugAddress)
+		if err != nil {
+			core.Error("Failed to start debug server: %v", err)
+			os.Exit(1)
+		}
+		defer debugServer.Close()
+	}
+	
+	// Enable qlog export if configured
+	if currentConfig.QlogDir != "" {
+		maxAge := time.Duration(currentConfig.QlogMaxAgeHours) * time.Hour
+		exporter, err := core.NewQlogExporter(currentConfig.QlogDir, currentConfig.QlogMaxSize, maxAge)
+		if err != nil {
+			core.Error("Failed to create qlog exporter: %v", err)
+			os.Exit(1)
+		}
+		if err := exporter.Cleanup(); err != nil {
+			core.Warn("Failed to clean up qlog directory: %v", err)
+		}
+		core.SetQlogExporter(exporter)
+		core.Info("Writing qlog traces to %s", currentConfig.QlogDir)
+	}
+	core.DefaultMetrics().WatchAdaptiveFEC(adaptiveFEC)
+	
+	// Account traffic per user and enforce quotas on the server if configured
+	if currentConfig.Server && currentConfig.AccountingEnabled() {
+		accountant, err := core.NewTrafficAccountant(currentConfig.AccountingFile, currentConfig.TrafficQuota())
+		if err != nil {
+			core.Error("Failed to create traffic accountant: %v", err)
+			os.Exit(1)
+		}
+		accountant.Start(time.Minute)
+		defer func() {
+			if err := accountant.Stop(); err != nil {
+				core.Error("Failed to save traffic totals: %v", err)
+			}
+		}()
+		core.SetTrafficAccountant(accountant)
+		core.Info("Accounting traffic per user")
+	}
+	
 	// Create obfuscator if enabled
 	obfuscator := core.NewObfuscator(core.ObfuscatorConfig{
 		Enabled: currentConfig.Obfs,
@@ -109,9 +213,17 @@ func main() {
 	var session *core.Session
 	var obfsSession *core.ObfuscatorSession
 	var multipathSession *core.MultipathSession
+	var multipathListener *core.MultipathListener
 	
 	// Create session
-	if currentConfig.Multipath {
+	if currentConfig.Multipath && currentConfig.Server {
+		// Group the connections of each client into a multipath session
+		multipathListener, err = core.ListenMultipath(ctx, coreConfig)
+		if err != nil {
+			core.Error("Failed to listen for multipath sessions: %v", err)
+			os.Exit(1)
+		}
+	} else if currentConfig.Multipath {
 		// Create multipath session
 		core.Info("Creating multipath session")
 		// Create a token bucket controller for multipath
@@ -121,9 +233,11 @@ func main() {
 			core.Error("Failed to create adaptive FEC: %v", err)
 			os.Exit(1)
 		}
+		core.DefaultMetrics().WatchAdaptiveFEC(adaptiveFEC)
 		
 		// Create a token bucket controller (will be updated with connection later)
 		controller := core.NewTokenBucketController(tokenBucket, adaptiveFEC, nil)
+		controller.SetRateController(rateController)
 		controller.Start()
 		defer controller.Stop()
 		
@@ -133,7 +247,17 @@ func main() {
 			core.Error("Failed to add path to multipath session: %v", err)
 			os.Exit(1)
 		}
-		// TODO: Add additional paths for true multipath support
+		// Add the additional paths, which join the session of the primary one
+		for _, path := range currentConfig.MultipathPaths {
+			address := path.Address
+			if address == "" {
+				address = currentConfig.Address
+			}
+			options := core.PathOptions{LocalAddress: path.LocalAddress, Interface: path.Interface}
+			if err := multipathSession.AddPathWithOptions(ctx, address, options); err != nil {
+				core.Warn("Failed to add path to %s: %v", address, err)
+			}
+		}
 	} else {
 		// Create regular session
 		session, err = core.NewSession(ctx, coreConfig)
@@ -158,6 +282,12 @@ func main() {
 		// Only start token bucket controller for client mode
 		// Server mode handles telemetry within the session
 		controller = core.NewTokenBucketController(tokenBucket, adaptiveFEC, session.Connection())
+		controller.SetPeerTelemetrySource(session.PeerTelemetry)
+		if rate := session.BrutalRates().Up; rate > 0 {
+			// Brutal mode paces at the negotiated rate instead
+			rateController = core.NewBrutalRateController(float64(rate))
+		}
+		controller.SetRateController(rateController)
 		// Note: The telemetry stream is now handled within the session itself
 		controller.Start()
 		defer controller.Stop()
@@ -165,7 +295,9 @@ func main() {
 		core.Info("Token bucket controller not started for server mode")
 	}
 
-	if currentConfig.Multipath {
+	if multipathListener != nil {
+		defer multipathListener.Close()
+	} else if currentConfig.Multipath {
 		defer multipathSession.Close()
 	} else {
 		defer session.Close()
@@ -173,9 +305,13 @@ func main() {
 
 	if currentConfig.Multipath && currentConfig.Server {
 		core.Info("Multipath server running, waiting for connections...")
-		// For a multipath server, we would need to implement a different logic
-		// For now, we'll just keep the main goroutine alive
-		<-ctx.Done()
+		for {
+			ms, err := multipathListener.Accept(ctx)
+			if err != nil {
+				break
+			}
+			go serveMultipathSession(ctx, ms)
+		}
 	} else if currentConfig.Multipath && !currentConfig.Server {
 		core.Info("Multipath client connected, opening interactive stream...")
 		// For demo, open one interactive stream and send/receive data
@@ -272,6 +408,66 @@ func main() {
 	time.Sleep(1 * time.Second)
 }
 
+// serveMultipathSession echoes the interactive streams the client opens on
+// any path of a multipath session, and the multipath streams it spreads
+// across them, until the session ends.
+func serveMultipathSession(ctx context.Context, ms *core.MultipathSession) {
+	defer ms.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			ms.Close()
+		case <-ms.Done():
+		}
+	}()
+	go func() {
+		for {
+			stream, err := ms.AcceptMultipathStream(ctx)
+			if err != nil {
+				return
+			}
+			go func(s *core.MultipathStream) {
+				defer s.Close()
+				io.Copy(s, s)
+			}(stream)
+		}
+	}()
+	
+	for {
+		stream, err := ms.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		
+		// Handle each stream in a separate goroutine
+		go func(s quic.Stream) {
+			defer s.Close()
+			buf := make([]byte, 1024)
+			for {
+				n, err := s.Read(buf)
+				if err != nil {
+					break
+				}
+				if _, err := s.Write(buf[:n]); err != nil {
+					break
+				}
+			}
+		}(stream)
+	}
+}
+
+// pathConfigs returns the multipath paths of a comma-separated list of
+// server addresses.
+func pathConfigs(addresses string) []cli.PathConfig {
+	var paths []cli.PathConfig
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			paths = append(paths, cli.PathConfig{Address: address})
+		}
+	}
+	return paths
+}
+
 // generateTLSConfig generates a simple self-signed TLS config for testing.
 func generateTLSConfig() *tls.Config {
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
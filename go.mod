@@ -4,14 +4,15 @@ go 1.22
 
 require (
 	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/klauspost/reedsolomon v1.12.5
 	github.com/quic-go/quic-go v0.40.0
 )
 
 require (
+	github.com/francoispqt/gojay v1.2.13 // indirect
 	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
 	github.com/google/pprof v0.0.0-20210407192527-94a9f03dee38 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
-	github.com/klauspost/reedsolomon v1.12.5 // indirect
 	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
 	github.com/quic-go/qtls-go1-20 v0.4.1 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
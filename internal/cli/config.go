@@ -18,18 +18,113 @@ type Config struct {
 	Address string `json:"address"`
 	// LogLevel is the log level (debug, info, warn, error).
 	LogLevel string `json:"log_level"`
+	// LogFormat is the log output format (text, json).
+	LogFormat string `json:"log_format"`
+	// LogComponents overrides the log level of individual components,
+	// e.g. {"fec": "debug", "multipath": "warn"}.
+	LogComponents map[string]string `json:"log_components"`
+	// LogFile is the log file. Logs are written to stderr if it is empty.
+	LogFile string `json:"log_file"`
+	// LogMaxSize is the size at which the log file is rotated (bytes).
+	// Zero disables rotation.
+	LogMaxSize int64 `json:"log_max_size"`
+	// LogMaxBackups is the number of rotated log files to keep.
+	LogMaxBackups int `json:"log_max_backups"`
 	// Multipath enables multipath.
 	Multipath bool `json:"multipath"`
+	// MultipathPaths are the paths a multipath client adds after the one
+	// to Address. The server groups them into one multipath session.
+	MultipathPaths []PathConfig `json:"multipath_paths"`
+	// MultipathStreamBuffer is the number of bytes a multipath stream
+	// buffers for reordering before it stops taking chunks. Zero means 1MB.
+	MultipathStreamBuffer int `json:"multipath_stream_buffer"`
+	// HeadOfLineTimeoutMs is how long a multipath stream waits for a
+	// missing chunk before asking for it again (milliseconds). Zero means
+	// 2000.
+	HeadOfLineTimeoutMs int `json:"head_of_line_timeout_ms"`
 	// Obfs enables obfuscation.
 	Obfs bool `json:"obfs"`
 	// FECData is the number of FEC data shards.
 	FECData int `json:"fec_data"`
 	// FECParity is the number of FEC parity shards.
 	FECParity int `json:"fec_parity"`
+	// FECInterleaveDepth is the number of writes whose FEC shards are
+	// interleaved on the wire. Zero or 1 disables interleaving.
+	FECInterleaveDepth int `json:"fec_interleave_depth"`
 	// TokenBucketRate is the initial rate for the token bucket (bytes per second).
 	TokenBucketRate float64 `json:"token_bucket_rate"`
 	// TokenBucketCapacity is the capacity of the token bucket (bytes).
 	TokenBucketCapacity float64 `json:"token_bucket_capacity"`
+	// RateControl is the algorithm that adjusts the token bucket rate
+	// (aimd, bbr, fixed). Empty means aimd.
+	RateControl string `json:"rate_control"`
+	// BrutalUpRate is the client-to-server rate of brutal mode (bytes per
+	// second). A client declares it; a server grants at most it. Zero
+	// disables brutal mode in that direction.
+	BrutalUpRate uint64 `json:"brutal_up_rate"`
+	// BrutalDownRate is the server-to-client rate of brutal mode (bytes
+	// per second). A client declares it; a server grants at most it. Zero
+	// disables brutal mode in that direction.
+	BrutalDownRate uint64 `json:"brutal_down_rate"`
+	// MetricsAddress is the address of the Prometheus metrics listener.
+	// Metrics are not served if it is empty.
+	MetricsAddress string `json:"metrics_address"`
+	// DebugAddress is the address of the local debug listener that serves
+	// the telemetry history. It is not served if it is empty.
+	DebugAddress string `json:"debug_address"`
+	// QlogDir is the directory qlog traces are written to, one per connection.
+	// Traces are not written if it is empty.
+	QlogDir string `json:"qlog_dir"`
+	// QlogMaxSize is the maximum total size of the qlog traces (bytes).
+	// Zero means unlimited.
+	QlogMaxSize int64 `json:"qlog_max_size"`
+	// QlogMaxAgeHours is the maximum age of a qlog trace (hours).
+	// Zero means unlimited.
+	QlogMaxAgeHours int `json:"qlog_max_age_hours"`
+	// Token is the token the client identifies itself with. The server
+	// accounts traffic and enforces quotas per token.
+	Token string `json:"token"`
+	// AccountingFile is the file the server persists the traffic of each
+	// user to. Traffic is accounted in memory only if it is empty.
+	AccountingFile string `json:"accounting_file"`
+	// QuotaDaily is the number of bytes each user may transfer per day.
+	// Zero means unlimited.
+	QuotaDaily uint64 `json:"quota_daily"`
+	// QuotaMonthly is the number of bytes each user may transfer per month.
+	// Zero means unlimited.
+	QuotaMonthly uint64 `json:"quota_monthly"`
+	// QuotaAction is what happens once a user exceeds a quota (reject,
+	// throttle).
+	QuotaAction string `json:"quota_action"`
+	// QuotaThrottleRate is the rate over-quota users are throttled to
+	// (bytes per second).
+	QuotaThrottleRate float64 `json:"quota_throttle_rate"`
+	// RateLimitUser caps the send rate of each user below the token bucket
+	// rate (bytes per second). Zero means no per-user cap.
+	RateLimitUser float64 `json:"rate_limit_user"`
+	// RateLimitSession caps the send rate of each session (bytes per
+	// second). Zero means no per-session cap.
+	RateLimitSession float64 `json:"rate_limit_session"`
+	// RateLimitWeights are the weights of the stream types (interactive,
+	// bulk, telemetry) sharing a session's rate.
+	RateLimitWeights map[string]float64 `json:"rate_limit_weights"`
+	// StreamPriorities are the priorities of the stream types (interactive,
+	// bulk, telemetry) as low, normal or high. Writes of a higher priority
+	// are sent first.
+	StreamPriorities map[string]string `json:"stream_priorities"`
+}
+
+// PathConfig configures an additional path of a multipath client.
+type PathConfig struct {
+	// Address is the server address the path connects to. Empty means the
+	// address of the first path.
+	Address string `json:"address"`
+	// LocalAddress is the local IP address the path sends from. The
+	// routing table picks it if it is empty.
+	LocalAddress string `json:"local_address"`
+	// Interface is the network interface the path is bound to (Linux
+	// only), e.g. wlan0 or wwan0.
+	Interface string `json:"interface"`
 }
 
 // ConfigManager manages the configuration with hot reloading capability.
@@ -145,9 +240,21 @@ func (cm *ConfigManager) checkForChanges() {
 		cm.config = newConfig
 		cm.mutex.Unlock()
 		
-		// Update the global logger level if it has changed
-		if currentConfig.LogLevel != newConfig.LogLevel {
-			core.InitLogger(newConfig.LogLevel)
+		// Reconfigure the global logger if its settings have changed
+		if loggingChanged(currentConfig, newConfig) {
+			if err := core.ConfigureLogger(newConfig.LogOptions()); err != nil {
+				core.Error("Failed to reconfigure logging: %v", err)
+			}
+		}
+		
+		// Apply new quotas to the running server
+		if quotaChanged(currentConfig, newConfig) {
+			core.SetTrafficQuota(newConfig.TrafficQuota())
+		}
+		
+		// Apply new rate limits and stream priorities to the running sessions
+		if rateLimitChanged(currentConfig, newConfig) || streamPrioritiesChanged(currentConfig, newConfig) {
+			core.SetRateLimitPolicy(newConfig.RateLimitPolicy(), newConfig.StreamPriorityMap())
 		}
 	}
 }
@@ -156,13 +263,171 @@ func (cm *ConfigManager) checkForChanges() {
 func (cm *ConfigManager) hasConfigChanged(oldConfig, newConfig *Config) bool {
 	return oldConfig.Server != newConfig.Server ||
 		oldConfig.Address != newConfig.Address ||
-		oldConfig.LogLevel != newConfig.LogLevel ||
+		loggingChanged(oldConfig, newConfig) ||
 		oldConfig.Multipath != newConfig.Multipath ||
+		multipathPathsChanged(oldConfig, newConfig) ||
+		oldConfig.MultipathStreamBuffer != newConfig.MultipathStreamBuffer ||
+		oldConfig.HeadOfLineTimeoutMs != newConfig.HeadOfLineTimeoutMs ||
 		oldConfig.Obfs != newConfig.Obfs ||
 		oldConfig.FECData != newConfig.FECData ||
 		oldConfig.FECParity != newConfig.FECParity ||
+		oldConfig.FECInterleaveDepth != newConfig.FECInterleaveDepth ||
 		oldConfig.TokenBucketRate != newConfig.TokenBucketRate ||
-		oldConfig.TokenBucketCapacity != newConfig.TokenBucketCapacity
+		oldConfig.TokenBucketCapacity != newConfig.TokenBucketCapacity ||
+		oldConfig.RateControl != newConfig.RateControl ||
+		oldConfig.BrutalUpRate != newConfig.BrutalUpRate ||
+		oldConfig.BrutalDownRate != newConfig.BrutalDownRate ||
+		oldConfig.MetricsAddress != newConfig.MetricsAddress ||
+		oldConfig.DebugAddress != newConfig.DebugAddress ||
+		oldConfig.QlogDir != newConfig.QlogDir ||
+		oldConfig.QlogMaxSize != newConfig.QlogMaxSize ||
+		oldConfig.QlogMaxAgeHours != newConfig.QlogMaxAgeHours ||
+		oldConfig.Token != newConfig.Token ||
+		oldConfig.AccountingFile != newConfig.AccountingFile ||
+		quotaChanged(oldConfig, newConfig) ||
+		rateLimitChanged(oldConfig, newConfig) ||
+		streamPrioritiesChanged(oldConfig, newConfig)
+}
+
+// multipathPathsChanged checks if the multipath paths have changed.
+func multipathPathsChanged(oldConfig, newConfig *Config) bool {
+	if len(oldConfig.MultipathPaths) != len(newConfig.MultipathPaths) {
+		return true
+	}
+	for i, path := range oldConfig.MultipathPaths {
+		if newConfig.MultipathPaths[i] != path {
+			return true
+		}
+	}
+	return false
+}
+
+// streamPrioritiesChanged checks if the stream priorities have changed.
+func streamPrioritiesChanged(oldConfig, newConfig *Config) bool {
+	if len(oldConfig.StreamPriorities) != len(newConfig.StreamPriorities) {
+		return true
+	}
+	for name, priority := range oldConfig.StreamPriorities {
+		if newPriority, ok := newConfig.StreamPriorities[name]; !ok || newPriority != priority {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitChanged checks if the rate limit configuration has changed.
+func rateLimitChanged(oldConfig, newConfig *Config) bool {
+	if oldConfig.RateLimitUser != newConfig.RateLimitUser ||
+		oldConfig.RateLimitSession != newConfig.RateLimitSession ||
+		len(oldConfig.RateLimitWeights) != len(newConfig.RateLimitWeights) {
+		return true
+	}
+	for name, weight := range oldConfig.RateLimitWeights {
+		if newWeight, ok := newConfig.RateLimitWeights[name]; !ok || newWeight != weight {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaChanged checks if the quota configuration has changed.
+func quotaChanged(oldConfig, newConfig *Config) bool {
+	return oldConfig.QuotaDaily != newConfig.QuotaDaily ||
+		oldConfig.QuotaMonthly != newConfig.QuotaMonthly ||
+		oldConfig.QuotaAction != newConfig.QuotaAction ||
+		oldConfig.QuotaThrottleRate != newConfig.QuotaThrottleRate
+}
+
+// loggingChanged checks if the logging configuration has changed.
+func loggingChanged(oldConfig, newConfig *Config) bool {
+	if oldConfig.LogLevel != newConfig.LogLevel ||
+		oldConfig.LogFormat != newConfig.LogFormat ||
+		oldConfig.LogFile != newConfig.LogFile ||
+		oldConfig.LogMaxSize != newConfig.LogMaxSize ||
+		oldConfig.LogMaxBackups != newConfig.LogMaxBackups ||
+		len(oldConfig.LogComponents) != len(newConfig.LogComponents) {
+		return true
+	}
+	for component, level := range oldConfig.LogComponents {
+		if newLevel, ok := newConfig.LogComponents[component]; !ok || newLevel != level {
+			return true
+		}
+	}
+	return false
+}
+
+// LogOptions returns the logger options of the configuration.
+func (c *Config) LogOptions() core.LogOptions {
+	return core.LogOptions{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		Components: c.LogComponents,
+		File:       c.LogFile,
+		MaxSize:    c.LogMaxSize,
+		MaxBackups: c.LogMaxBackups,
+	}
+}
+
+// TrafficQuota returns the per-user traffic quota of the configuration.
+func (c *Config) TrafficQuota() core.Quota {
+	return core.Quota{
+		Daily:        c.QuotaDaily,
+		Monthly:      c.QuotaMonthly,
+		Action:       core.QuotaAction(c.QuotaAction),
+		ThrottleRate: c.QuotaThrottleRate,
+	}
+}
+
+// RateLimitPolicy returns the per-user, per-session and per-stream type
+// rate limits of the configuration.
+func (c *Config) RateLimitPolicy() core.RateLimitPolicy {
+	weights := make(map[uint8]float64)
+	for name, weight := range c.RateLimitWeights {
+		switch name {
+		case "interactive":
+			weights[core.StreamTypeInteractive] = weight
+		case "bulk":
+			weights[core.StreamTypeBulk] = weight
+		case "telemetry":
+			weights[core.StreamTypeTelemetry] = weight
+		default:
+			core.Warn("Ignoring rate limit weight of unknown stream type %q", name)
+		}
+	}
+	return core.RateLimitPolicy{
+		UserRate:      c.RateLimitUser,
+		SessionRate:   c.RateLimitSession,
+		StreamWeights: weights,
+	}
+}
+
+// StreamPriorityMap returns the priorities of the stream types of the
+// configuration.
+func (c *Config) StreamPriorityMap() map[uint8]core.StreamPriority {
+	priorities := make(map[uint8]core.StreamPriority)
+	for name, value := range c.StreamPriorities {
+		priority, err := core.ParseStreamPriority(value)
+		if err != nil {
+			core.Warn("Ignoring priority of %s streams: %v", name, err)
+			continue
+		}
+		switch name {
+		case "interactive":
+			priorities[core.StreamTypeInteractive] = priority
+		case "bulk":
+			priorities[core.StreamTypeBulk] = priority
+		case "telemetry":
+			priorities[core.StreamTypeTelemetry] = priority
+		default:
+			core.Warn("Ignoring priority of unknown stream type %q", name)
+		}
+	}
+	return priorities
+}
+
+// AccountingEnabled reports whether the server accounts traffic per user.
+func (c *Config) AccountingEnabled() bool {
+	return c.AccountingFile != "" || c.QuotaDaily > 0 || c.QuotaMonthly > 0
 }
 
 // StopHotReload stops the hot reloading of the configuration.
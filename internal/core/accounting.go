@@ -0,0 +1,485 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// accountingLog logs traffic accounting and quota events.
+var accountingLog = ComponentLogger("accounting")
+
+// ErrQuotaExceeded is returned when a user has used up a traffic quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaAction is what happens to a user's traffic once a quota is exceeded.
+type QuotaAction string
+
+const (
+	// QuotaReject rejects new sessions of the user.
+	QuotaReject QuotaAction = "reject"
+	// QuotaThrottle accepts sessions but limits their throughput.
+	QuotaThrottle QuotaAction = "throttle"
+)
+
+// Quota limits the traffic of each user. Traffic in both directions counts
+// against it.
+type Quota struct {
+	// Daily is the number of bytes a user may transfer per day.
+	// Zero means unlimited.
+	Daily uint64
+	// Monthly is the number of bytes a user may transfer per month.
+	// Zero means unlimited.
+	Monthly uint64
+	// Action is what happens once a quota is exceeded. It defaults to
+	// QuotaReject.
+	Action QuotaAction
+	// ThrottleRate is the rate in bytes per second a user is throttled to
+	// when Action is QuotaThrottle.
+	ThrottleRate float64
+}
+
+// TrafficUsage counts the bytes transferred in each direction, seen from
+// the client.
+type TrafficUsage struct {
+	// Up is the number of bytes sent by the client.
+	Up uint64 `json:"up"`
+	// Down is the number of bytes received by the client.
+	Down uint64 `json:"down"`
+}
+
+// Total returns the number of bytes transferred in both directions.
+func (u TrafficUsage) Total() uint64 {
+	return u.Up + u.Down
+}
+
+// UserTraffic is the traffic of a user.
+type UserTraffic struct {
+	// Total is the traffic since accounting started.
+	Total TrafficUsage `json:"total"`
+	// Day is the day Daily is counted for, as YYYY-MM-DD.
+	Day string `json:"day"`
+	// Daily is the traffic of the current day.
+	Daily TrafficUsage `json:"daily"`
+	// Month is the month Monthly is counted for, as YYYY-MM.
+	Month string `json:"month"`
+	// Monthly is the traffic of the current month.
+	Monthly TrafficUsage `json:"monthly"`
+}
+
+// rollover starts new daily and monthly periods if now is past the
+// current ones.
+func (ut *UserTraffic) rollover(now time.Time) {
+	if day := now.Format("2006-01-02"); ut.Day != day {
+		ut.Day = day
+		ut.Daily = TrafficUsage{}
+	}
+	if month := now.Format("2006-01"); ut.Month != month {
+		ut.Month = month
+		ut.Monthly = TrafficUsage{}
+	}
+}
+
+// trafficFile is the persisted form of the accounted traffic.
+type trafficFile struct {
+	// Users maps user identities to their traffic.
+	Users map[string]*UserTraffic `json:"users"`
+}
+
+// TrafficAccountant counts the traffic of each user and session, persists
+// the user totals to a file and enforces quotas. It is safe for concurrent
+// use.
+type TrafficAccountant struct {
+	// path is the file the totals are persisted to, or empty to keep them
+	// in memory only.
+	path string
+	// quota is the quota enforced per user.
+	quota Quota
+	// users maps user identities to their traffic.
+	users map[string]*UserTraffic
+	// sessions maps the IDs of live sessions to their traffic.
+	sessions map[string]*TrafficUsage
+	// throttles holds the token buckets of throttled users.
+	throttles map[string]*TokenBucket
+	// changes counts the changes of the totals.
+	changes uint64
+	// saved is the value of changes when the totals were last saved.
+	saved uint64
+	// now returns the current time.
+	now func() time.Time
+	// mutex protects quota, users, sessions, throttles, changes and saved.
+	mutex sync.Mutex
+	// ctx is the context for the periodic saving.
+	ctx context.Context
+	// cancel is the cancel function for the periodic saving.
+	cancel context.CancelFunc
+	// done is closed when the periodic saving has stopped.
+	done chan struct{}
+}
+
+// NewTrafficAccountant creates a new TrafficAccountant that persists the
+// totals to path, loading the totals saved there before. An empty path
+// keeps the totals in memory only.
+func NewTrafficAccountant(path string, quota Quota) (*TrafficAccountant, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ta := &TrafficAccountant{
+		path:      path,
+		quota:     quota,
+		users:     make(map[string]*UserTraffic),
+		sessions:  make(map[string]*TrafficUsage),
+		throttles: make(map[string]*TokenBucket),
+		now:       time.Now,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if err := ta.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return ta, nil
+}
+
+// load reads the persisted totals. A missing file is not an error.
+func (ta *TrafficAccountant) load() error {
+	if ta.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(ta.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read traffic file: %w", err)
+	}
+
+	var file trafficFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to decode traffic file: %w", err)
+	}
+	for user, traffic := range file.Users {
+		if traffic != nil {
+			ta.users[user] = traffic
+		}
+	}
+	return nil
+}
+
+// SetQuota replaces the quota enforced per user.
+func (ta *TrafficAccountant) SetQuota(quota Quota) {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	ta.quota = quota
+	// Throttled users get a bucket with the new rate when they next transfer
+	ta.throttles = make(map[string]*TokenBucket)
+}
+
+// Quota returns the quota enforced per user.
+func (ta *TrafficAccountant) Quota() Quota {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+	return ta.quota
+}
+
+// Add counts traffic of a user's session.
+func (ta *TrafficAccountant) Add(user, sessionID string, up, down uint64) {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	traffic := ta.userTraffic(user)
+	traffic.Total.Up += up
+	traffic.Total.Down += down
+	traffic.Daily.Up += up
+	traffic.Daily.Down += down
+	traffic.Monthly.Up += up
+	traffic.Monthly.Down += down
+	ta.changes++
+
+	if sessionID != "" {
+		session, ok := ta.sessions[sessionID]
+		if !ok {
+			session = &TrafficUsage{}
+			ta.sessions[sessionID] = session
+		}
+		session.Up += up
+		session.Down += down
+	}
+}
+
+// userTraffic returns the traffic of a user in the current periods,
+// creating it if needed. The caller must hold the mutex.
+func (ta *TrafficAccountant) userTraffic(user string) *UserTraffic {
+	traffic, ok := ta.users[user]
+	if !ok {
+		traffic = &UserTraffic{}
+		ta.users[user] = traffic
+	}
+	traffic.rollover(ta.now())
+	return traffic
+}
+
+// UserUsage returns the traffic of a user.
+func (ta *TrafficAccountant) UserUsage(user string) UserTraffic {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	if _, ok := ta.users[user]; !ok {
+		var traffic UserTraffic
+		traffic.rollover(ta.now())
+		return traffic
+	}
+	return *ta.userTraffic(user)
+}
+
+// SessionUsage returns the traffic of a live session.
+func (ta *TrafficAccountant) SessionUsage(sessionID string) TrafficUsage {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	if session, ok := ta.sessions[sessionID]; ok {
+		return *session
+	}
+	return TrafficUsage{}
+}
+
+// EndSession stops tracking a session and returns its traffic.
+func (ta *TrafficAccountant) EndSession(sessionID string) TrafficUsage {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	var usage TrafficUsage
+	if session, ok := ta.sessions[sessionID]; ok {
+		usage = *session
+		delete(ta.sessions, sessionID)
+	}
+	return usage
+}
+
+// CheckQuota returns an error wrapping ErrQuotaExceeded if the user has
+// used up the daily or monthly quota.
+func (ta *TrafficAccountant) CheckQuota(user string) error {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+	return ta.checkQuota(user)
+}
+
+// checkQuota implements CheckQuota. The caller must hold the mutex.
+func (ta *TrafficAccountant) checkQuota(user string) error {
+	traffic, ok := ta.users[user]
+	if !ok {
+		return nil
+	}
+	traffic.rollover(ta.now())
+
+	if ta.quota.Daily > 0 && traffic.Daily.Total() >= ta.quota.Daily {
+		return fmt.Errorf("%w: daily quota of %d bytes used up", ErrQuotaExceeded, ta.quota.Daily)
+	}
+	if ta.quota.Monthly > 0 && traffic.Monthly.Total() >= ta.quota.Monthly {
+		return fmt.Errorf("%w: monthly quota of %d bytes used up", ErrQuotaExceeded, ta.quota.Monthly)
+	}
+	return nil
+}
+
+// Admit checks whether a new session of the user is accepted. It returns
+// an error wrapping ErrQuotaExceeded if the user has used up a quota and
+// over-quota sessions are rejected.
+func (ta *TrafficAccountant) Admit(user string) error {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	if ta.quota.Action == QuotaThrottle {
+		return nil
+	}
+	return ta.checkQuota(user)
+}
+
+// throttleBucket returns the token bucket n bytes of the user must be
+// taken from, or nil if the user is not throttled.
+func (ta *TrafficAccountant) throttleBucket(user string) *TokenBucket {
+	ta.mutex.Lock()
+	defer ta.mutex.Unlock()
+
+	if ta.quota.Action != QuotaThrottle || ta.quota.ThrottleRate <= 0 || ta.checkQuota(user) == nil {
+		return nil
+	}
+	bucket, ok := ta.throttles[user]
+	if !ok {
+		// Allow bursts of one second
+		bucket = NewTokenBucket(ta.quota.ThrottleRate, ta.quota.ThrottleRate)
+		ta.throttles[user] = bucket
+	}
+	return bucket
+}
+
+// throttle blocks until n bytes of the user may be transferred, or until
+// ctx is done. It returns immediately for users that are not throttled.
+func (ta *TrafficAccountant) throttle(ctx context.Context, user string, n int) error {
+	bucket := ta.throttleBucket(user)
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.Wait(ctx, float64(n))
+}
+
+// Save writes the user totals to the file if they changed since they were
+// last saved. The file is replaced atomically. If saving fails, the totals
+// are saved again by the next call.
+func (ta *TrafficAccountant) Save() error {
+	if ta.path == "" {
+		return nil
+	}
+
+	ta.mutex.Lock()
+	if ta.changes == ta.saved {
+		ta.mutex.Unlock()
+		return nil
+	}
+	changes := ta.changes
+	data, err := json.MarshalIndent(trafficFile{Users: ta.users}, "", "  ")
+	ta.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode traffic file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ta.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create traffic directory: %w", err)
+	}
+	tmp := ta.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write traffic file: %w", err)
+	}
+	if err := os.Rename(tmp, ta.path); err != nil {
+		return fmt.Errorf("failed to replace traffic file: %w", err)
+	}
+
+	// Changes made while writing are saved by the next call
+	ta.mutex.Lock()
+	if changes > ta.saved {
+		ta.saved = changes
+	}
+	ta.mutex.Unlock()
+	return nil
+}
+
+// Start saves the totals every interval until Stop is called.
+func (ta *TrafficAccountant) Start(interval time.Duration) {
+	ta.done = make(chan struct{})
+	go func() {
+		defer close(ta.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ta.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ta.Save(); err != nil {
+					accountingLog.Warn("Failed to save traffic totals: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic saving and saves the totals a last time.
+func (ta *TrafficAccountant) Stop() error {
+	ta.cancel()
+	if ta.done != nil {
+		<-ta.done
+	}
+	return ta.Save()
+}
+
+// trafficAccountant is the accountant of server sessions, or nil if
+// traffic is not accounted.
+var trafficAccountant atomic.Pointer[TrafficAccountant]
+
+// SetTrafficAccountant enables traffic accounting and quota enforcement
+// for server sessions accepted from now on. Passing nil disables it.
+func SetTrafficAccountant(accountant *TrafficAccountant) {
+	trafficAccountant.Store(accountant)
+}
+
+// SetTrafficQuota replaces the quota of the traffic accountant, if any.
+func SetTrafficQuota(quota Quota) {
+	if accountant := trafficAccountant.Load(); accountant != nil {
+		accountant.SetQuota(quota)
+	}
+}
+
+// clientIdentity returns the identity a client's traffic is accounted
+// under: a hash of its token, or the host of its address if it sent no
+// token. Tokens are hashed so they are not written to the traffic file.
+func clientIdentity(token []byte, addr net.Addr) string {
+	if len(token) > 0 {
+		sum := sha256.Sum256(token)
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// accountedStream counts the bytes of a server stream for its user and
+// session and throttles them once the user is over quota.
+type accountedStream struct {
+	quic.Stream
+	// accountant counts the bytes.
+	accountant *TrafficAccountant
+	// user is the identity of the session's client.
+	user string
+	// sessionID is the ID of the stream's session.
+	sessionID string
+}
+
+// newAccountedStream wraps a server stream so its bytes are accounted.
+func newAccountedStream(stream quic.Stream, accountant *TrafficAccountant, user, sessionID string) *accountedStream {
+	return &accountedStream{
+		Stream:     stream,
+		accountant: accountant,
+		user:       user,
+		sessionID:  sessionID,
+	}
+}
+
+// Read reads from the stream and counts the bytes as sent by the client.
+// When the user is throttled, it returns after the bytes are paid for, so
+// the client is slowed down by flow control.
+func (s *accountedStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		s.accountant.Add(s.user, s.sessionID, uint64(n), 0)
+		if throttleErr := s.accountant.throttle(s.Stream.Context(), s.user, n); throttleErr != nil && err == nil {
+			err = throttleErr
+		}
+	}
+	return n, err
+}
+
+// Write writes to the stream and counts the bytes as received by the
+// client. When the user is throttled, it waits before writing.
+func (s *accountedStream) Write(p []byte) (int, error) {
+	if err := s.accountant.throttle(s.Stream.Context(), s.user, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := s.Stream.Write(p)
+	if n > 0 {
+		s.accountant.Add(s.user, s.sessionID, 0, uint64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrafficAccountantPersistsTotals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	accountant, err := NewTrafficAccountant(path, Quota{})
+	if err != nil {
+		t.Fatalf("Failed to create accountant: %v", err)
+	}
+
+	accountant.Add("alice", "s1", 100, 1000)
+	accountant.Add("alice", "s2", 50, 0)
+	accountant.Add("bob", "s3", 1, 2)
+
+	if usage := accountant.SessionUsage("s1"); usage.Up != 100 || usage.Down != 1000 {
+		t.Errorf("Unexpected session usage %+v", usage)
+	}
+	if usage := accountant.EndSession("s2"); usage.Up != 50 {
+		t.Errorf("Unexpected ended session usage %+v", usage)
+	}
+	if usage := accountant.SessionUsage("s2"); usage.Total() != 0 {
+		t.Errorf("Expected ended session to be forgotten, got %+v", usage)
+	}
+
+	accountant.Start(time.Hour)
+	if err := accountant.Stop(); err != nil {
+		t.Fatalf("Failed to save totals: %v", err)
+	}
+
+	reloaded, err := NewTrafficAccountant(path, Quota{})
+	if err != nil {
+		t.Fatalf("Failed to reload accountant: %v", err)
+	}
+	alice := reloaded.UserUsage("alice")
+	if alice.Total.Up != 150 || alice.Total.Down != 1000 || alice.Daily.Total() != 1150 || alice.Monthly.Total() != 1150 {
+		t.Errorf("Unexpected reloaded usage %+v", alice)
+	}
+	if bob := reloaded.UserUsage("bob"); bob.Total.Total() != 3 {
+		t.Errorf("Unexpected reloaded usage %+v", bob)
+	}
+}
+
+func TestTrafficAccountantRetriesFailedSave(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	path := filepath.Join(dir, "traffic.json")
+	accountant, err := NewTrafficAccountant(path, Quota{})
+	if err != nil {
+		t.Fatalf("Failed to create accountant: %v", err)
+	}
+	accountant.Add("alice", "s1", 100, 1000)
+	accountant.path = filepath.Join(blocker, "traffic.json")
+	if err := accountant.Save(); err == nil {
+		t.Fatal("Expected saving under a file to fail")
+	}
+
+	// The totals are still unsaved and written by the next call
+	accountant.path = path
+	if err := accountant.Save(); err != nil {
+		t.Fatalf("Failed to save totals: %v", err)
+	}
+	reloaded, err := NewTrafficAccountant(path, Quota{})
+	if err != nil {
+		t.Fatalf("Failed to reload accountant: %v", err)
+	}
+	if alice := reloaded.UserUsage("alice"); alice.Total.Total() != 1100 {
+		t.Errorf("Expected the totals to be saved after the failure, got %+v", alice)
+	}
+}
+
+func TestTrafficAccountantQuotaPeriods(t *testing.T) {
+	accountant, err := NewTrafficAccountant("", Quota{Daily: 1000, Monthly: 1500})
+	if err != nil {
+		t.Fatalf("Failed to create accountant: %v", err)
+	}
+	now := time.Date(2024, 3, 30, 12, 0, 0, 0, time.Local)
+	accountant.now = func() time.Time { return now }
+
+	accountant.Add("alice", "", 600, 400)
+	err = accountant.Admit("alice")
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "daily") {
+		t.Fatalf("Expected the daily quota to be exceeded, got %v", err)
+	}
+	if err := accountant.Admit("bob"); err != nil {
+		t.Errorf("Expected other users to be admitted, got %v", err)
+	}
+
+	// The next day the daily quota starts over, but the monthly one does not
+	now = now.Add(24 * time.Hour)
+	if err := accountant.Admit("alice"); err != nil {
+		t.Errorf("Expected a new day to reset the daily quota, got %v", err)
+	}
+	accountant.Add("alice", "", 500, 0)
+	err = accountant.Admit("alice")
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "monthly") {
+		t.Fatalf("Expected the monthly quota to be exceeded, got %v", err)
+	}
+
+	now = now.Add(48 * time.Hour)
+	if err := accountant.Admit("alice"); err != nil {
+		t.Errorf("Expected a new month to reset the monthly quota, got %v", err)
+	}
+	if usage := accountant.UserUsage("alice"); usage.Month != "2024-04" || usage.Total.Total() != 1500 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	// Throttled users are admitted
+	accountant.Add("alice", "", 1000, 0)
+	accountant.SetQuota(Quota{Daily: 1000, Action: QuotaThrottle, ThrottleRate: 1000})
+	if err := accountant.Admit("alice"); err != nil {
+		t.Errorf("Expected throttled users to be admitted, got %v", err)
+	}
+	if err := accountant.CheckQuota("alice"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the quota to still be exceeded, got %v", err)
+	}
+}
+
+func TestAccountedStreamThrottles(t *testing.T) {
+	accountant, err := NewTrafficAccountant("", Quota{Daily: 100, Action: QuotaThrottle, ThrottleRate: 1000})
+	if err != nil {
+		t.Fatalf("Failed to create accountant: %v", err)
+	}
+	mock := &MockQUICStream{readData: make([]byte, 100)}
+	stream := newAccountedStream(mock, accountant, "alice", "s1")
+
+	// Under quota, nothing is throttled
+	if _, err := stream.Read(make([]byte, 100)); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+
+	// Over quota, 1500 bytes take at least half a second at 1000 bytes/s
+	start := time.Now()
+	if _, err := stream.Write(make([]byte, 1500)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the write to be throttled, took %v", elapsed)
+	}
+
+	if usage := accountant.SessionUsage("s1"); usage.Up != 100 || usage.Down != 1500 {
+		t.Errorf("Unexpected session usage %+v", usage)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := accountant.throttle(ctx, "alice", 5000); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled throttle to fail, got %v", err)
+	}
+}
+
+func TestHandshakeRejectsOverQuota(t *testing.T) {
+	accountant, err := NewTrafficAccountant("", Quota{Daily: 10})
+	if err != nil {
+		t.Fatalf("Failed to create accountant: %v", err)
+	}
+	token := []byte("alice-secret")
+	accountant.Add(clientIdentity(token, nil), "", 10, 0)
+	SetTrafficAccountant(accountant)
+	defer SetTrafficAccountant(nil)
+
+	client, server := newTestConnectionPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := performServerHandshake(ctx, server, BandwidthRates{}, nil)
+		serverErr <- err
+	}()
+
+	_, err = performClientHandshake(ctx, client, token, BandwidthRates{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "daily quota") {
+		t.Errorf("Expected the client to be rejected with the quota reason, got %v", err)
+	}
+	if err := <-serverErr; handshakeFailureReason(err) != "quota" {
+		t.Errorf("Expected a quota handshake failure, got %v", err)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4242}
+	if got := clientIdentity(nil, addr); got != "192.0.2.1" {
+		t.Errorf("Expected the host without a token, got %q", got)
+	}
+	got := clientIdentity([]byte("secret"), addr)
+	if !strings.HasPrefix(got, "token:") || strings.Contains(got, "secret") {
+		t.Errorf("Expected a hashed token identity, got %q", got)
+	}
+}
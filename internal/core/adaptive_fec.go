@@ -2,13 +2,36 @@ package core
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
+const (
+	// minXORDataShards is the smallest XOR group, used under heavy loss.
+	minXORDataShards = 2
+	// maxXORDataShards is the largest XOR group, used when loss is low.
+	maxXORDataShards = 8
+)
+
+// FECParams describes the shard layout used by an FEC encoder.
+type FECParams struct {
+	// DataShards is the number of data shards.
+	DataShards int
+	// ParityShards is the number of parity shards.
+	ParityShards int
+	// XORDataShards is the number of data shards per XOR parity shard used
+	// for latency-sensitive streams.
+	XORDataShards int
+}
+
 // AdaptiveFEC adjusts FEC parameters based on telemetry data.
+// It is safe for concurrent use: Adjust may run on the controller goroutine
+// while Encode and Decode are called from data paths.
 type AdaptiveFEC struct {
-	// fec is the underlying FEC encoder/decoder.
+	// fec is the underlying Reed-Solomon FEC encoder/decoder used for bulk data.
 	fec *FEC
+	// xor is the low-latency XOR parity codec used for interactive data.
+	xor *XORFEC
 	// k is the number of data shards.
 	k int
 	// m is the number of parity shards.
@@ -17,6 +40,14 @@ type AdaptiveFEC struct {
 	minParity int
 	// maxParity is the maximum number of parity shards.
 	maxParity int
+	// listeners are notified whenever the FEC parameters change.
+	listeners []func(old, new FECParams)
+	// metrics receives the effectiveness counters of all codecs.
+	metrics *FECMetrics
+	// events receives an event whenever the FEC parameters change.
+	events *EventBus
+	// mutex protects fec, xor, k, m, listeners, metrics and events.
+	mutex sync.RWMutex
 }
 
 // NewAdaptiveFEC creates a new AdaptiveFEC.
@@ -26,8 +57,16 @@ func NewAdaptiveFEC(k, m, minParity, maxParity int) (*AdaptiveFEC, error) {
 		return nil, fmt.Errorf("failed to create FEC: %w", err)
 	}
 	
+	xor, err := NewXORFEC(maxXORDataShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XOR FEC: %w", err)
+	}
+	
 	return &AdaptiveFEC{
 		fec:       fec,
+		xor:       xor,
+		metrics:   defaultFECMetrics,
+		events:    defaultEventBus,
 		k:         k,
 		m:         m,
 		minParity: minParity,
@@ -37,6 +76,8 @@ func NewAdaptiveFEC(k, m, minParity, maxParity int) (*AdaptiveFEC, error) {
 
 // Adjust adjusts the FEC parameters based on telemetry data using a more sophisticated algorithm.
 func (af *AdaptiveFEC) Adjust(data *TelemetryData) error {
+	af.mutex.Lock()
+	
 	// Calculate a new number of parity shards based on multiple factors:
 	// 1. Packet loss rate
 	// 2. RTT (higher RTT means more expensive retransmissions)
@@ -72,18 +113,57 @@ func (af *AdaptiveFEC) Adjust(data *TelemetryData) error {
 	// Apply smoothing to avoid rapid changes
 	newM = af.smoothAdjustment(newM)
 	
-	// If the number of parity shards has changed, create a new FEC
+	// Interactive streams use a smaller XOR group when loss is high
+	newXORDataShards := af.calculateXORDataShards(data.Loss)
+	
+	// If no parameter has changed, there is nothing to do
+	if newM == af.m && newXORDataShards == af.xor.DataShards() {
+		af.mutex.Unlock()
+		return nil
+	}
+	
+	old := af.paramsLocked()
+	
 	if newM != af.m {
-		fmt.Printf("Adjusting FEC: changing parity shards from %d to %d (lossFactor=%.2f, rttFactor=%.2f, bandwidthFactor=%.2f, efficiencyFactor=%.2f)\n", 
-			af.m, newM, lossFactor, rttFactor, bandwidthFactor, efficiencyFactor)
 		fec, err := NewFEC(af.k, newM)
 		if err != nil {
+			af.mutex.Unlock()
 			return fmt.Errorf("failed to create new FEC: %w", err)
 		}
+		fec.SetMetrics(af.metrics)
 		af.fec = fec
 		af.m = newM
 	}
 	
+	if newXORDataShards != af.xor.DataShards() {
+		xor, err := NewXORFEC(newXORDataShards)
+		if err != nil {
+			af.mutex.Unlock()
+			return fmt.Errorf("failed to create new XOR FEC: %w", err)
+		}
+		xor.SetMetrics(af.metrics)
+		af.xor = xor
+	}
+	
+	current := af.paramsLocked()
+	listeners := af.listeners
+	events := af.events
+	af.mutex.Unlock()
+	
+	events.Publish(&FECAdjustedEvent{
+		Old:              old,
+		New:              current,
+		LossFactor:       lossFactor,
+		RTTFactor:        rttFactor,
+		BandwidthFactor:  bandwidthFactor,
+		EfficiencyFactor: efficiencyFactor,
+	})
+	
+	// Notify listeners outside the lock so they may call back into AdaptiveFEC
+	for _, listener := range listeners {
+		listener(old, current)
+	}
+	
 	return nil
 }
 
@@ -190,6 +270,20 @@ func (af *AdaptiveFEC) calculateEfficiencyFactor(deliveryRate, bandwidth uint64)
 	return 2.0 - efficiency
 }
 
+// calculateXORDataShards picks how many data shards share one XOR parity
+// shard. A single XOR parity repairs one loss per group, so the group must
+// shrink as loss grows to keep the chance of two losses in a group low.
+func (af *AdaptiveFEC) calculateXORDataShards(loss float64) int {
+	switch {
+	case loss < 0.01: // Less than 1% loss
+		return maxXORDataShards
+	case loss < 0.05: // 1-5% loss
+		return maxXORDataShards / 2
+	default:
+		return minXORDataShards
+	}
+}
+
 // smoothAdjustment applies smoothing to avoid rapid changes in FEC parameters
 func (af *AdaptiveFEC) smoothAdjustment(newM int) int {
 	// Limit the change to at most 2 shards per adjustment
@@ -206,12 +300,104 @@ func (af *AdaptiveFEC) smoothAdjustment(newM int) int {
 	return newM
 }
 
+// Params returns the current FEC parameters.
+func (af *AdaptiveFEC) Params() FECParams {
+	af.mutex.RLock()
+	defer af.mutex.RUnlock()
+	return af.paramsLocked()
+}
+
+// paramsLocked returns the current FEC parameters. The caller must hold the mutex.
+func (af *AdaptiveFEC) paramsLocked() FECParams {
+	return FECParams{
+		DataShards:    af.k,
+		ParityShards:  af.m,
+		XORDataShards: af.xor.DataShards(),
+	}
+}
+
+// OnParamsChange registers a callback that is invoked after every change of
+// the FEC parameters. Callbacks run synchronously on the goroutine that
+// called Adjust, so they should return quickly.
+func (af *AdaptiveFEC) OnParamsChange(fn func(old, new FECParams)) {
+	af.mutex.Lock()
+	defer af.mutex.Unlock()
+	
+	// Copy on write so Adjust can iterate a snapshot without holding the lock
+	listeners := make([]func(old, new FECParams), len(af.listeners), len(af.listeners)+1)
+	copy(listeners, af.listeners)
+	af.listeners = append(listeners, fn)
+}
+
+// SetEventBus sets the bus parameter changes are published on.
+func (af *AdaptiveFEC) SetEventBus(events *EventBus) {
+	af.mutex.Lock()
+	defer af.mutex.Unlock()
+	af.events = events
+}
+
+// Metrics returns the metrics the codecs record into.
+func (af *AdaptiveFEC) Metrics() *FECMetrics {
+	af.mutex.RLock()
+	defer af.mutex.RUnlock()
+	return af.metrics
+}
+
+// SetMetrics makes all codecs record into the given metrics instead of the
+// process-wide default. Blocks already being encoded or decoded with the
+// previous codecs still count towards the previous metrics.
+func (af *AdaptiveFEC) SetMetrics(metrics *FECMetrics) error {
+	af.mutex.Lock()
+	defer af.mutex.Unlock()
+	
+	// Swap in fresh codecs rather than mutating ones that may be in use
+	fec, err := NewFEC(af.k, af.m)
+	if err != nil {
+		return fmt.Errorf("failed to create FEC: %w", err)
+	}
+	fec.SetMetrics(metrics)
+	
+	xor, err := NewXORFEC(af.xor.DataShards())
+	if err != nil {
+		return fmt.Errorf("failed to create XOR FEC: %w", err)
+	}
+	xor.SetMetrics(metrics)
+	
+	af.fec = fec
+	af.xor = xor
+	af.metrics = metrics
+	return nil
+}
+
+// currentFEC returns the FEC encoder/decoder for the current parameters.
+func (af *AdaptiveFEC) currentFEC() *FEC {
+	af.mutex.RLock()
+	defer af.mutex.RUnlock()
+	return af.fec
+}
+
+// CodecFor returns the codec to use for a block of the given stream type.
+// Interactive and telemetry streams get the low-latency XOR codec, bulk
+// streams get Reed-Solomon. The returned codec reflects the current
+// parameters, so it should be fetched again for every block.
+func (af *AdaptiveFEC) CodecFor(streamType uint8) FECCodec {
+	af.mutex.RLock()
+	defer af.mutex.RUnlock()
+	
+	switch streamType {
+	case StreamTypeInteractive, StreamTypeTelemetry:
+		return af.xor
+	default:
+		return af.fec
+	}
+}
+
 // Encode encodes the data using the current FEC parameters.
 func (af *AdaptiveFEC) Encode(data []byte) ([][]byte, error) {
-	return af.fec.Encode(data)
+	return af.currentFEC().Encode(data)
 }
 
 // Decode decodes the shards using the current FEC parameters.
 func (af *AdaptiveFEC) Decode(shards [][]byte) ([]byte, error) {
-	return af.fec.Decode(shards)
+	return af.currentFEC().Decode(shards)
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
@@ -184,4 +185,92 @@ func TestAdaptiveFECEncodeDecode(t *testing.T) {
 		t.Errorf("Decoded data from adjusted FEC does not match original. Got %s, expected %s", 
 			string(decodedData2), string(data))
 	}
-}
\ No newline at end of file
+}
+
+func TestAdaptiveFECParamsChangeNotification(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(10, 3, 1, 10)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+
+	initial := adaptiveFEC.Params()
+	if initial.DataShards != 10 || initial.ParityShards != 3 {
+		t.Fatalf("Unexpected initial params: %+v", initial)
+	}
+
+	var changes []FECParams
+	adaptiveFEC.OnParamsChange(func(old, new FECParams) {
+		if old != initial {
+			t.Errorf("Expected old params %+v, got %+v", initial, old)
+		}
+		changes = append(changes, new)
+	})
+
+	highLossData := &TelemetryData{
+		RTT:          50 * time.Millisecond,
+		Loss:         0.15,
+		Bandwidth:    1000000,
+		DeliveryRate: 1000000,
+		Timestamp:    time.Now(),
+	}
+	if err := adaptiveFEC.Adjust(highLossData); err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change notification, got %d", len(changes))
+	}
+	if changes[0] != adaptiveFEC.Params() {
+		t.Errorf("Notified params %+v do not match current params %+v", changes[0], adaptiveFEC.Params())
+	}
+}
+
+func TestAdaptiveFECConcurrentAdjustAndEncode(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(5, 2, 1, 5)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+
+	data := []byte("Concurrent adaptive FEC test payload.")
+	done := make(chan struct{})
+	adjusterDone := make(chan struct{})
+
+	// Keep flipping the parameters while data paths encode
+	go func() {
+		defer close(adjusterDone)
+		losses := []float64{0.3, 0.001}
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			adaptiveFEC.Adjust(&TelemetryData{Loss: losses[i%2], Bandwidth: 1000000, DeliveryRate: 1000000})
+			adaptiveFEC.Params()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				shards, err := adaptiveFEC.Encode(data)
+				if err != nil {
+					t.Errorf("Failed to encode data: %v", err)
+					return
+				}
+				if len(shards) < 5+1 || len(shards) > 5+5 {
+					t.Errorf("Unexpected shard count %d", len(shards))
+					return
+				}
+			}
+		}()
+	}
+
+	// Let the encoders finish, then stop the adjuster
+	wg.Wait()
+	close(done)
+	<-adjusterDone
+}
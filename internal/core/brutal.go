@@ -0,0 +1,77 @@
+package core
+
+import "math"
+
+// RateControlBrutal keeps the token bucket rate at the bandwidth negotiated
+// in the handshake, raised to make up for loss.
+const RateControlBrutal = "brutal"
+
+// brutalMinDeliveryRate is the lowest share of the packets sent that brutal
+// mode assumes to arrive. Loss beyond it is not made up for, so a broken
+// link is not flooded.
+const brutalMinDeliveryRate = 0.8
+
+// BandwidthRates are the rates of the two directions of a session in bytes
+// per second, from the client's point of view. A zero rate leaves that
+// direction to the rate controller.
+type BandwidthRates struct {
+	// Up is the rate from the client to the server.
+	Up uint64
+	// Down is the rate from the server to the client.
+	Down uint64
+}
+
+// negotiateBrutalRates caps the rates a client declared at the server's
+// limits. A direction the client did not declare, or the server has no
+// limit for, is not paced at a fixed rate.
+func negotiateBrutalRates(declared, limits BandwidthRates) BandwidthRates {
+	return BandwidthRates{
+		Up:   negotiateBrutalRate(declared.Up, limits.Up),
+		Down: negotiateBrutalRate(declared.Down, limits.Down),
+	}
+}
+
+// negotiateBrutalRate returns the lower of a declared rate and a limit, or
+// zero if either is zero.
+func negotiateBrutalRate(declared, limit uint64) uint64 {
+	if declared == 0 || limit == 0 {
+		return 0
+	}
+	if declared > limit {
+		return limit
+	}
+	return declared
+}
+
+// BrutalRateController keeps the rate the peer receives at the bandwidth
+// negotiated in the handshake rather than backing off on loss: it paces at
+// the rate divided by the share of packets that arrive, so the packets lost
+// are sent on top of the rate. Loss is made up for up to
+// 1-brutalMinDeliveryRate. QUIC's own congestion control still runs below
+// the rate.
+type BrutalRateController struct {
+	// Rate is the negotiated rate in bytes per second.
+	Rate float64
+}
+
+// NewBrutalRateController creates a BrutalRateController.
+func NewBrutalRateController(rate float64) *BrutalRateController {
+	return &BrutalRateController{Rate: rate}
+}
+
+// Name returns the name of the algorithm.
+func (c *BrutalRateController) Name() string {
+	return RateControlBrutal
+}
+
+// Update returns the negotiated rate raised to make up for the loss.
+func (c *BrutalRateController) Update(rate float64, data *TelemetryData) (float64, string) {
+	delivered := 1.0
+	if data != nil && data.Loss > 0 {
+		delivered = math.Max(1-data.Loss, brutalMinDeliveryRate)
+	}
+	if target := c.Rate / delivered; rate != target {
+		return target, "brutal"
+	}
+	return rate, ""
+}
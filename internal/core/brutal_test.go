@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNegotiateBrutalRates(t *testing.T) {
+	tests := []struct {
+		declared BandwidthRates
+		limits   BandwidthRates
+		want     BandwidthRates
+	}{
+		{BandwidthRates{Up: 1000, Down: 5000}, BandwidthRates{Up: 2000, Down: 2000}, BandwidthRates{Up: 1000, Down: 2000}},
+		{BandwidthRates{Up: 1000}, BandwidthRates{Up: 2000, Down: 2000}, BandwidthRates{Up: 1000}},
+		{BandwidthRates{Up: 1000, Down: 5000}, BandwidthRates{}, BandwidthRates{}},
+	}
+	for _, test := range tests {
+		if got := negotiateBrutalRates(test.declared, test.limits); got != test.want {
+			t.Errorf("negotiateBrutalRates(%+v, %+v) = %+v, want %+v", test.declared, test.limits, got, test.want)
+		}
+	}
+}
+
+func TestHandshakeNegotiatesBrutalRates(t *testing.T) {
+	client, server := newTestConnectionPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverRates := make(chan BandwidthRates, 1)
+	go func() {
+		result, err := performServerHandshake(ctx, server, BandwidthRates{Up: 2000000, Down: 8000000}, nil)
+		if err != nil {
+			t.Errorf("Server handshake failed: %v", err)
+		}
+		serverRates <- result.brutalRates
+	}()
+
+	result, err := performClientHandshake(ctx, client, nil, BandwidthRates{Up: 5000000, Down: 4000000}, nil)
+	if err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+	want := BandwidthRates{Up: 2000000, Down: 4000000}
+	if result.brutalRates != want {
+		t.Errorf("Expected the client to pace at %+v, got %+v", want, result.brutalRates)
+	}
+	if rates := <-serverRates; rates != want {
+		t.Errorf("Expected the server to agree on %+v, got %+v", want, rates)
+	}
+}
+
+func TestBrutalSessionPacing(t *testing.T) {
+	shared := NewTokenBucket(1000000, 1000000)
+	config := &Config{IsServer: true, RateLimiter: shared, RateLimitTree: NewRateLimitTree(shared)}
+
+	// A server session's node is capped at the negotiated rate within the
+	// tree, so the server's and the user's limits still apply
+	server := newSession(&MockQUICConnection{}, "s1")
+	server.brutalRates = BandwidthRates{Up: 1000, Down: 50000}
+	server.startRateLimiting(config, "alice")
+	if server.rateLimiter != nil || server.rateLimitNode == nil || server.rateLimitNode.parent.name != "alice" {
+		t.Fatal("Expected the server session to pace in the rate limit tree")
+	}
+	if server.rateLimitNode.limit != 50000 || server.rateLimitNode.Rate() != 50000 {
+		t.Errorf("Expected the session node to be capped at the download rate, got limit %v and rate %v", server.rateLimitNode.limit, server.rateLimitNode.Rate())
+	}
+	if shared.GetRate() != 1000000 {
+		t.Errorf("Expected the shared rate to be unchanged, got %v", shared.GetRate())
+	}
+
+	// A lower session limit still applies to brutal sessions
+	config.RateLimitPolicy = RateLimitPolicy{SessionRate: 20000}
+	capped := newSession(&MockQUICConnection{}, "s2")
+	capped.brutalRates = BandwidthRates{Up: 1000, Down: 50000}
+	capped.startRateLimiting(config, "bob")
+	if capped.rateLimitNode == nil || capped.rateLimitNode.limit != 20000 {
+		t.Errorf("Expected the session limit to cap the brutal session")
+	}
+
+	// A client sets its own bucket to the upload rate
+	config.IsServer = false
+	client := newSession(&MockQUICConnection{}, "s1")
+	client.brutalRates = BandwidthRates{Up: 1000, Down: 50000}
+	client.startRateLimiting(config, "")
+	if shared.GetRate() != 1000 || client.rateLimitNode == nil {
+		t.Errorf("Expected the client to pace its bucket at the upload rate, got %v", shared.GetRate())
+	}
+}
+
+func TestBrutalRateControllerMakesUpForLoss(t *testing.T) {
+	controller := NewBrutalRateController(50000)
+
+	if rate, reason := controller.Update(1000, &TelemetryData{}); rate != 50000 || reason != "brutal" {
+		t.Errorf("Expected the negotiated rate without loss, got %v %q", rate, reason)
+	}
+	if rate, reason := controller.Update(50000, &TelemetryData{}); rate != 50000 || reason != "" {
+		t.Errorf("Expected the rate to hold without loss, got %v %q", rate, reason)
+	}
+
+	// Lost packets are sent on top of the rate
+	rate, reason := controller.Update(50000, &TelemetryData{Loss: 0.1})
+	if want := 50000 / 0.9; math.Abs(rate-want) > 1e-6 || reason != "brutal" {
+		t.Errorf("Expected the rate to rise to %v under 10%% loss, got %v %q", want, rate, reason)
+	}
+	// Heavy loss is only made up for up to the cap
+	if rate, _ := controller.Update(rate, &TelemetryData{Loss: 0.6}); rate != 50000/brutalMinDeliveryRate {
+		t.Errorf("Expected the rate to be capped at %v, got %v", 50000/brutalMinDeliveryRate, rate)
+	}
+}
+
+func TestBrutalSessionMakesUpForLoss(t *testing.T) {
+	shared := NewTokenBucket(1000000, 1000000)
+	config := &Config{IsServer: true, RateLimiter: shared, RateLimitTree: NewRateLimitTree(shared)}
+	server := newSession(&MockQUICConnection{}, "s1")
+	server.brutalRates = BandwidthRates{Down: 50000}
+	server.startRateLimiting(config, "alice")
+	defer server.stopRateLimiting()
+
+	server.compensateBrutalLoss(&TelemetryData{Loss: 0.2})
+	if want := 50000 / 0.8; math.Abs(server.rateLimitNode.limit-want) > 1e-6 {
+		t.Errorf("Expected the session node to be raised to %v under 20%% loss, got %v", want, server.rateLimitNode.limit)
+	}
+	server.compensateBrutalLoss(&TelemetryData{})
+	if server.rateLimitNode.limit != 50000 {
+		t.Errorf("Expected the session node back at the rate without loss, got %v", server.rateLimitNode.limit)
+	}
+}
+
+func TestBrutalSessionsShareTheBucketWithoutTree(t *testing.T) {
+	shared := NewTokenBucket(1000000, 1000000)
+	config := &Config{IsServer: true, RateLimiter: shared, RateLimitPolicy: RateLimitPolicy{UserRate: 30000}}
+
+	// Sessions of one user share the user's node, so the user limit holds
+	// across them
+	first := newSession(&MockQUICConnection{}, "s1")
+	first.brutalRates = BandwidthRates{Down: 50000}
+	first.startRateLimiting(config, "carol")
+	defer first.stopRateLimiting()
+	second := newSession(&MockQUICConnection{}, "s2")
+	second.brutalRates = BandwidthRates{Down: 50000}
+	second.startRateLimiting(config, "carol")
+	defer second.stopRateLimiting()
+
+	if first.rateLimitNode == nil || second.rateLimitNode == nil {
+		t.Fatal("Expected the brutal sessions to pace in a rate limit tree")
+	}
+	user := first.rateLimitNode.parent
+	if second.rateLimitNode.parent != user || user.limit != 30000 {
+		t.Errorf("Expected both sessions below one user node limited to 30000")
+	}
+	if root := user.root(); root.bucket != shared {
+		t.Errorf("Expected the tree to draw from the shared bucket")
+	}
+}
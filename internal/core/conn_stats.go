@@ -0,0 +1,232 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// ConnectionStats is a point-in-time snapshot of the statistics of a QUIC
+// connection, as observed by its connection tracer.
+type ConnectionStats struct {
+	// SmoothedRTT is the smoothed round-trip time. It is zero until the
+	// first RTT sample has been taken.
+	SmoothedRTT time.Duration
+	// LatestRTT is the most recent RTT sample.
+	LatestRTT time.Duration
+	// MinRTT is the smallest RTT sample seen on the connection.
+	MinRTT time.Duration
+	// CongestionWindow is the congestion window in bytes.
+	CongestionWindow uint64
+	// BytesInFlight is the number of sent bytes not yet acknowledged or lost.
+	BytesInFlight uint64
+	// PacketsSent is the total number of packets sent.
+	PacketsSent uint64
+	// BytesSent is the total number of bytes sent.
+	BytesSent uint64
+	// PacketsLost is the total number of packets declared lost.
+	PacketsLost uint64
+	// BytesAcked is the total number of 1-RTT bytes acknowledged by the peer.
+	BytesAcked uint64
+	// PacketsReceived is the total number of 1-RTT packets received.
+	PacketsReceived uint64
+	// PacketsMissing is the number of gaps in the sequence of received 1-RTT
+	// packet numbers, i.e. packets the peer sent that have not arrived.
+	// Packet numbers the peer skips on purpose are counted too, so this
+	// slightly overestimates loss.
+	PacketsMissing uint64
+	// DeliveryRate is the most recent delivery rate sample in bytes per
+	// second, or zero if there is none.
+	DeliveryRate uint64
+	// Bandwidth is the estimated bandwidth in bytes per second: the highest
+	// recent delivery rate. It is zero until the first sample.
+	Bandwidth uint64
+}
+
+// ConnectionStatsTracker accumulates the statistics of a single QUIC
+// connection from the events of a logging.ConnectionTracer. It is safe for
+// concurrent use.
+type ConnectionStatsTracker struct {
+	// stats holds the accumulated statistics.
+	stats ConnectionStats
+	// sentPackets maps unacknowledged ack-eliciting 1-RTT packet numbers
+	// to their size, so acknowledged bytes can be counted. Packets that
+	// only carry ACK or CONNECTION_CLOSE frames are never acknowledged or
+	// declared lost, so they are not recorded.
+	sentPackets map[logging.PacketNumber]logging.ByteCount
+	// highestReceived is the highest 1-RTT packet number received.
+	highestReceived logging.PacketNumber
+	// delivery estimates the bandwidth from the acknowledged bytes.
+	delivery *DeliveryRateEstimator
+	// mutex protects stats, sentPackets and highestReceived.
+	mutex sync.Mutex
+}
+
+// NewConnectionStatsTracker creates a new ConnectionStatsTracker.
+func NewConnectionStatsTracker() *ConnectionStatsTracker {
+	return &ConnectionStatsTracker{
+		sentPackets:     make(map[logging.PacketNumber]logging.ByteCount),
+		highestReceived: -1,
+		delivery:        NewDeliveryRateEstimator(deliveryRateFilterWindow),
+	}
+}
+
+// Tracer returns a connection tracer that feeds this tracker.
+func (ct *ConnectionStatsTracker) Tracer() *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(_ *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+			ct.onPacketSent(size)
+		},
+		SentShortHeaderPacket: func(hdr *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, frames []logging.Frame) {
+			ct.onPacketSent(size)
+			if !ackEliciting(frames) {
+				return
+			}
+			ct.mutex.Lock()
+			ct.sentPackets[hdr.PacketNumber] = size
+			ct.mutex.Unlock()
+		},
+		ReceivedShortHeaderPacket: func(hdr *logging.ShortHeader, _ logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+			ct.onPacketReceived(hdr.PacketNumber)
+		},
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, _ int) {
+			ct.mutex.Lock()
+			ct.stats.SmoothedRTT = rttStats.SmoothedRTT()
+			ct.stats.LatestRTT = rttStats.LatestRTT()
+			ct.stats.MinRTT = rttStats.MinRTT()
+			ct.stats.CongestionWindow = uint64(cwnd)
+			ct.stats.BytesInFlight = uint64(bytesInFlight)
+			ct.mutex.Unlock()
+		},
+		AcknowledgedPacket: func(level logging.EncryptionLevel, pn logging.PacketNumber) {
+			if level != logging.Encryption1RTT {
+				return
+			}
+			ct.mutex.Lock()
+			if size, ok := ct.sentPackets[pn]; ok {
+				ct.stats.BytesAcked += uint64(size)
+				delete(ct.sentPackets, pn)
+				ct.delivery.OnAck(uint64(size), time.Now(), ct.stats.SmoothedRTT)
+			}
+			ct.mutex.Unlock()
+		},
+		LostPacket: func(level logging.EncryptionLevel, pn logging.PacketNumber, _ logging.PacketLossReason) {
+			ct.mutex.Lock()
+			ct.stats.PacketsLost++
+			if level == logging.Encryption1RTT {
+				delete(ct.sentPackets, pn)
+			}
+			ct.mutex.Unlock()
+		},
+	}
+}
+
+// ackEliciting reports whether a packet with the given frames (not counting
+// its ACK frame, which the tracer reports separately) must be acknowledged.
+func ackEliciting(frames []logging.Frame) bool {
+	for _, frame := range frames {
+		switch frame.(type) {
+		case *logging.AckFrame, *logging.ConnectionCloseFrame:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// onPacketSent records a sent packet of the given size.
+func (ct *ConnectionStatsTracker) onPacketSent(size logging.ByteCount) {
+	ct.mutex.Lock()
+	ct.stats.PacketsSent++
+	ct.stats.BytesSent += uint64(size)
+	ct.mutex.Unlock()
+}
+
+// onPacketReceived records a received 1-RTT packet. A packet number beyond
+// the next expected one leaves a gap of missing packets; a packet that
+// arrives late fills one.
+func (ct *ConnectionStatsTracker) onPacketReceived(pn logging.PacketNumber) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	ct.stats.PacketsReceived++
+	switch {
+	case pn > ct.highestReceived:
+		ct.stats.PacketsMissing += uint64(pn - ct.highestReceived - 1)
+		ct.highestReceived = pn
+	case ct.stats.PacketsMissing > 0:
+		ct.stats.PacketsMissing--
+	}
+}
+
+// Stats returns a snapshot of the connection statistics.
+func (ct *ConnectionStatsTracker) Stats() ConnectionStats {
+	ct.mutex.Lock()
+	stats := ct.stats
+	ct.mutex.Unlock()
+
+	now := time.Now()
+	stats.DeliveryRate = ct.delivery.DeliveryRate(now)
+	stats.Bandwidth = ct.delivery.Bandwidth(now)
+	return stats
+}
+
+// connStatsTrackers maps quic-go connection tracing IDs to the stats
+// tracker of the connection.
+var connStatsTrackers sync.Map
+
+// newQUICConfig returns the QUIC configuration used for all VANTUN
+// connections. It installs a tracer that records connection statistics and,
+// if enabled, exports a qlog trace.
+func newQUICConfig() *quic.Config {
+	return &quic.Config{
+		Tracer:          newConnectionTracer,
+		EnableDatagrams: true,
+	}
+}
+
+// newConnectionTracer creates the tracer for a new connection.
+func newConnectionTracer(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+	tracer := newConnectionStatsTracer(ctx, p, connID)
+	if exporter := qlogExporter.Load(); exporter != nil {
+		tracer = logging.NewMultiplexedConnectionTracer(tracer, exporter.Tracer(p, connID))
+	}
+	return tracer
+}
+
+// newConnectionStatsTracer creates a stats tracker for a new connection and
+// registers it under the connection's tracing ID.
+func newConnectionStatsTracer(ctx context.Context, _ logging.Perspective, _ quic.ConnectionID) *logging.ConnectionTracer {
+	tracker := NewConnectionStatsTracker()
+	tracer := tracker.Tracer()
+
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if ok {
+		connStatsTrackers.Store(id, tracker)
+		tracer.Close = func() {
+			connStatsTrackers.Delete(id)
+		}
+	}
+
+	return tracer
+}
+
+// ConnectionStatsFor returns the stats tracker of a connection created with
+// the VANTUN QUIC configuration, or nil if the connection is not traced.
+func ConnectionStatsFor(conn quic.Connection) *ConnectionStatsTracker {
+	if conn == nil {
+		return nil
+	}
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	tracker, ok := connStatsTrackers.Load(id)
+	if !ok {
+		return nil
+	}
+	return tracker.(*ConnectionStatsTracker)
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+func TestConnectionStatsTrackerEvents(t *testing.T) {
+	tracker := NewConnectionStatsTracker()
+	tracer := tracker.Tracer()
+
+	// Send ten 1-RTT packets of 1000 bytes
+	frames := []logging.Frame{&logging.StreamFrame{Length: 950}}
+	for pn := logging.PacketNumber(0); pn < 10; pn++ {
+		tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: pn}, 1000, logging.ECNUnsupported, nil, frames)
+	}
+
+	// Acknowledge eight of them and lose two
+	for pn := logging.PacketNumber(0); pn < 8; pn++ {
+		tracer.AcknowledgedPacket(logging.Encryption1RTT, pn)
+	}
+	tracer.LostPacket(logging.Encryption1RTT, 8, logging.PacketLossReorderingThreshold)
+	tracer.LostPacket(logging.Encryption1RTT, 9, logging.PacketLossTimeThreshold)
+
+	// A duplicate acknowledgement must not be counted twice
+	tracer.AcknowledgedPacket(logging.Encryption1RTT, 0)
+
+	rttStats := &logging.RTTStats{}
+	rttStats.UpdateRTT(40*time.Millisecond, 0, time.Now())
+	tracer.UpdatedMetrics(rttStats, 20000, 3000, 3)
+
+	stats := tracker.Stats()
+	if stats.PacketsSent != 10 || stats.BytesSent != 10000 {
+		t.Errorf("Unexpected sent counters: packets=%d bytes=%d", stats.PacketsSent, stats.BytesSent)
+	}
+	if stats.PacketsLost != 2 {
+		t.Errorf("Expected 2 lost packets, got %d", stats.PacketsLost)
+	}
+	if stats.BytesAcked != 8000 {
+		t.Errorf("Expected 8000 bytes acknowledged, got %d", stats.BytesAcked)
+	}
+	if stats.SmoothedRTT != 40*time.Millisecond || stats.MinRTT != 40*time.Millisecond {
+		t.Errorf("Unexpected RTT: smoothed=%v min=%v", stats.SmoothedRTT, stats.MinRTT)
+	}
+	if stats.CongestionWindow != 20000 || stats.BytesInFlight != 3000 {
+		t.Errorf("Unexpected cwnd=%d inFlight=%d", stats.CongestionWindow, stats.BytesInFlight)
+	}
+
+	// The collector computes loss over the interval
+	collector := newTelemetryCollector(nil, tracker)
+	collector.lastTime = time.Now().Add(-time.Second)
+	data := collector.Collect()
+	if data.Loss != 0.2 {
+		t.Errorf("Expected 20%% loss, got %f", data.Loss)
+	}
+	if data.RTT != 40*time.Millisecond {
+		t.Errorf("Expected RTT of 40ms, got %v", data.RTT)
+	}
+	if data.DeliveryRate < 7000 || data.DeliveryRate > 8000 {
+		t.Errorf("Expected a delivery rate of about 8000 B/s, got %d", data.DeliveryRate)
+	}
+	if data.Bandwidth != 500000 {
+		t.Errorf("Expected bandwidth of 500000 B/s, got %d", data.Bandwidth)
+	}
+
+	// Gaps in the received packet numbers are reported as receive loss
+	for _, pn := range []logging.PacketNumber{0, 1, 3, 4, 2, 7} {
+		tracer.ReceivedShortHeaderPacket(&logging.ShortHeader{PacketNumber: pn}, 1200, logging.ECNUnsupported, nil)
+	}
+	if stats := tracker.Stats(); stats.PacketsReceived != 6 || stats.PacketsMissing != 2 {
+		t.Errorf("Expected 6 received and 2 missing packets, got %d and %d", stats.PacketsReceived, stats.PacketsMissing)
+	}
+	data = collector.Collect()
+	if data.ReceiveLoss != 0.25 {
+		t.Errorf("Expected 25%% receive loss, got %f", data.ReceiveLoss)
+	}
+
+	// Without new packets the next interval reports no loss
+	data = collector.Collect()
+	if data.Loss != 0 || data.ReceiveLoss != 0 {
+		t.Errorf("Expected no loss in an idle interval, got %f and %f", data.Loss, data.ReceiveLoss)
+	}
+}
+
+// newTestConnectionPair returns a client and a server QUIC connection
+// created with the VANTUN QUIC configuration. Both are closed when the test
+// ends.
+func newTestConnectionPair(t *testing.T) (quic.Connection, quic.Connection) {
+	t.Helper()
+
+	cert, key, err := generateTestCert()
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	tlsCert, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+
+	listener, err := quic.ListenAddr("localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"vantun"},
+	}, newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan quic.Connection, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := quic.DialAddr(ctx, listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"vantun"},
+	}, newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.CloseWithError(0, "") })
+
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Failed to accept connection")
+	}
+	t.Cleanup(func() { server.CloseWithError(0, "") })
+
+	return client, server
+}
+
+func TestConnectionStatsTrackerSkipsAckOnlyPackets(t *testing.T) {
+	tracker := NewConnectionStatsTracker()
+	tracer := tracker.Tracer()
+
+	// Packets that only acknowledge are never acknowledged themselves
+	ack := &logging.AckFrame{AckRanges: []logging.AckRange{{Smallest: 0, Largest: 10}}}
+	for pn := logging.PacketNumber(0); pn < 1000; pn++ {
+		tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: pn}, 40, logging.ECNUnsupported, ack, nil)
+	}
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 1000}, 40, logging.ECNUnsupported, nil, []logging.Frame{&logging.ConnectionCloseFrame{}})
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 1001}, 1200, logging.ECNUnsupported, ack, []logging.Frame{&logging.StreamFrame{Length: 1100}})
+
+	tracker.mutex.Lock()
+	tracked := len(tracker.sentPackets)
+	tracker.mutex.Unlock()
+	if tracked != 1 {
+		t.Errorf("Expected only the ack-eliciting packet to be tracked, got %d", tracked)
+	}
+	if stats := tracker.Stats(); stats.PacketsSent != 1002 {
+		t.Errorf("Expected all 1002 packets to be counted as sent, got %d", stats.PacketsSent)
+	}
+}
+
+func TestConnectionStatsOverQUIC(t *testing.T) {
+	conn, server := newTestConnectionPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Echo a single stream
+	go func() {
+		stream, err := server.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+
+	tracker := ConnectionStatsFor(conn)
+	if tracker == nil {
+		t.Fatal("Expected the connection to be traced")
+	}
+	collector := NewTelemetryCollector(conn)
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	payload := make([]byte, 64*1024)
+	if _, err := stream.Write(payload); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	stream.Close()
+	if _, err := io.ReadFull(stream, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	data := collector.Collect()
+	if data.RTT <= 0 {
+		t.Errorf("Expected a measured RTT, got %v", data.RTT)
+	}
+	if data.CongestionWindow == 0 {
+		t.Error("Expected a congestion window")
+	}
+	if data.DeliveryRate == 0 {
+		t.Error("Expected a delivery rate")
+	}
+	if stats := tracker.Stats(); stats.BytesSent < uint64(len(payload)) {
+		t.Errorf("Expected at least %d bytes sent, got %d", len(payload), stats.BytesSent)
+	}
+
+	if ConnectionStatsFor(&MockQUICConnection{}) != nil {
+		t.Error("Expected no stats for an untraced connection")
+	}
+}
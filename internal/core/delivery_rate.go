@@ -0,0 +1,123 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// deliveryRateMinInterval is the shortest interval a delivery rate
+	// sample is taken over. Samples span at least one RTT as well, so
+	// bursts of acknowledgements do not inflate them.
+	deliveryRateMinInterval = 100 * time.Millisecond
+	// deliveryRateFilterWindow is how long a delivery rate sample counts
+	// towards the bandwidth estimate.
+	deliveryRateFilterWindow = 10 * time.Second
+)
+
+// deliveryRateSample is a delivery rate measured over one interval.
+type deliveryRateSample struct {
+	// rate is the delivery rate in bytes per second.
+	rate float64
+	// time is when the interval ended.
+	time time.Time
+}
+
+// DeliveryRateEstimator estimates the bandwidth of a connection from the
+// bytes the peer acknowledges. It measures the delivery rate over
+// intervals of at least one RTT and estimates the bandwidth as the highest
+// rate of the recent intervals, so periods where the sender has little to
+// send do not lower the estimate. It is safe for concurrent use.
+type DeliveryRateEstimator struct {
+	// window is how long a sample counts towards the bandwidth estimate.
+	window time.Duration
+	// intervalStart is when the current interval started, or zero before
+	// the first acknowledgement.
+	intervalStart time.Time
+	// intervalBytes is the number of bytes acknowledged in the current
+	// interval.
+	intervalBytes uint64
+	// lastAck is when the last acknowledgement arrived.
+	lastAck time.Time
+	// samples holds the samples of the window, oldest first.
+	samples []deliveryRateSample
+	// mutex protects the fields above.
+	mutex sync.Mutex
+}
+
+// NewDeliveryRateEstimator creates a DeliveryRateEstimator whose bandwidth
+// estimate is the highest delivery rate within window.
+func NewDeliveryRateEstimator(window time.Duration) *DeliveryRateEstimator {
+	return &DeliveryRateEstimator{window: window}
+}
+
+// OnAck records that bytes were acknowledged at now. rtt is the current
+// smoothed RTT, or zero if it is not known yet.
+func (e *DeliveryRateEstimator) OnAck(bytes uint64, now time.Time, rtt time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	interval := deliveryRateMinInterval
+	if rtt > interval {
+		interval = rtt
+	}
+
+	// Start over after an idle period, which would otherwise be counted
+	// as slow delivery
+	if e.intervalStart.IsZero() || now.Sub(e.lastAck) > interval {
+		e.intervalStart = now
+		e.intervalBytes = 0
+	} else {
+		e.intervalBytes += bytes
+	}
+	e.lastAck = now
+
+	if elapsed := now.Sub(e.intervalStart); elapsed >= interval {
+		e.samples = append(e.samples, deliveryRateSample{
+			rate: float64(e.intervalBytes) / elapsed.Seconds(),
+			time: now,
+		})
+		e.intervalStart = now
+		e.intervalBytes = 0
+	}
+	e.expire(now)
+}
+
+// DeliveryRate returns the most recent delivery rate sample in bytes per
+// second, or zero if there is none within the window.
+func (e *DeliveryRateEstimator) DeliveryRate(now time.Time) uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expire(now)
+	if len(e.samples) == 0 {
+		return 0
+	}
+	return uint64(e.samples[len(e.samples)-1].rate)
+}
+
+// Bandwidth returns the highest delivery rate within the window in bytes
+// per second, or zero if there is no sample.
+func (e *DeliveryRateEstimator) Bandwidth(now time.Time) uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expire(now)
+	var bandwidth float64
+	for _, sample := range e.samples {
+		if sample.rate > bandwidth {
+			bandwidth = sample.rate
+		}
+	}
+	return uint64(bandwidth)
+}
+
+// expire drops the samples older than the window. The caller must hold the
+// mutex.
+func (e *DeliveryRateEstimator) expire(now time.Time) {
+	drop := 0
+	for drop < len(e.samples) && now.Sub(e.samples[drop].time) > e.window {
+		drop++
+	}
+	e.samples = e.samples[drop:]
+}
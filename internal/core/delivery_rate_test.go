@@ -0,0 +1,83 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryRateEstimator(t *testing.T) {
+	estimator := NewDeliveryRateEstimator(10 * time.Second)
+	now := time.Now()
+	ack := func(bytes uint64, count int, every time.Duration) {
+		for i := 0; i < count; i++ {
+			now = now.Add(every)
+			estimator.OnAck(bytes, now, 20*time.Millisecond)
+		}
+	}
+
+	if estimator.Bandwidth(now) != 0 || estimator.DeliveryRate(now) != 0 {
+		t.Fatal("Expected no estimate before the first acknowledgement")
+	}
+
+	// 1000 bytes every 10ms is 100000 bytes/s
+	ack(1000, 100, 10*time.Millisecond)
+	if rate := estimator.DeliveryRate(now); rate < 95000 || rate > 105000 {
+		t.Errorf("Expected a delivery rate of about 100000 B/s, got %d", rate)
+	}
+
+	// Sending less lowers the delivery rate but not the bandwidth
+	ack(1000, 50, 50*time.Millisecond)
+	if rate := estimator.DeliveryRate(now); rate < 19000 || rate > 21000 {
+		t.Errorf("Expected a delivery rate of about 20000 B/s, got %d", rate)
+	}
+	if bandwidth := estimator.Bandwidth(now); bandwidth < 95000 || bandwidth > 105000 {
+		t.Errorf("Expected the bandwidth to stay at about 100000 B/s, got %d", bandwidth)
+	}
+
+	// An idle period is not a slow sample
+	now = now.Add(time.Second)
+	ack(1000, 20, 10*time.Millisecond)
+	if rate := estimator.DeliveryRate(now); rate < 95000 {
+		t.Errorf("Expected the idle period to be skipped, got %d B/s", rate)
+	}
+
+	// Old samples expire
+	now = now.Add(11 * time.Second)
+	if estimator.Bandwidth(now) != 0 {
+		t.Errorf("Expected the samples to expire, got %d B/s", estimator.Bandwidth(now))
+	}
+}
+
+func TestDeliveryRateEstimatorIntervalSpansRTT(t *testing.T) {
+	estimator := NewDeliveryRateEstimator(10 * time.Second)
+	now := time.Now()
+
+	// With a 500ms RTT, acknowledgements 100ms apart are one interval
+	for i := 0; i < 5; i++ {
+		now = now.Add(100 * time.Millisecond)
+		estimator.OnAck(10000, now, 500*time.Millisecond)
+	}
+	if estimator.Bandwidth(now) != 0 {
+		t.Errorf("Expected no sample before a full RTT, got %d B/s", estimator.Bandwidth(now))
+	}
+	now = now.Add(100 * time.Millisecond)
+	estimator.OnAck(10000, now, 500*time.Millisecond)
+	if bandwidth := estimator.Bandwidth(now); bandwidth != 100000 {
+		t.Errorf("Expected 100000 B/s over the RTT, got %d", bandwidth)
+	}
+}
+
+func TestTelemetryBandwidthFromDeliveryRate(t *testing.T) {
+	tracker := NewConnectionStatsTracker()
+	now := time.Now().Add(-time.Second)
+	for i := 0; i < 50; i++ {
+		now = now.Add(10 * time.Millisecond)
+		tracker.delivery.OnAck(2000, now, 0)
+	}
+
+	collector := newTelemetryCollector(nil, tracker)
+	data := collector.Collect()
+	if data.Bandwidth < 190000 || data.Bandwidth > 210000 {
+		t.Errorf("Expected a bandwidth of about 200000 B/s, got %d", data.Bandwidth)
+	}
+}
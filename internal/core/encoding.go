@@ -9,6 +9,14 @@ import (
 	"github.com/fxamacker/cbor/v2"
 )
 
+// maxMessageSize is the maximum size of an encoded message.
+const maxMessageSize = 1024 * 1024
+
+// maxTelemetryMessageSize is the maximum size of an encoded Telemetry
+// message. Reports are a few hundred bytes, so anything larger is rejected
+// before it is allocated.
+const maxTelemetryMessageSize = 16 * 1024
+
 // lengthBufPool is a pool of byte slices used for length prefixes to reduce memory allocations
 var lengthBufPool = sync.Pool{
 	New: func() interface{} {
@@ -71,6 +79,94 @@ func DecodeStreamType(data []byte) (*StreamTypePayload, error) {
 	return &payload, nil
 }
 
+// EncodeMultipathData encodes a MultipathDataPayload into a CBOR byte slice.
+func EncodeMultipathData(payload *MultipathDataPayload) ([]byte, error) {
+	data, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal MultipathData payload: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeMultipathData decodes a CBOR byte slice into a MultipathDataPayload.
+func DecodeMultipathData(data []byte) (*MultipathDataPayload, error) {
+	var payload MultipathDataPayload
+	if err := cbor.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal MultipathData payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// EncodeMultipathAck encodes a MultipathAckPayload into a CBOR byte slice.
+func EncodeMultipathAck(payload *MultipathAckPayload) ([]byte, error) {
+	data, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal MultipathAck payload: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeMultipathAck decodes a CBOR byte slice into a MultipathAckPayload.
+func DecodeMultipathAck(data []byte) (*MultipathAckPayload, error) {
+	var payload MultipathAckPayload
+	if err := cbor.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal MultipathAck payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// EncodeTelemetry encodes a TelemetryPayload into a CBOR byte slice.
+func EncodeTelemetry(payload *TelemetryPayload) ([]byte, error) {
+	data, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Telemetry payload: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeTelemetry decodes a CBOR byte slice into a TelemetryPayload.
+// Payloads of an unsupported version, without data, or with values out of
+// range are rejected.
+func DecodeTelemetry(data []byte) (*TelemetryPayload, error) {
+	var payload TelemetryPayload
+	if err := cbor.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Telemetry payload: %w", err)
+	}
+	if payload.Version != TelemetryVersion {
+		return nil, fmt.Errorf("unsupported telemetry version: %d", payload.Version)
+	}
+	if payload.Data == nil {
+		return nil, fmt.Errorf("telemetry payload has no data")
+	}
+	if err := validateTelemetryData(payload.Data); err != nil {
+		return nil, err
+	}
+	return &payload, nil
+}
+
+// validateTelemetryData checks that telemetry received from the peer is
+// within the ranges the local controllers can act on.
+func validateTelemetryData(data *TelemetryData) error {
+	if data.RTT < 0 {
+		return fmt.Errorf("invalid telemetry RTT: %v", data.RTT)
+	}
+	if !isRatio(data.Loss) {
+		return fmt.Errorf("invalid telemetry loss: %v", data.Loss)
+	}
+	if !isRatio(data.ReceiveLoss) {
+		return fmt.Errorf("invalid telemetry receive loss: %v", data.ReceiveLoss)
+	}
+	if data.FEC != nil && data.FEC.AvgReconstructionLatency < 0 {
+		return fmt.Errorf("invalid telemetry FEC reconstruction latency: %v", data.FEC.AvgReconstructionLatency)
+	}
+	return nil
+}
+
+// isRatio reports whether v is a number between 0 and 1.
+func isRatio(v float64) bool {
+	return v >= 0 && v <= 1
+}
+
 // WriteMessage writes a message with a length prefix
 func WriteMessage(stream io.Writer, msg *Message) error {
 	data, err := cbor.Marshal(msg)
@@ -100,6 +196,12 @@ func WriteMessage(stream io.Writer, msg *Message) error {
 
 // ReadMessage reads a message with a length prefix
 func ReadMessage(stream io.Reader) (*Message, error) {
+	return readMessage(stream, maxMessageSize)
+}
+
+// readMessage reads a message with a length prefix, rejecting messages
+// larger than maxSize bytes.
+func readMessage(stream io.Reader, maxSize uint32) (*Message, error) {
 	// Get length buffer from pool
 	lengthBuf := lengthBufPool.Get().([]byte)
 	defer lengthBufPool.Put(lengthBuf)
@@ -112,7 +214,7 @@ func ReadMessage(stream io.Reader) (*Message, error) {
 	length := binary.BigEndian.Uint32(lengthBuf)
 	
 	// Validate length to prevent excessive memory allocation
-	if length > 1024*1024 { // 1MB limit
+	if length > maxSize {
 		return nil, fmt.Errorf("message too large: %d bytes", length)
 	}
 	
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Event is a typed event emitted by a VANTUN component. Subscribers can
+// switch on the concrete type to read its fields, or use Kind, Level and
+// Fields to handle events generically.
+type Event interface {
+	// Kind returns the name of the event, e.g. "telemetry.reported".
+	Kind() string
+	// Level returns the level the event is logged at.
+	Level() LogLevel
+	// Fields returns the fields of the event as ordered key/value pairs.
+	Fields() []EventField
+}
+
+// EventField is a key/value pair of an event.
+type EventField struct {
+	// Key is the name of the field.
+	Key string
+	// Value is the value of the field.
+	Value interface{}
+}
+
+// TelemetryReportedEvent is emitted when a telemetry report has been sent
+// to the peer.
+type TelemetryReportedEvent struct {
+	// Data is the reported telemetry.
+	Data *TelemetryData
+}
+
+// Kind returns the name of the event.
+func (e *TelemetryReportedEvent) Kind() string { return "telemetry.reported" }
+
+// Level returns the level the event is logged at.
+func (e *TelemetryReportedEvent) Level() LogLevel { return DebugLevel }
+
+// Fields returns the fields of the event.
+func (e *TelemetryReportedEvent) Fields() []EventField {
+	fields := []EventField{
+		{"rtt", e.Data.RTT},
+		{"loss", e.Data.Loss},
+		{"receive_loss", e.Data.ReceiveLoss},
+		{"bandwidth", e.Data.Bandwidth},
+		{"cwnd", e.Data.CongestionWindow},
+		{"in_flight", e.Data.BytesInFlight},
+		{"delivery_rate", e.Data.DeliveryRate},
+	}
+	if fec := e.Data.FEC; fec != nil {
+		fields = append(fields,
+			EventField{"fec_encoded", fec.BlocksEncoded},
+			EventField{"fec_decoded", fec.BlocksDecoded},
+			EventField{"fec_recovered", fec.BlocksRecovered},
+			EventField{"fec_unrecoverable", fec.BlocksUnrecoverable},
+			EventField{"fec_overhead", fec.Overhead()},
+			EventField{"fec_avg_reconstruction", fec.AvgReconstructionLatency},
+		)
+	}
+	if e.Data.SessionID != "" {
+		fields = append(fields, EventField{"session_id", e.Data.SessionID})
+	}
+	return fields
+}
+
+// TelemetryReportFailedEvent is emitted when a telemetry report could not
+// be sent.
+type TelemetryReportFailedEvent struct {
+	// Err is the error returned by the reporter.
+	Err error
+}
+
+// Kind returns the name of the event.
+func (e *TelemetryReportFailedEvent) Kind() string { return "telemetry.report_failed" }
+
+// Level returns the level the event is logged at.
+func (e *TelemetryReportFailedEvent) Level() LogLevel { return WarnLevel }
+
+// Fields returns the fields of the event.
+func (e *TelemetryReportFailedEvent) Fields() []EventField {
+	return []EventField{{"error", e.Err}}
+}
+
+// RateChangedEvent is emitted when the token bucket controller changes the
+// sending rate.
+type RateChangedEvent struct {
+	// OldRate is the previous rate in bytes per second.
+	OldRate float64
+	// NewRate is the new rate in bytes per second.
+	NewRate float64
+	// Loss is the loss rate the decision was based on.
+	Loss float64
+	// Reason describes why the rate changed, e.g. "high_loss".
+	Reason string
+	// Algorithm is the name of the rate controller that changed the rate.
+	Algorithm string
+}
+
+// Kind returns the name of the event.
+func (e *RateChangedEvent) Kind() string { return "rate.changed" }
+
+// Level returns the level the event is logged at.
+func (e *RateChangedEvent) Level() LogLevel { return DebugLevel }
+
+// Fields returns the fields of the event.
+func (e *RateChangedEvent) Fields() []EventField {
+	fields := []EventField{
+		{"old_rate", e.OldRate},
+		{"new_rate", e.NewRate},
+		{"loss", e.Loss},
+		{"reason", e.Reason},
+	}
+	if e.Algorithm != "" {
+		fields = append(fields, EventField{"algorithm", e.Algorithm})
+	}
+	return fields
+}
+
+// FECAdjustedEvent is emitted when AdaptiveFEC changes its parameters.
+type FECAdjustedEvent struct {
+	// Old is the previous parameters.
+	Old FECParams
+	// New is the new parameters.
+	New FECParams
+	// LossFactor is the factor derived from the loss rate.
+	LossFactor float64
+	// RTTFactor is the factor derived from the RTT.
+	RTTFactor float64
+	// BandwidthFactor is the factor derived from the bandwidth.
+	BandwidthFactor float64
+	// EfficiencyFactor is the factor derived from the delivery rate.
+	EfficiencyFactor float64
+}
+
+// Kind returns the name of the event.
+func (e *FECAdjustedEvent) Kind() string { return "fec.adjusted" }
+
+// Level returns the level the event is logged at.
+func (e *FECAdjustedEvent) Level() LogLevel { return InfoLevel }
+
+// Fields returns the fields of the event.
+func (e *FECAdjustedEvent) Fields() []EventField {
+	return []EventField{
+		{"old_parity", e.Old.ParityShards},
+		{"new_parity", e.New.ParityShards},
+		{"old_xor_data", e.Old.XORDataShards},
+		{"new_xor_data", e.New.XORDataShards},
+		{"loss_factor", e.LossFactor},
+		{"rtt_factor", e.RTTFactor},
+		{"bandwidth_factor", e.BandwidthFactor},
+		{"efficiency_factor", e.EfficiencyFactor},
+	}
+}
+
+// FECAdjustFailedEvent is emitted when AdaptiveFEC could not apply new
+// parameters.
+type FECAdjustFailedEvent struct {
+	// Err is the error returned by Adjust.
+	Err error
+}
+
+// Kind returns the name of the event.
+func (e *FECAdjustFailedEvent) Kind() string { return "fec.adjust_failed" }
+
+// Level returns the level the event is logged at.
+func (e *FECAdjustFailedEvent) Level() LogLevel { return WarnLevel }
+
+// Fields returns the fields of the event.
+func (e *FECAdjustFailedEvent) Fields() []EventField {
+	return []EventField{{"error", e.Err}}
+}
+
+// EventBus delivers events to its subscribers. Handlers are called
+// synchronously on the publishing goroutine, so they must be fast and must
+// not block. It is safe for concurrent use.
+type EventBus struct {
+	// handlers are the subscribed handlers by subscription ID.
+	handlers map[uint64]func(Event)
+	// nextID is the ID of the next subscription.
+	nextID uint64
+	// mutex protects handlers and nextID.
+	mutex sync.RWMutex
+}
+
+// NewEventBus creates a new EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		handlers: make(map[uint64]func(Event)),
+	}
+}
+
+// Subscribe registers a handler for all events published on the bus and
+// returns a function that removes it again.
+func (eb *EventBus) Subscribe(handler func(Event)) func() {
+	eb.mutex.Lock()
+	id := eb.nextID
+	eb.nextID++
+	eb.handlers[id] = handler
+	eb.mutex.Unlock()
+
+	return func() {
+		eb.mutex.Lock()
+		delete(eb.handlers, id)
+		eb.mutex.Unlock()
+	}
+}
+
+// Publish delivers an event to all subscribers.
+func (eb *EventBus) Publish(event Event) {
+	eb.mutex.RLock()
+	handlers := make([]func(Event), 0, len(eb.handlers))
+	for _, handler := range eb.handlers {
+		handlers = append(handlers, handler)
+	}
+	eb.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// defaultEventBus is the process-wide event bus. The global logger and the
+// default metrics are subscribed to it.
+var defaultEventBus = newDefaultEventBus()
+
+// newDefaultEventBus creates the process-wide event bus.
+func newDefaultEventBus() *EventBus {
+	bus := NewEventBus()
+	bus.Subscribe(logEvent)
+	bus.Subscribe(defaultMetrics.HandleEvent)
+	return bus
+}
+
+// DefaultEventBus returns the process-wide event bus.
+func DefaultEventBus() *EventBus {
+	return defaultEventBus
+}
+
+// logEvent writes an event to the global logger at the event's level, with
+// the event kind as the message and the event fields as fields. The part of
+// the kind before the first dot names the component, so "fec.adjusted" is
+// filtered by the level of the fec component.
+func logEvent(event Event) {
+	component, _, _ := strings.Cut(event.Kind(), ".")
+	logger := defaultLogger.Component(component)
+	if !logger.Enabled(event.Level()) {
+		return
+	}
+
+	fields := event.Fields()
+	args := make([]interface{}, 0, 2*len(fields))
+	for _, field := range fields {
+		args = append(args, field.Key, field.Value)
+	}
+	logger.Log(event.Level(), event.Kind(), args...)
+}
+
+// FormatEvent formats an event as its kind followed by key=value pairs.
+func FormatEvent(event Event) string {
+	var b strings.Builder
+	b.WriteString(event.Kind())
+	for _, field := range event.Fields() {
+		b.WriteByte(' ')
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		switch v := field.Value.(type) {
+		case float64:
+			fmt.Fprintf(&b, "%.4g", v)
+		case error:
+			fmt.Fprintf(&b, "%q", v.Error())
+		case string:
+			fmt.Fprintf(&b, "%q", v)
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects the events published on a bus.
+type eventRecorder struct {
+	events []Event
+	mutex  sync.Mutex
+}
+
+func (r *eventRecorder) handle(event Event) {
+	r.mutex.Lock()
+	r.events = append(r.events, event)
+	r.mutex.Unlock()
+}
+
+func (r *eventRecorder) snapshot() []Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	bus := NewEventBus()
+	first, second := &eventRecorder{}, &eventRecorder{}
+	unsubscribe := bus.Subscribe(first.handle)
+	bus.Subscribe(second.handle)
+
+	bus.Publish(&TelemetryReportFailedEvent{Err: errors.New("boom")})
+	unsubscribe()
+	bus.Publish(&TelemetryReportFailedEvent{Err: errors.New("again")})
+
+	if got := len(first.snapshot()); got != 1 {
+		t.Errorf("Expected 1 event before unsubscribing, got %d", got)
+	}
+	if got := len(second.snapshot()); got != 2 {
+		t.Errorf("Expected 2 events, got %d", got)
+	}
+}
+
+func TestTelemetryReporterPublishesEvents(t *testing.T) {
+	bus := NewEventBus()
+	recorder := &eventRecorder{}
+	bus.Subscribe(recorder.handle)
+
+	reporter := NewTelemetryReporter(&MockQUICStream{})
+	reporter.SetEventBus(bus)
+	data := &TelemetryData{RTT: 30 * time.Millisecond, Loss: 0.02}
+	if err := reporter.Report(data); err != nil {
+		t.Fatalf("Failed to report telemetry: %v", err)
+	}
+
+	events := recorder.snapshot()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	reported, ok := events[0].(*TelemetryReportedEvent)
+	if !ok || reported.Data != data {
+		t.Fatalf("Expected a TelemetryReportedEvent for the report, got %#v", events[0])
+	}
+	if reported.Level() != DebugLevel {
+		t.Errorf("Expected telemetry reports to be logged at debug level, got %v", reported.Level())
+	}
+
+	formatted := FormatEvent(reported)
+	if !strings.HasPrefix(formatted, "telemetry.reported rtt=30ms loss=0.02 ") {
+		t.Errorf("Unexpected formatted event %q", formatted)
+	}
+}
+
+// failingStream is a mock stream whose writes fail.
+type failingStream struct {
+	MockQUICStream
+	writes int
+}
+
+func (s *failingStream) Write(p []byte) (int, error) {
+	s.writes++
+	return 0, errors.New("stream reset")
+}
+
+func TestTelemetryReporterStopsAfterWriteFailure(t *testing.T) {
+	bus := NewEventBus()
+	recorder := &eventRecorder{}
+	bus.Subscribe(recorder.handle)
+
+	stream := &failingStream{}
+	reporter := NewTelemetryReporter(stream)
+	reporter.SetEventBus(bus)
+	if err := reporter.Report(&TelemetryData{}); err == nil {
+		t.Fatal("Expected the report to fail")
+	}
+	for i := 0; i < 3; i++ {
+		if err := reporter.Report(&TelemetryData{}); !errors.Is(err, ErrTelemetryStreamFailed) {
+			t.Errorf("Expected ErrTelemetryStreamFailed, got %v", err)
+		}
+	}
+
+	// The failure is published once and the stream is not written again
+	events := recorder.snapshot()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if _, ok := events[0].(*TelemetryReportFailedEvent); !ok {
+		t.Errorf("Expected a TelemetryReportFailedEvent, got %#v", events[0])
+	}
+	if stream.writes != 1 {
+		t.Errorf("Expected 1 write, got %d", stream.writes)
+	}
+}
+
+func TestTelemetryStreamStopsAfterWriteFailure(t *testing.T) {
+	stream := &failingStream{}
+	ts := NewTelemetryStream(stream)
+	ts.reporter.SetEventBus(NewEventBus())
+	if err := ts.WriteTelemetry(&TelemetryData{}); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	for i := 0; i < 3; i++ {
+		if err := ts.WriteTelemetry(&TelemetryData{}); !errors.Is(err, ErrTelemetryStreamFailed) {
+			t.Errorf("Expected ErrTelemetryStreamFailed, got %v", err)
+		}
+	}
+	if stream.writes != 1 {
+		t.Errorf("Expected 1 write, got %d", stream.writes)
+	}
+}
+
+func TestAdaptiveFECPublishesAdjustment(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(4, 2, 1, 6)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+	bus := NewEventBus()
+	recorder := &eventRecorder{}
+	bus.Subscribe(recorder.handle)
+	adaptiveFEC.SetEventBus(bus)
+
+	if err := adaptiveFEC.Adjust(&TelemetryData{Loss: 0.2}); err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+
+	events := recorder.snapshot()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	adjusted, ok := events[0].(*FECAdjustedEvent)
+	if !ok {
+		t.Fatalf("Expected a FECAdjustedEvent, got %#v", events[0])
+	}
+	if adjusted.Old.ParityShards != 2 || adjusted.New != adaptiveFEC.Params() {
+		t.Errorf("Unexpected adjustment %+v -> %+v", adjusted.Old, adjusted.New)
+	}
+	if adjusted.LossFactor <= 1 {
+		t.Errorf("Expected a loss factor above 1, got %f", adjusted.LossFactor)
+	}
+}
+
+func TestTokenBucketControllerPublishesRateChanges(t *testing.T) {
+	bus := NewEventBus()
+	recorder := &eventRecorder{}
+	bus.Subscribe(recorder.handle)
+
+	// Without a connection no loss is measured, so the rate increases
+	controller := NewTokenBucketController(NewTokenBucket(1000, 1000), nil, nil)
+	controller.SetEventBus(bus)
+	controller.Start()
+	defer controller.Stop()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, event := range recorder.snapshot() {
+			changed, ok := event.(*RateChangedEvent)
+			if !ok {
+				t.Fatalf("Unexpected event %#v", event)
+			}
+			if changed.Reason != "low_loss" || changed.OldRate != 1000 || changed.NewRate <= changed.OldRate {
+				t.Errorf("Unexpected rate change %+v", changed)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for a rate change")
+}
+
+func TestMetricsHandleEvent(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.HandleEvent(&RateChangedEvent{OldRate: 1000, NewRate: 900, Reason: "high_loss"})
+	metrics.HandleEvent(&FECAdjustedEvent{New: FECParams{DataShards: 10, ParityShards: 4}})
+
+	if got := metrics.tokenBucketRate.Load(); got != 900 {
+		t.Errorf("Expected token bucket rate 900, got %d", got)
+	}
+	if got := metrics.fecParityShards.Load(); got != 4 {
+		t.Errorf("Expected 4 parity shards, got %d", got)
+	}
+
+	var b strings.Builder
+	if _, err := metrics.WriteTo(&b); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	if !strings.Contains(b.String(), `vantun_events_total{kind="rate.changed"} 1`) {
+		t.Errorf("Expected the event counter in the output, got:\n%s", b.String())
+	}
+}
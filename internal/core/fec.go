@@ -1,8 +1,10 @@
 package core
 
 import (
+	"encoding/binary"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/klauspost/reedsolomon"
 )
@@ -58,31 +60,29 @@ func getEncoderFromCache(k, m int) (reedsolomon.Encoder, error) {
 	return enc, nil
 }
 
+// fecLengthPrefixSize is the size of the length prefix stored in front of
+// the data encoded by FEC.
+const fecLengthPrefixSize = 4
+
 // FEC represents a forward error correction encoder/decoder.
 type FEC struct {
 	enc        reedsolomon.Encoder
 	k          int // number of data shards
 	m          int // number of parity shards
-	lastDataSize int // size of the last encoded data
+	metrics    *FECMetrics // effectiveness counters
 }
 
-// ReturnShards returns the shards to the memory pool for reuse.
-// This should be called after processing the shards to avoid memory leaks.
-func (f *FEC) ReturnShards(shards [][]byte) {
-	for _, shard := range shards {
-		if shard != nil {
-			shardPool.Put(shard[:cap(shard)])
-		}
-	}
-}
+// ReturnShards used to return shards to the memory pool.
+//
+// Deprecated: Encode now returns shards owned by the caller and this is a
+// no-op. Use EncodeBlock and Block.Release for pooled shards.
+func (f *FEC) ReturnShards(shards [][]byte) {}
 
-// ReturnData returns the decoded data to the memory pool for reuse.
-// This should be called after processing the data to avoid memory leaks.
-func (f *FEC) ReturnData(data []byte) {
-	if data != nil {
-		dataPool.Put(data[:cap(data)])
-	}
-}
+// ReturnData used to return decoded data to the memory pool.
+//
+// Deprecated: Decode now returns data owned by the caller and this is a
+// no-op. Use DecodeBuffer and Buffer.Release for pooled data.
+func (f *FEC) ReturnData(data []byte) {}
 
 // NewFEC creates a new FEC with the specified number of data and parity shards.
 func NewFEC(k, m int) (*FEC, error) {
@@ -91,64 +91,107 @@ func NewFEC(k, m int) (*FEC, error) {
 		return nil, fmt.Errorf("failed to get reedsolomon encoder: %w", err)
 	}
 	return &FEC{
-		enc: enc,
-		k:   k,
-		m:   m,
+		enc:     enc,
+		k:       k,
+		m:       m,
+		metrics: defaultFECMetrics,
 	}, nil
 }
 
+// SetMetrics makes the FEC record into the given metrics instead of the
+// process-wide default. It must be called before the FEC is shared.
+func (f *FEC) SetMetrics(metrics *FECMetrics) {
+	f.metrics = metrics
+}
+
 // Encode encodes the data into shards, including parity shards.
+// The data is prefixed with its length so Decode does not depend on any
+// encoder state. The returned shards are freshly allocated and owned by
+// the caller.
 func (f *FEC) Encode(data []byte) ([][]byte, error) {
-	// Store the original data size
-	f.lastDataSize = len(data)
-	
-	// Calculate shard size
-	shardSize := (len(data) + f.k - 1) / f.k
-	
-	// Create shards using memory pool
 	shards := make([][]byte, f.k+f.m)
+	shardSize := f.shardSize(len(data))
 	for i := range shards {
-		// Get a byte slice from the pool
-		shard := shardPool.Get().([]byte)
-		
-		// Ensure it's large enough
-		if cap(shard) < shardSize {
-			// If not large enough, allocate a new one
-			shard = make([]byte, shardSize)
-		} else {
-			// If large enough, resize it
-			shard = shard[:shardSize]
-		}
-		
-		shards[i] = shard
+		shards[i] = make([]byte, shardSize)
 	}
 	
-	// Copy data to data shards (first k shards) row-wise
-	for i := 0; i < f.k; i++ {
-		start := i * shardSize
-		end := start + shardSize
-		if end > len(data) {
-			end = len(data)
-		}
-		if start < len(data) {
-			copy(shards[i], data[start:end])
+	if err := f.encodeInto(shards, data); err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// EncodeBlock encodes the data into a Block whose shards come from the
+// shard pool. The caller must call Release on the Block once the shards
+// have been sent and are no longer referenced.
+func (f *FEC) EncodeBlock(data []byte) (*Block, error) {
+	block := &Block{Shards: make([][]byte, f.k+f.m)}
+	shardSize := f.shardSize(len(data))
+	for i := range block.Shards {
+		block.Shards[i] = getZeroedShard(shardSize)
+	}
+	
+	if err := f.encodeInto(block.Shards, data); err != nil {
+		block.Release()
+		return nil, err
+	}
+	return block, nil
+}
+
+// shardSize returns the shard size needed to hold dataSize bytes of data
+// plus the length prefix.
+func (f *FEC) shardSize(dataSize int) int {
+	return (fecLengthPrefixSize + dataSize + f.k - 1) / f.k
+}
+
+// encodeInto copies the length prefix and the data into the first k shards
+// and computes the parity shards. The shards must be zeroed and
+// shardSize(len(data)) bytes long.
+func (f *FEC) encodeInto(shards [][]byte, data []byte) error {
+	shardSize := len(shards[0])
+	
+	// Spread the length prefix and the data row-wise over the data shards
+	var prefix [fecLengthPrefixSize]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(data)))
+	offset := 0
+	for _, part := range [][]byte{prefix[:], data} {
+		for len(part) > 0 {
+			n := copy(shards[offset/shardSize][offset%shardSize:], part)
+			part = part[n:]
+			offset += n
 		}
 	}
 	
 	// Encode parity shards
 	if err := f.enc.Encode(shards); err != nil {
-		// Return shards to pool before returning error
-		for _, shard := range shards {
-			shardPool.Put(shard[:cap(shard)])
-		}
-		return nil, fmt.Errorf("failed to encode shards: %w", err)
+		return fmt.Errorf("failed to encode shards: %w", err)
 	}
 	
-	return shards, nil
+	f.metrics.recordEncode(len(data), f.m*shardSize)
+	return nil
 }
 
 // Decode decodes the shards back into data.
+// Missing shards are nil and are reconstructed in place. The shards remain
+// owned by the caller; the returned data is freshly allocated.
 func (f *FEC) Decode(shards [][]byte) ([]byte, error) {
+	return f.reconstruct(shards, newDataBuffer)
+}
+
+// DecodeBuffer decodes the shards into a Buffer backed by the data pool.
+// The caller must call Release on the Buffer once the data is no longer
+// referenced. The shards remain owned by the caller.
+func (f *FEC) DecodeBuffer(shards [][]byte) (*Buffer, error) {
+	data, err := f.reconstruct(shards, getDataBuffer)
+	if err != nil {
+		return nil, err
+	}
+	return &Buffer{data: data}, nil
+}
+
+// reconstruct rebuilds missing shards and reassembles the original data,
+// whose length is read from the prefix, into a buffer obtained from alloc.
+func (f *FEC) reconstruct(shards [][]byte, alloc func(size int) []byte) ([]byte, error) {
 	// Verify shard count
 	if len(shards) != f.k+f.m {
 		return nil, fmt.Errorf("invalid shard count: expected %d, got %d", f.k+f.m, len(shards))
@@ -169,6 +212,7 @@ func (f *FEC) Decode(shards [][]byte) ([]byte, error) {
 		
 		// If all shards are nil, that's an error
 		if !found {
+			f.metrics.recordUnrecoverable()
 			return nil, fmt.Errorf("all shards are nil")
 		}
 		
@@ -180,50 +224,38 @@ func (f *FEC) Decode(shards [][]byte) ([]byte, error) {
 		}
 		
 		// Reconstruct missing shards if needed
+		missing := countMissing(shards)
+		start := time.Now()
 		if err := f.enc.Reconstruct(shards); err != nil {
+			f.metrics.recordUnrecoverable()
 			return nil, fmt.Errorf("failed to reconstruct shards: %w", err)
 		}
+		elapsed := time.Since(start)
 		
-		// Calculate the original data size
-		// Use the stored original data size if available, otherwise calculate it
-		dataSize := f.lastDataSize
-		if dataSize == 0 {
-			// Fallback to the old method if lastDataSize is not set
-			dataSize = f.k * shardSize
-		}
-		
-		// Get a byte slice from the pool for the decoded data
-		data := dataPool.Get().([]byte)
-		
-		// Ensure it's large enough
-		if cap(data) < dataSize {
-			// If not large enough, allocate a new one
-			data = make([]byte, dataSize)
-		} else {
-			// If large enough, resize it
-			data = data[:dataSize]
+		if shardSize*f.k < fecLengthPrefixSize {
+			return nil, fmt.Errorf("shards too small to hold length prefix")
 		}
 		
-		// Copy data shards (first k shards) into the data slice
-		offset := 0
-		for i := 0; i < f.k; i++ {
-			shardLen := len(shards[i])
-			if offset+shardLen > dataSize {
-				shardLen = dataSize - offset
-			}
-			if shardLen > 0 {
-				copy(data[offset:], shards[i][:shardLen])
-				offset += shardLen
+		// Read the data shards (first k shards) back row-wise
+		read := func(dst []byte, offset int) {
+			for len(dst) > 0 {
+				n := copy(dst, shards[offset/shardSize][offset%shardSize:])
+				dst = dst[n:]
+				offset += n
 			}
 		}
 		
-		// Return shards to pool
-		for _, shard := range shards {
-			if shard != nil {
-				shardPool.Put(shard[:cap(shard)])
-			}
+		var prefix [fecLengthPrefixSize]byte
+		read(prefix[:], 0)
+		dataSize := int(binary.BigEndian.Uint32(prefix[:]))
+		if dataSize > shardSize*f.k-fecLengthPrefixSize {
+			return nil, fmt.Errorf("data size %d exceeds shard capacity %d", dataSize, shardSize*f.k-fecLengthPrefixSize)
 		}
 		
+		data := alloc(dataSize)
+		read(data, fecLengthPrefixSize)
+		
+		f.metrics.recordDecode(missing > 0, elapsed)
 		return data, nil
 	}
 	
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"sync"
+)
+
+// Block is an encoded FEC block. Its shards are backed by buffers from the
+// shard pool that belong to the Block until Release is called. Shards must
+// not be used or retained after Release, since the buffers may then be
+// handed out to another Block.
+type Block struct {
+	// Shards holds the data shards followed by the parity shards.
+	Shards [][]byte
+	// once ensures the shards are returned to the pool only once.
+	once sync.Once
+}
+
+// Release returns the shards to the pool. It is safe to call more than once.
+func (b *Block) Release() {
+	if b == nil {
+		return
+	}
+	b.once.Do(func() {
+		for _, shard := range b.Shards {
+			if shard != nil {
+				shardPool.Put(shard[:cap(shard)])
+			}
+		}
+		b.Shards = nil
+	})
+}
+
+// Buffer holds decoded data backed by a buffer from the data pool that
+// belongs to the Buffer until Release is called. The data must not be used
+// or retained after Release.
+type Buffer struct {
+	// data is the decoded data.
+	data []byte
+	// once ensures the data is returned to the pool only once.
+	once sync.Once
+}
+
+// Bytes returns the decoded data. It is only valid until Release is called.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Len returns the length of the decoded data.
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Release returns the data to the pool. It is safe to call more than once.
+func (b *Buffer) Release() {
+	if b == nil {
+		return
+	}
+	b.once.Do(func() {
+		if b.data != nil {
+			dataPool.Put(b.data[:cap(b.data)])
+		}
+		b.data = nil
+	})
+}
+
+// getZeroedShard gets a zeroed shard of the given size from the shard pool.
+// Pooled shards may hold data from an earlier block, which must not leak
+// into the padding of a new one.
+func getZeroedShard(size int) []byte {
+	shard := shardPool.Get().([]byte)
+	if cap(shard) < size {
+		return make([]byte, size)
+	}
+	shard = shard[:size]
+	for i := range shard {
+		shard[i] = 0
+	}
+	return shard
+}
+
+// getDataBuffer gets a buffer of the given size from the data pool.
+// The buffer is not zeroed; the caller overwrites all of it.
+func getDataBuffer(size int) []byte {
+	data := dataPool.Get().([]byte)
+	if cap(data) < size {
+		return make([]byte, size)
+	}
+	return data[:size]
+}
+
+// newDataBuffer allocates a buffer of the given size that is owned by the caller.
+func newDataBuffer(size int) []byte {
+	return make([]byte, size)
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// FECCodec is a forward error correction encoder/decoder that turns a block
+// of data into shards and back. The Reed-Solomon FEC and the XOR parity
+// XORFEC both implement it.
+//
+// Encode and Decode return freshly allocated buffers owned by the caller.
+// EncodeBlock and DecodeBuffer use pooled buffers owned by the returned
+// Block or Buffer until it is released. Decoding never takes ownership of
+// the input shards.
+type FECCodec interface {
+	// Encode encodes the data into data and parity shards.
+	Encode(data []byte) ([][]byte, error)
+	// EncodeBlock encodes the data into a Block backed by pooled shards.
+	EncodeBlock(data []byte) (*Block, error)
+	// Decode reconstructs the original data from the shards.
+	// Missing shards are nil.
+	Decode(shards [][]byte) ([]byte, error)
+	// DecodeBuffer reconstructs the original data into a pooled Buffer.
+	DecodeBuffer(shards [][]byte) (*Buffer, error)
+}
+
+// xorLengthPrefixSize is the size of the length prefix stored in front of
+// the data encoded by XORFEC.
+const xorLengthPrefixSize = 4
+
+const (
+	// fecCodecReedSolomon identifies the Reed-Solomon FEC on the wire.
+	fecCodecReedSolomon byte = 1
+	// fecCodecXOR identifies XORFEC on the wire.
+	fecCodecXOR byte = 2
+)
+
+// codecLayout returns the wire ID of a codec and its number of data shards
+// and of shards in total, which is all a receiver needs to decode.
+func codecLayout(codec FECCodec) (id byte, dataShards, totalShards int, err error) {
+	switch c := codec.(type) {
+	case *FEC:
+		return fecCodecReedSolomon, c.k, c.k + c.m, nil
+	case *XORFEC:
+		return fecCodecXOR, c.k, c.k + 1, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported FEC codec %T", codec)
+	}
+}
+
+// codecFromLayout returns a codec that decodes the blocks of a codec with
+// the given wire ID and layout.
+func codecFromLayout(id byte, dataShards, totalShards int) (FECCodec, error) {
+	switch id {
+	case fecCodecReedSolomon:
+		return NewFEC(dataShards, totalShards-dataShards)
+	case fecCodecXOR:
+		if totalShards != dataShards+1 {
+			return nil, fmt.Errorf("invalid XOR layout: %d data shards, %d in total", dataShards, totalShards)
+		}
+		return NewXORFEC(dataShards)
+	default:
+		return nil, fmt.Errorf("unknown FEC codec %d", id)
+	}
+}
+
+// XORFEC is a low-latency FEC codec that adds a single XOR parity shard to
+// every k data shards. It can repair one lost shard per block, and since the
+// code is systematic the data shards can be consumed as they arrive. It is
+// much cheaper than Reed-Solomon and suits small, interactive blocks.
+type XORFEC struct {
+	// k is the number of data shards protected by the parity shard.
+	k int
+	// metrics holds the effectiveness counters.
+	metrics *FECMetrics
+}
+
+// NewXORFEC creates a new XORFEC with k data shards per parity shard.
+func NewXORFEC(k int) (*XORFEC, error) {
+	if k < 1 || k > 255 {
+		return nil, fmt.Errorf("invalid XOR data shard count %d: must be between 1 and 255", k)
+	}
+	return &XORFEC{k: k, metrics: defaultFECMetrics}, nil
+}
+
+// SetMetrics makes the codec record into the given metrics instead of the
+// process-wide default. It must be called before the codec is shared.
+func (x *XORFEC) SetMetrics(metrics *FECMetrics) {
+	x.metrics = metrics
+}
+
+// DataShards returns the number of data shards per parity shard.
+func (x *XORFEC) DataShards() int {
+	return x.k
+}
+
+// Encode encodes the data into k data shards and one parity shard.
+// The data is prefixed with its length so Decode does not depend on any
+// encoder state. The returned shards are owned by the caller.
+func (x *XORFEC) Encode(data []byte) ([][]byte, error) {
+	shards := make([][]byte, x.k+1)
+	shardSize := x.shardSize(len(data))
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+	}
+
+	x.encodeInto(shards, data)
+	return shards, nil
+}
+
+// EncodeBlock encodes the data into a Block whose shards come from the
+// shard pool. The caller must call Release on the Block when done.
+func (x *XORFEC) EncodeBlock(data []byte) (*Block, error) {
+	block := &Block{Shards: make([][]byte, x.k+1)}
+	shardSize := x.shardSize(len(data))
+	for i := range block.Shards {
+		block.Shards[i] = getZeroedShard(shardSize)
+	}
+
+	x.encodeInto(block.Shards, data)
+	return block, nil
+}
+
+// shardSize returns the shard size needed to hold dataSize bytes of data
+// plus the length prefix.
+func (x *XORFEC) shardSize(dataSize int) int {
+	return (xorLengthPrefixSize + dataSize + x.k - 1) / x.k
+}
+
+// encodeInto writes the length prefix and data into the data shards and
+// computes the parity shard. The shards must be zeroed.
+func (x *XORFEC) encodeInto(shards [][]byte, data []byte) {
+	shardSize := len(shards[0])
+
+	// Spread the length prefix and the data row-wise over the data shards
+	var prefix [xorLengthPrefixSize]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(data)))
+	offset := 0
+	for _, part := range [][]byte{prefix[:], data} {
+		for len(part) > 0 {
+			shard := shards[offset/shardSize]
+			n := copy(shard[offset%shardSize:], part)
+			part = part[n:]
+			offset += n
+		}
+	}
+
+	// The parity shard is the XOR of all data shards
+	parity := shards[x.k]
+	for _, shard := range shards[:x.k] {
+		xorInto(parity, shard)
+	}
+
+	x.metrics.recordEncode(len(data), len(parity))
+}
+
+// Decode reconstructs the original data from the shards. At most one shard
+// may be missing; a missing shard is rebuilt in place. The returned data is
+// owned by the caller.
+func (x *XORFEC) Decode(shards [][]byte) ([]byte, error) {
+	return x.decode(shards, newDataBuffer)
+}
+
+// DecodeBuffer decodes the shards into a Buffer backed by the data pool.
+// The caller must call Release on the Buffer when done.
+func (x *XORFEC) DecodeBuffer(shards [][]byte) (*Buffer, error) {
+	data, err := x.decode(shards, getDataBuffer)
+	if err != nil {
+		return nil, err
+	}
+	return &Buffer{data: data}, nil
+}
+
+// decode rebuilds a missing shard and reads the data into a buffer
+// obtained from alloc.
+func (x *XORFEC) decode(shards [][]byte, alloc func(size int) []byte) ([]byte, error) {
+	if len(shards) != x.k+1 {
+		return nil, fmt.Errorf("invalid shard count: expected %d, got %d", x.k+1, len(shards))
+	}
+
+	missing := -1
+	shardSize := -1
+	for i, shard := range shards {
+		if shard == nil {
+			if missing >= 0 {
+				x.metrics.recordUnrecoverable()
+				return nil, fmt.Errorf("too many missing shards: XOR parity can only repair one")
+			}
+			missing = i
+			continue
+		}
+		if shardSize < 0 {
+			shardSize = len(shard)
+		} else if len(shard) != shardSize {
+			return nil, fmt.Errorf("shards have different sizes")
+		}
+	}
+
+	// Rebuild the missing shard from the XOR of all others
+	start := time.Now()
+	if missing >= 0 {
+		rebuilt := make([]byte, shardSize)
+		for _, shard := range shards {
+			if shard != nil {
+				xorInto(rebuilt, shard)
+			}
+		}
+		shards[missing] = rebuilt
+	}
+	elapsed := time.Since(start)
+
+	if shardSize*x.k < xorLengthPrefixSize {
+		return nil, fmt.Errorf("shards too small to hold length prefix")
+	}
+
+	// Read the data shards back row-wise
+	read := func(dst []byte, offset int) {
+		for len(dst) > 0 {
+			n := copy(dst, shards[offset/shardSize][offset%shardSize:])
+			dst = dst[n:]
+			offset += n
+		}
+	}
+
+	var prefix [xorLengthPrefixSize]byte
+	read(prefix[:], 0)
+	dataSize := int(binary.BigEndian.Uint32(prefix[:]))
+	if dataSize > shardSize*x.k-xorLengthPrefixSize {
+		return nil, fmt.Errorf("invalid data length %d", dataSize)
+	}
+
+	data := alloc(dataSize)
+	read(data, xorLengthPrefixSize)
+
+	x.metrics.recordDecode(missing >= 0, elapsed)
+	return data, nil
+}
+
+// xorInto XORs src into dst. Both slices must have the same length.
+func xorInto(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
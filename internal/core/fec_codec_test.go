@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestXORFECEncodeDecode(t *testing.T) {
+	xor, err := NewXORFEC(4)
+	if err != nil {
+		t.Fatalf("Failed to create XOR FEC: %v", err)
+	}
+
+	data := []byte("ssh keystroke payload")
+	shards, err := xor.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	if len(shards) != 5 {
+		t.Fatalf("Expected 5 shards, got %d", len(shards))
+	}
+
+	decoded, err := xor.Decode(shards)
+	if err != nil {
+		t.Fatalf("Failed to decode shards: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("Decoded data does not match original. Got %q, expected %q", decoded, data)
+	}
+}
+
+func TestXORFECRepairsSingleLoss(t *testing.T) {
+	xor, err := NewXORFEC(3)
+	if err != nil {
+		t.Fatalf("Failed to create XOR FEC: %v", err)
+	}
+
+	data := []byte("a short interactive message that spans all three data shards")
+
+	// Losing any single shard, data or parity, must be repairable
+	for lost := 0; lost <= 3; lost++ {
+		shards, err := xor.Encode(data)
+		if err != nil {
+			t.Fatalf("Failed to encode data: %v", err)
+		}
+		shards[lost] = nil
+
+		decoded, err := xor.Decode(shards)
+		if err != nil {
+			t.Fatalf("Failed to decode with shard %d lost: %v", lost, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("Shard %d lost: got %q, expected %q", lost, decoded, data)
+		}
+	}
+}
+
+func TestXORFECTooManyLosses(t *testing.T) {
+	xor, err := NewXORFEC(3)
+	if err != nil {
+		t.Fatalf("Failed to create XOR FEC: %v", err)
+	}
+
+	shards, err := xor.Encode([]byte("two losses are too many for a single parity shard"))
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	shards[0] = nil
+	shards[2] = nil
+
+	if _, err := xor.Decode(shards); err == nil {
+		t.Error("Expected decode to fail with two missing shards")
+	}
+}
+
+func TestAdaptiveFECCodecForStreamType(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(10, 3, 1, 10)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+
+	if _, ok := adaptiveFEC.CodecFor(StreamTypeInteractive).(*XORFEC); !ok {
+		t.Error("Expected XOR codec for interactive streams")
+	}
+	if _, ok := adaptiveFEC.CodecFor(StreamTypeTelemetry).(*XORFEC); !ok {
+		t.Error("Expected XOR codec for telemetry streams")
+	}
+	if _, ok := adaptiveFEC.CodecFor(StreamTypeBulk).(*FEC); !ok {
+		t.Error("Expected Reed-Solomon codec for bulk streams")
+	}
+
+	// High loss shrinks the XOR group so each parity shard protects fewer data shards
+	before := adaptiveFEC.Params().XORDataShards
+	err = adaptiveFEC.Adjust(&TelemetryData{
+		RTT:          50 * time.Millisecond,
+		Loss:         0.15,
+		Bandwidth:    1000000,
+		DeliveryRate: 1000000,
+	})
+	if err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+	after := adaptiveFEC.Params().XORDataShards
+	if after >= before {
+		t.Errorf("Expected XOR group to shrink under high loss, went from %d to %d", before, after)
+	}
+
+	codec := adaptiveFEC.CodecFor(StreamTypeInteractive)
+	data := []byte("interactive data after adjustment")
+	shards, err := codec.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	if len(shards) != after+1 {
+		t.Errorf("Expected %d shards, got %d", after+1, len(shards))
+	}
+	decoded, err := codec.Decode(shards)
+	if err != nil {
+		t.Fatalf("Failed to decode data: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("Decoded data does not match original. Got %q, expected %q", decoded, data)
+	}
+}
+
+func TestAdaptiveFECStreamCodecs(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(10, 3, 1, 10)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+
+	// Bulk streams send Reed-Solomon frames
+	bulk := &recordingStream{}
+	if _, err := NewAdaptiveFECStream(bulk, adaptiveFEC, StreamTypeBulk).Write([]byte("bulk")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if codec := bulk.frames[0][0]; codec != fecCodecReedSolomon {
+		t.Errorf("Expected Reed-Solomon frames on bulk streams, got codec %d", codec)
+	}
+
+	// Interactive streams send XOR frames and follow the adjusted group size
+	interactive := &recordingStream{}
+	stream := NewAdaptiveFECStream(interactive, adaptiveFEC, StreamTypeInteractive)
+	if _, err := stream.Write([]byte("before ")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	err = adaptiveFEC.Adjust(&TelemetryData{
+		RTT:          50 * time.Millisecond,
+		Loss:         0.15,
+		Bandwidth:    1000000,
+		DeliveryRate: 1000000,
+	})
+	if err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+	if _, err := stream.Write([]byte("after")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	first, last := interactive.frames[0], interactive.frames[len(interactive.frames)-1]
+	if first[0] != fecCodecXOR || last[0] != fecCodecXOR {
+		t.Errorf("Expected XOR frames on interactive streams, got codecs %d and %d", first[0], last[0])
+	}
+	if int(last[1]) != adaptiveFEC.Params().XORDataShards || last[1] == first[1] {
+		t.Errorf("Expected the XOR group to change from %d to %d data shards, got %d", first[1], adaptiveFEC.Params().XORDataShards, last[1])
+	}
+
+	// The reader decodes each group with the codec named in its frames
+	reader := NewFECStreamWithCodec(&recordingStream{r: bytes.NewReader(interactive.received(nil))}, nil)
+	received, err := io.ReadAll(reader)
+	if err != nil || string(received) != "before after" {
+		t.Errorf("Expected both writes, got %q: %v", received, err)
+	}
+}
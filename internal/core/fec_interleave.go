@@ -0,0 +1,183 @@
+package core
+
+import (
+	"fmt"
+)
+
+// maxInterleaveDepth is the largest supported interleaving depth.
+// The block index and group size are carried in a single header byte.
+const maxInterleaveDepth = 255
+
+// InterleavedShard is a single FEC shard tagged with its position in an
+// interleaving group, so the receiver can regroup shards into blocks.
+type InterleavedShard struct {
+	// Block is the index of the block within its interleaving group.
+	Block int
+	// Blocks is the number of blocks in the interleaving group.
+	Blocks int
+	// Index is the index of the shard within its block.
+	Index int
+	// Data is the shard payload.
+	Data []byte
+}
+
+// InterleavedGroup is an encoded interleaving group. Its shard payloads are
+// backed by pooled buffers that belong to the group until Release is called.
+type InterleavedGroup struct {
+	// Shards holds the shards of all blocks in interleaved wire order.
+	Shards []InterleavedShard
+	// blocks are the encoded blocks that own the shard payloads.
+	blocks []*Block
+}
+
+// Release returns the shard payloads to the pool. It is safe to call more
+// than once. The shards must not be used after Release.
+func (g *InterleavedGroup) Release() {
+	for _, block := range g.blocks {
+		block.Release()
+	}
+	g.Shards = nil
+}
+
+// Interleaver spreads the shards of several consecutive FEC blocks across
+// the wire. Instead of sending all shards of one block back to back, it sends
+// shard 0 of every block, then shard 1 of every block, and so on. A burst of
+// consecutive lost packets is therefore spread over the blocks of the group,
+// and each block only loses a few shards.
+type Interleaver struct {
+	// fec is the underlying FEC encoder/decoder.
+	fec *FEC
+	// depth is the maximum number of blocks in an interleaving group.
+	depth int
+}
+
+// NewInterleaver creates a new Interleaver that groups up to depth blocks,
+// each encoded with k data shards and m parity shards.
+func NewInterleaver(k, m, depth int) (*Interleaver, error) {
+	if depth < 1 || depth > maxInterleaveDepth {
+		return nil, fmt.Errorf("invalid interleave depth %d: must be between 1 and %d", depth, maxInterleaveDepth)
+	}
+
+	fec, err := NewFEC(k, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FEC: %w", err)
+	}
+
+	return &Interleaver{
+		fec:   fec,
+		depth: depth,
+	}, nil
+}
+
+// Depth returns the maximum number of blocks in an interleaving group.
+func (il *Interleaver) Depth() int {
+	return il.depth
+}
+
+// MaxBurst returns the longest run of consecutive lost shards that a full
+// interleaving group is guaranteed to recover from.
+func (il *Interleaver) MaxBurst() int {
+	return il.depth * il.fec.m
+}
+
+// Encode encodes up to Depth blocks and returns their shards in interleaved
+// wire order. The caller must call Release on the group once the shards
+// have been sent.
+func (il *Interleaver) Encode(blocks [][]byte) (*InterleavedGroup, error) {
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks to encode")
+	}
+	if len(blocks) > il.depth {
+		return nil, fmt.Errorf("too many blocks: got %d, depth is %d", len(blocks), il.depth)
+	}
+
+	// Encode every block on its own
+	group := &InterleavedGroup{blocks: make([]*Block, 0, len(blocks))}
+	for b, data := range blocks {
+		block, err := il.fec.EncodeBlock(data)
+		if err != nil {
+			// Release the blocks encoded so far
+			group.Release()
+			return nil, fmt.Errorf("failed to encode block %d: %w", b, err)
+		}
+		group.blocks = append(group.blocks, block)
+	}
+
+	// Emit shard i of every block before shard i+1 of any block
+	total := il.fec.k + il.fec.m
+	group.Shards = make([]InterleavedShard, 0, total*len(blocks))
+	for i := 0; i < total; i++ {
+		for b, block := range group.blocks {
+			group.Shards = append(group.Shards, InterleavedShard{
+				Block:  b,
+				Blocks: len(blocks),
+				Index:  i,
+				Data:   block.Shards[i],
+			})
+		}
+	}
+
+	return group, nil
+}
+
+// Decode regroups received shards into blocks and reconstructs them.
+// Missing shards are simply absent from the input. The returned blocks are
+// in their original order and owned by the caller; the input shards are
+// not retained.
+func (il *Interleaver) Decode(shards []InterleavedShard) ([][]byte, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards provided")
+	}
+	if blocks := shards[0].Blocks; blocks > il.depth {
+		return nil, fmt.Errorf("invalid block count %d for depth %d", blocks, il.depth)
+	}
+	return decodeGroup(il.fec, il.fec.k+il.fec.m, shards)
+}
+
+// decodeGroup regroups the received shards of an interleaving group into
+// blocks of total shards each and reconstructs them with codec.
+func decodeGroup(codec FECCodec, total int, shards []InterleavedShard) ([][]byte, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shards provided")
+	}
+
+	blocks := shards[0].Blocks
+	if blocks < 1 || blocks > maxInterleaveDepth {
+		return nil, fmt.Errorf("invalid block count %d", blocks)
+	}
+
+	// Regroup shards by block
+	grouped := make([][][]byte, blocks)
+	received := make([]bool, blocks)
+	for b := range grouped {
+		grouped[b] = make([][]byte, total)
+	}
+	for _, shard := range shards {
+		if shard.Blocks != blocks {
+			return nil, fmt.Errorf("shards belong to different interleaving groups")
+		}
+		if shard.Block < 0 || shard.Block >= blocks {
+			return nil, fmt.Errorf("invalid block index %d", shard.Block)
+		}
+		if shard.Index < 0 || shard.Index >= total {
+			return nil, fmt.Errorf("invalid shard index %d", shard.Index)
+		}
+		grouped[shard.Block][shard.Index] = shard.Data
+		received[shard.Block] = true
+	}
+
+	// Reconstruct each block
+	out := make([][]byte, blocks)
+	for b := range grouped {
+		if !received[b] {
+			return nil, fmt.Errorf("block %d: all shards lost", b)
+		}
+		data, err := codec.Decode(grouped[b])
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", b, err)
+		}
+		out[b] = data
+	}
+
+	return out, nil
+}
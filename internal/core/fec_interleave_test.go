@@ -0,0 +1,273 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleaverBurstLossRecovery(t *testing.T) {
+	k, m, depth := 4, 2, 4
+	interleaver, err := NewInterleaver(k, m, depth)
+	if err != nil {
+		t.Fatalf("Failed to create interleaver: %v", err)
+	}
+
+	// Create blocks of different sizes
+	blocks := make([][]byte, depth)
+	for b := range blocks {
+		blocks[b] = []byte(fmt.Sprintf("block %d carries payload %s", b, bytes.Repeat([]byte{'x'}, b*7)))
+	}
+
+	group, err := interleaver.Encode(blocks)
+	if err != nil {
+		t.Fatalf("Failed to encode blocks: %v", err)
+	}
+	defer group.Release()
+	shards := group.Shards
+	if len(shards) != (k+m)*depth {
+		t.Fatalf("Expected %d shards, got %d", (k+m)*depth, len(shards))
+	}
+
+	// Drop the longest recoverable burst of consecutive shards from the middle of the wire order
+	burst := interleaver.MaxBurst()
+	start := 5
+	received := append([]InterleavedShard{}, shards[:start]...)
+	received = append(received, shards[start+burst:]...)
+
+	decoded, err := interleaver.Decode(received)
+	if err != nil {
+		t.Fatalf("Failed to decode after burst loss of %d shards: %v", burst, err)
+	}
+
+	for b := range blocks {
+		if !bytes.Equal(decoded[b], blocks[b]) {
+			t.Errorf("Block %d mismatch: got %q, expected %q", b, decoded[b], blocks[b])
+		}
+	}
+}
+
+func TestInterleaverWithoutInterleavingLosesBurst(t *testing.T) {
+	// With depth 1 the same burst hits a single block and exceeds its parity
+	k, m := 4, 2
+	interleaver, err := NewInterleaver(k, m, 1)
+	if err != nil {
+		t.Fatalf("Failed to create interleaver: %v", err)
+	}
+
+	group, err := interleaver.Encode([][]byte{[]byte("a single block without interleaving")})
+	if err != nil {
+		t.Fatalf("Failed to encode block: %v", err)
+	}
+	defer group.Release()
+	shards := group.Shards
+
+	received := append([]InterleavedShard{}, shards[:1]...)
+	received = append(received, shards[1+m+1:]...)
+
+	if _, err := interleaver.Decode(received); err == nil {
+		t.Error("Expected decode to fail when a burst exceeds the parity of a single block")
+	}
+}
+
+func TestInterleaverInvalidDepth(t *testing.T) {
+	if _, err := NewInterleaver(4, 2, 0); err == nil {
+		t.Error("Expected error for depth 0")
+	}
+	if _, err := NewInterleaver(4, 2, maxInterleaveDepth+1); err == nil {
+		t.Errorf("Expected error for depth %d", maxInterleaveDepth+1)
+	}
+
+	interleaver, err := NewInterleaver(4, 2, 2)
+	if err != nil {
+		t.Fatalf("Failed to create interleaver: %v", err)
+	}
+	if _, err := interleaver.Encode([][]byte{[]byte("a"), []byte("b"), []byte("c")}); err == nil {
+		t.Error("Expected error when encoding more blocks than the depth")
+	}
+}
+
+func TestInterleavedFECStreamWrite(t *testing.T) {
+	k, m, depth := 2, 1, 2
+	mockStream := &MockQUICStream{}
+	stream, err := NewInterleavedFECStream(mockStream, k, m, depth)
+	if err != nil {
+		t.Fatalf("Failed to create interleaved FEC stream: %v", err)
+	}
+	stream.flushDelay = time.Hour
+
+	// The first write is buffered until the group is full
+	if _, err := stream.Write([]byte("first")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if len(mockStream.writeData) != 0 {
+		t.Fatalf("Expected first write to be buffered, but %d bytes were written", len(mockStream.writeData))
+	}
+
+	if _, err := stream.Write([]byte("second")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if len(mockStream.writeData) == 0 {
+		t.Fatal("Expected a full group to be flushed")
+	}
+
+	// Shards must alternate between the two blocks on the wire
+	data := mockStream.writeData
+	for i := 0; i < depth*(k+m) && len(data) >= fecFrameHeaderSize; i++ {
+		header := data[:fecFrameHeaderSize]
+		if header[0] != fecCodecReedSolomon || int(header[1]) != k {
+			t.Errorf("Shard %d: expected Reed-Solomon with %d data shards, got codec %d with %d", i, k, header[0], header[1])
+		}
+		if int(header[4]) != i%depth {
+			t.Errorf("Shard %d: expected block %d, got %d", i, i%depth, header[4])
+		}
+		if int(header[2]) != i/depth {
+			t.Errorf("Shard %d: expected shard index %d, got %d", i, i/depth, header[2])
+		}
+		shardSize := int(binary.BigEndian.Uint32(header[10:]))
+		data = data[fecFrameHeaderSize+shardSize:]
+	}
+
+	// A trailing partial group is flushed on close
+	written := len(mockStream.writeData)
+	if _, err := stream.Write([]byte("third")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Failed to close stream: %v", err)
+	}
+	if len(mockStream.writeData) == written {
+		t.Error("Expected pending block to be flushed on close")
+	}
+}
+
+// recordingStream records every write as one frame and reads from r.
+type recordingStream struct {
+	MockQUICStream
+	mutex  sync.Mutex
+	frames [][]byte
+	r      io.Reader
+}
+
+func (s *recordingStream) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.frames = append(s.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (s *recordingStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// received returns the recorded frames as the peer reads them, without
+// the frames whose index is in lost.
+func (s *recordingStream) received(lost map[int]bool) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var out []byte
+	for i, frame := range s.frames {
+		if !lost[i] {
+			out = append(out, frame...)
+		}
+	}
+	return out
+}
+
+func TestInterleavedFECStreamRecoversBurstLoss(t *testing.T) {
+	k, m, depth := 4, 2, 4
+	sender := &recordingStream{}
+	writer, err := NewInterleavedFECStream(sender, k, m, depth)
+	if err != nil {
+		t.Fatalf("Failed to create interleaved FEC stream: %v", err)
+	}
+
+	// Two full groups and a partial one flushed on close
+	var sent []byte
+	for i := 0; i < 2*depth+1; i++ {
+		block := []byte(fmt.Sprintf("block %d %s", i, bytes.Repeat([]byte{'x'}, i*13)))
+		sent = append(sent, block...)
+		if _, err := writer.Write(block); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// Lose the longest recoverable burst in each full group, and the last
+	// frame of the partial group
+	frames := depth * (k + m)
+	lost := make(map[int]bool)
+	for i := 0; i < depth*m; i++ {
+		lost[3+i] = true
+		lost[frames+7+i] = true
+	}
+	lost[2*frames+k+m-1] = true
+
+	reader, err := NewInterleavedFECStream(&recordingStream{r: bytes.NewReader(sender.received(lost))}, k, m, depth)
+	if err != nil {
+		t.Fatalf("Failed to create interleaved FEC stream: %v", err)
+	}
+	received, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Errorf("Expected the written data after burst losses, got %q", received)
+	}
+}
+
+func TestFECStreamRead(t *testing.T) {
+	sender := &recordingStream{}
+	writer, err := NewFECStream(sender, 3, 1)
+	if err != nil {
+		t.Fatalf("Failed to create FEC stream: %v", err)
+	}
+	for _, block := range []string{"hello", ", ", "world"} {
+		if _, err := writer.Write([]byte(block)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	// Each write is its own group; lose one shard of the second
+	reader, err := NewFECStream(&recordingStream{r: bytes.NewReader(sender.received(map[int]bool{5: true}))}, 3, 1)
+	if err != nil {
+		t.Fatalf("Failed to create FEC stream: %v", err)
+	}
+	received, err := io.ReadAll(reader)
+	if err != nil || string(received) != "hello, world" {
+		t.Errorf("Expected hello, world, got %q: %v", received, err)
+	}
+}
+
+func TestInterleavedFECStreamFlushDelay(t *testing.T) {
+	sender := &recordingStream{}
+	stream, err := NewInterleavedFECStream(sender, 2, 1, 8)
+	if err != nil {
+		t.Fatalf("Failed to create interleaved FEC stream: %v", err)
+	}
+	stream.flushDelay = 20 * time.Millisecond
+
+	// A single sparse write is sent without waiting for the group to fill
+	if _, err := stream.Write([]byte("keystroke")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(sender.received(nil)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	reader, err := NewInterleavedFECStream(&recordingStream{r: bytes.NewReader(sender.received(nil))}, 2, 1, 8)
+	if err != nil {
+		t.Fatalf("Failed to create interleaved FEC stream: %v", err)
+	}
+	received, err := io.ReadAll(reader)
+	if err != nil || string(received) != "keystroke" {
+		t.Errorf("Expected the write to be flushed after the delay, got %q: %v", received, err)
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FECMetrics counts how effective forward error correction is. It is safe
+// for concurrent use. Codecs record into the process-wide default metrics
+// unless they are given their own with SetMetrics.
+type FECMetrics struct {
+	blocksEncoded       atomic.Uint64
+	blocksDecoded       atomic.Uint64
+	blocksRecovered     atomic.Uint64
+	blocksUnrecoverable atomic.Uint64
+	dataBytes           atomic.Uint64
+	parityBytes         atomic.Uint64
+	reconstructionNanos atomic.Uint64
+}
+
+// FECStats is a point-in-time snapshot of FECMetrics.
+type FECStats struct {
+	// BlocksEncoded is the number of blocks encoded.
+	BlocksEncoded uint64
+	// BlocksDecoded is the number of blocks decoded successfully.
+	BlocksDecoded uint64
+	// BlocksRecovered is the number of decoded blocks that needed parity
+	// to rebuild lost shards.
+	BlocksRecovered uint64
+	// BlocksUnrecoverable is the number of blocks that lost more shards
+	// than the parity could repair.
+	BlocksUnrecoverable uint64
+	// DataBytes is the number of payload bytes encoded.
+	DataBytes uint64
+	// ParityBytes is the number of parity bytes sent on top of the payload.
+	ParityBytes uint64
+	// AvgReconstructionLatency is the average time spent rebuilding lost
+	// shards in a recovered block.
+	AvgReconstructionLatency time.Duration
+}
+
+// defaultFECMetrics is the process-wide FEC metrics instance.
+var defaultFECMetrics = &FECMetrics{}
+
+// DefaultFECMetrics returns the process-wide FEC metrics.
+func DefaultFECMetrics() *FECMetrics {
+	return defaultFECMetrics
+}
+
+// recordEncode records an encoded block.
+func (fm *FECMetrics) recordEncode(dataBytes, parityBytes int) {
+	fm.blocksEncoded.Add(1)
+	fm.dataBytes.Add(uint64(dataBytes))
+	fm.parityBytes.Add(uint64(parityBytes))
+}
+
+// recordDecode records a successfully decoded block. If lost shards had to
+// be rebuilt, elapsed is the time it took.
+func (fm *FECMetrics) recordDecode(recovered bool, elapsed time.Duration) {
+	fm.blocksDecoded.Add(1)
+	if recovered {
+		fm.blocksRecovered.Add(1)
+		fm.reconstructionNanos.Add(uint64(elapsed))
+	}
+}
+
+// recordUnrecoverable records a block that could not be reconstructed.
+func (fm *FECMetrics) recordUnrecoverable() {
+	fm.blocksUnrecoverable.Add(1)
+}
+
+// Snapshot returns the current values of all counters.
+func (fm *FECMetrics) Snapshot() FECStats {
+	stats := FECStats{
+		BlocksEncoded:       fm.blocksEncoded.Load(),
+		BlocksDecoded:       fm.blocksDecoded.Load(),
+		BlocksRecovered:     fm.blocksRecovered.Load(),
+		BlocksUnrecoverable: fm.blocksUnrecoverable.Load(),
+		DataBytes:           fm.dataBytes.Load(),
+		ParityBytes:         fm.parityBytes.Load(),
+	}
+	if stats.BlocksRecovered > 0 {
+		stats.AvgReconstructionLatency = time.Duration(fm.reconstructionNanos.Load() / stats.BlocksRecovered)
+	}
+	return stats
+}
+
+// Overhead returns the parity bytes sent per payload byte, e.g. 0.3 for
+// 30% overhead.
+func (s FECStats) Overhead() float64 {
+	if s.DataBytes == 0 {
+		return 0
+	}
+	return float64(s.ParityBytes) / float64(s.DataBytes)
+}
+
+// RecoveryRate returns the fraction of decoded blocks that needed parity.
+// A rate near zero means the parity is mostly wasted bandwidth.
+func (s FECStats) RecoveryRate() float64 {
+	if s.BlocksDecoded == 0 {
+		return 0
+	}
+	return float64(s.BlocksRecovered) / float64(s.BlocksDecoded)
+}
+
+// countMissing returns the number of nil shards.
+func countMissing(shards [][]byte) int {
+	missing := 0
+	for _, shard := range shards {
+		if shard == nil {
+			missing++
+		}
+	}
+	return missing
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFECMetricsCounters(t *testing.T) {
+	metrics := &FECMetrics{}
+	fec, err := NewFEC(4, 2)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+	fec.SetMetrics(metrics)
+
+	data := make([]byte, 400)
+
+	// A clean block is decoded without parity
+	shards, err := fec.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	if _, err := fec.Decode(shards); err != nil {
+		t.Fatalf("Failed to decode shards: %v", err)
+	}
+
+	// A block with a lost shard is recovered using parity
+	shards, err = fec.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	shards[1] = nil
+	if _, err := fec.Decode(shards); err != nil {
+		t.Fatalf("Failed to decode shards: %v", err)
+	}
+
+	// A block that lost more shards than it has parity is unrecoverable
+	shards, err = fec.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	shards[0], shards[1], shards[2] = nil, nil, nil
+	if _, err := fec.Decode(shards); err == nil {
+		t.Fatal("Expected decode to fail with too many lost shards")
+	}
+
+	stats := metrics.Snapshot()
+	if stats.BlocksEncoded != 3 {
+		t.Errorf("Expected 3 blocks encoded, got %d", stats.BlocksEncoded)
+	}
+	if stats.BlocksDecoded != 2 {
+		t.Errorf("Expected 2 blocks decoded, got %d", stats.BlocksDecoded)
+	}
+	if stats.BlocksRecovered != 1 {
+		t.Errorf("Expected 1 block recovered, got %d", stats.BlocksRecovered)
+	}
+	if stats.BlocksUnrecoverable != 1 {
+		t.Errorf("Expected 1 unrecoverable block, got %d", stats.BlocksUnrecoverable)
+	}
+	// Each shard holds a quarter of the data and the 4-byte length prefix
+	if stats.DataBytes != 3*400 || stats.ParityBytes != 3*2*101 {
+		t.Errorf("Unexpected byte counters: data=%d parity=%d", stats.DataBytes, stats.ParityBytes)
+	}
+	if stats.Overhead() != 606.0/1200 {
+		t.Errorf("Expected overhead 0.505, got %f", stats.Overhead())
+	}
+	if stats.RecoveryRate() != 0.5 {
+		t.Errorf("Expected recovery rate 0.5, got %f", stats.RecoveryRate())
+	}
+	if stats.AvgReconstructionLatency <= 0 {
+		t.Errorf("Expected a positive reconstruction latency, got %v", stats.AvgReconstructionLatency)
+	}
+}
+
+func TestAdaptiveFECMetricsSurviveAdjust(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(4, 2, 1, 6)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+	metrics := &FECMetrics{}
+	if err := adaptiveFEC.SetMetrics(metrics); err != nil {
+		t.Fatalf("Failed to set metrics: %v", err)
+	}
+
+	if _, err := adaptiveFEC.Encode([]byte("before adjustment")); err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	if err := adaptiveFEC.Adjust(&TelemetryData{Loss: 0.2, RTT: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+	if _, err := adaptiveFEC.Encode([]byte("after adjustment")); err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	if _, err := adaptiveFEC.CodecFor(StreamTypeInteractive).Encode([]byte("interactive")); err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+
+	if got := metrics.Snapshot().BlocksEncoded; got != 3 {
+		t.Errorf("Expected 3 blocks encoded across adjustments, got %d", got)
+	}
+}
+
+func TestTelemetryCollectorIncludesFECStats(t *testing.T) {
+	metrics := &FECMetrics{}
+	metrics.recordEncode(100, 30)
+
+	collector := NewTelemetryCollector(nil)
+	collector.SetFECMetrics(metrics)
+
+	data := collector.Collect()
+	if data.FEC == nil {
+		t.Fatal("Expected FEC stats in telemetry data")
+	}
+	if data.FEC.BlocksEncoded != 1 || data.FEC.ParityBytes != 30 {
+		t.Errorf("Unexpected FEC stats: %+v", *data.FEC)
+	}
+}
@@ -1,27 +1,87 @@
 package core
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
 )
 
-// headerPool is a pool of byte slices used for FEC stream headers to reduce memory allocations
-var headerPool = sync.Pool{
+const (
+	// fecFrameHeaderSize is the size of the header in front of every shard
+	// on an FEC stream.
+	fecFrameHeaderSize = 14
+	// maxFECShardSize is the largest shard a reader accepts.
+	maxFECShardSize = maxMessageSize
+	// defaultFECFlushDelay is how long an interleaved FEC stream holds a
+	// partial group before sending it.
+	defaultFECFlushDelay = 10 * time.Millisecond
+)
+
+// framePool is a pool of byte slices used for FEC stream frames to reduce memory allocations
+var framePool = sync.Pool{
 	New: func() interface{} {
-		// Headers are fixed size of 8 bytes
-		return make([]byte, 8)
+		// Initial capacity of 1024 bytes, will grow as needed
+		return make([]byte, 1024)
 	},
 }
 
-// FECStream wraps a quic.Stream to add FEC capabilities.
+// FECStream wraps a quic.Stream to add FEC capabilities. Every write is
+// encoded into shards, which are sent as frames with the codec and the
+// position of the shard in its interleaving group. Reads regroup the frames
+// and decode them with the codec the peer used, recovering shards that were
+// lost on the way from parity.
 type FECStream struct {
 	stream quic.Stream
-	fec    *FEC
-	k      int // number of data shards
-	m      int // number of parity shards
+	fec    FECCodec
+	// adaptive picks the codec of every write by streamType instead of
+	// fec; nil if the codec is fixed.
+	adaptive *AdaptiveFEC
+	// streamType is the type of the stream, which adaptive picks the codec
+	// for.
+	streamType uint8
+	// interleaver interleaves shards of consecutive writes; nil if disabled.
+	interleaver *Interleaver
+	// flushDelay is how long a partial interleaving group is held before
+	// it is sent.
+	flushDelay time.Duration
+	
+	// writeMutex serializes writes with the flush timer.
+	writeMutex sync.Mutex
+	// pending holds blocks waiting to be sent as one interleaving group.
+	pending [][]byte
+	// flushTimer sends the pending blocks once flushDelay has passed.
+	flushTimer *time.Timer
+	// flushErr is the error of the last flush run by the timer. It is
+	// returned by the next write.
+	flushErr error
+	// group is the sequence number of the next interleaving group sent.
+	group uint32
+	
+	// readBuf holds decoded data not returned by Read yet.
+	readBuf []byte
+	// carry is the first frame of the next group, read while looking for
+	// the end of the previous one.
+	carry *fecFrame
+	// readErr is returned by Read once readBuf is drained.
+	readErr error
+}
+
+// fecFrame is a shard received on an FEC stream.
+type fecFrame struct {
+	InterleavedShard
+	// codec is the wire ID of the codec the block was encoded with.
+	codec byte
+	// dataShards is the number of data shards per block.
+	dataShards int
+	// total is the number of shards per block.
+	total int
+	// group is the sequence number of the interleaving group.
+	group uint32
 }
 
 // NewFECStream creates a new FECStream.
@@ -33,66 +93,309 @@ func NewFECStream(stream quic.Stream, k, m int) (*FECStream, error) {
 	return &FECStream{
 		stream: stream,
 		fec:    fec,
-		k:      k,
-		m:      m,
+	}, nil
+}
+
+// NewFECStreamWithCodec creates a new FECStream that encodes every write
+// with the given codec.
+func NewFECStreamWithCodec(stream quic.Stream, codec FECCodec) *FECStream {
+	return &FECStream{
+		stream: stream,
+		fec:    codec,
+	}
+}
+
+// NewAdaptiveFECStream creates a new FECStream that encodes every write
+// with the codec adaptiveFEC picks for the stream type at that time: XOR
+// parity for interactive and telemetry streams, Reed-Solomon for bulk
+// streams. Writes are not interleaved, so they are sent right away.
+func NewAdaptiveFECStream(stream quic.Stream, adaptiveFEC *AdaptiveFEC, streamType uint8) *FECStream {
+	return &FECStream{
+		stream:     stream,
+		adaptive:   adaptiveFEC,
+		streamType: streamType,
+	}
+}
+
+// NewInterleavedFECStream creates a new FECStream that interleaves the shards
+// of up to depth consecutive writes on the wire, so that a burst of lost
+// packets is spread across several blocks. Writes are buffered until depth
+// blocks are pending, Flush is called, or the first pending block has
+// waited for the flush delay.
+func NewInterleavedFECStream(stream quic.Stream, k, m, depth int) (*FECStream, error) {
+	interleaver, err := NewInterleaver(k, m, depth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create interleaver: %w", err)
+	}
+	return &FECStream{
+		stream:      stream,
+		fec:         interleaver.fec,
+		interleaver: interleaver,
+		flushDelay:  defaultFECFlushDelay,
+		pending:     make([][]byte, 0, depth),
 	}, nil
 }
 
 // Write writes data to the stream with FEC encoding.
 func (f *FECStream) Write(p []byte) (n int, err error) {
-	// Encode data into shards
-	shards, err := f.fec.Encode(p)
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	
+	if f.flushErr != nil {
+		return 0, f.flushErr
+	}
+	
+	if f.interleaver != nil {
+		// Copy the data since the caller may reuse p after Write returns
+		block := make([]byte, len(p))
+		copy(block, p)
+		f.pending = append(f.pending, block)
+		
+		if len(f.pending) >= f.interleaver.Depth() {
+			if err := f.flush(); err != nil {
+				return 0, err
+			}
+		} else if f.flushTimer == nil {
+			// Do not hold sparse writes until the group fills up
+			f.flushTimer = time.AfterFunc(f.flushDelay, f.flushPending)
+		}
+		return len(p), nil
+	}
+	
+	// Encode data into pooled shards
+	codec := f.fec
+	if f.adaptive != nil {
+		codec = f.adaptive.CodecFor(f.streamType)
+	}
+	id, dataShards, total, err := codecLayout(codec)
+	if err != nil {
+		return 0, err
+	}
+	block, err := codec.EncodeBlock(p)
 	if err != nil {
 		return 0, fmt.Errorf("failed to encode data: %w", err)
 	}
 	
-	// Ensure shards are returned to pool
-	defer f.fec.ReturnShards(shards)
+	// Ensure shards are returned to pool once they have been written
+	defer block.Release()
 	
-	// Send each shard with a header indicating shard index and total count
-	for i, shard := range shards {
-		// Get header from pool
-		header := headerPool.Get().([]byte)
-		header[0] = byte(i)
-		header[1] = byte(f.k + f.m)
-		// Note: This is a simplified header format. In a real implementation,
-		// you would need to handle data length and use a more robust framing.
-		
-		// Write header and shard data
-		if _, err := f.stream.Write(header); err != nil {
-			// Return header to pool before returning error
-			headerPool.Put(header)
-			return 0, fmt.Errorf("failed to write header: %w", err)
-		}
-		
-		if _, err := f.stream.Write(shard); err != nil {
-			// Return header to pool before returning error
-			headerPool.Put(header)
-			return 0, fmt.Errorf("failed to write shard: %w", err)
+	// Send each shard with a header indicating codec, shard index and total count
+	for i, shard := range block.Shards {
+		if err := f.writeShard(InterleavedShard{Block: 0, Blocks: 1, Index: i, Data: shard}, id, dataShards, total); err != nil {
+			return 0, err
 		}
-		
-		// Return header to pool
-		headerPool.Put(header)
 	}
+	f.group++
 	
 	return len(p), nil
 }
 
-// Read reads data from the stream and decodes it with FEC.
+// Flush sends all pending blocks as one interleaving group.
+// It is a no-op if interleaving is disabled or nothing is pending.
+func (f *FECStream) Flush() error {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	return f.flush()
+}
+
+// flushPending is run by the flush timer. Its error is returned by the
+// next write.
+func (f *FECStream) flushPending() {
+	f.writeMutex.Lock()
+	defer f.writeMutex.Unlock()
+	f.flushTimer = nil
+	if err := f.flush(); err != nil && f.flushErr == nil {
+		f.flushErr = err
+	}
+}
+
+// flush sends all pending blocks. The caller must hold writeMutex.
+func (f *FECStream) flush() error {
+	if f.flushTimer != nil {
+		f.flushTimer.Stop()
+		f.flushTimer = nil
+	}
+	if f.interleaver == nil || len(f.pending) == 0 {
+		return nil
+	}
+	
+	group, err := f.interleaver.Encode(f.pending)
+	f.pending = f.pending[:0]
+	if err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+	
+	// Ensure shards are returned to pool once they have been written
+	defer group.Release()
+	
+	id, dataShards, total, err := codecLayout(f.interleaver.fec)
+	if err != nil {
+		return err
+	}
+	for _, shard := range group.Shards {
+		if err := f.writeShard(shard, id, dataShards, total); err != nil {
+			return err
+		}
+	}
+	f.group++
+	
+	return nil
+}
+
+// writeShard writes a single shard as one frame, preceded by its 14-byte
+// header: codec ID, data shards, shard index, total shards, block index,
+// blocks in group, the group sequence number and the shard length (4 bytes
+// each, big endian). The block's data length is encoded in the block
+// itself.
+func (f *FECStream) writeShard(shard InterleavedShard, codec byte, dataShards, total int) error {
+	// Get frame buffer from pool
+	frame := framePool.Get().([]byte)
+	defer func() { framePool.Put(frame[:cap(frame)]) }()
+	size := fecFrameHeaderSize + len(shard.Data)
+	if cap(frame) < size {
+		frame = make([]byte, size)
+	}
+	frame = frame[:size]
+	
+	frame[0] = codec
+	frame[1] = byte(dataShards)
+	frame[2] = byte(shard.Index)
+	frame[3] = byte(total)
+	frame[4] = byte(shard.Block)
+	frame[5] = byte(shard.Blocks)
+	binary.BigEndian.PutUint32(frame[6:], f.group)
+	binary.BigEndian.PutUint32(frame[10:], uint32(len(shard.Data)))
+	copy(frame[fecFrameHeaderSize:], shard.Data)
+	
+	// Write header and shard data at once, so a frame is lost as a whole
+	if _, err := f.stream.Write(frame); err != nil {
+		return fmt.Errorf("failed to write shard: %w", err)
+	}
+	
+	return nil
+}
+
+// Read reads data from the stream and decodes it with FEC. Data is
+// returned once its whole interleaving group has been received and
+// decoded.
 func (f *FECStream) Read(p []byte) (n int, err error) {
-	// This is a simplified implementation. In a real implementation,
-	// you would need to buffer incoming shards and reconstruct the data
-	// when enough shards are received.
+	for len(f.readBuf) == 0 {
+		if f.readErr != nil {
+			return 0, f.readErr
+		}
+		if err := f.readGroup(); err != nil {
+			f.readErr = err
+		}
+	}
 	
-	// For now, we'll just read from the underlying stream directly.
-	return f.stream.Read(p)
+	n = copy(p, f.readBuf)
+	f.readBuf = f.readBuf[n:]
+	return n, nil
 }
 
-// Close closes the underlying stream.
+// readGroup reads the frames of the next interleaving group and decodes its
+// blocks into readBuf. A group ends once all its shards have arrived, or a
+// shard of a later group or the end of the stream shows that the rest was
+// lost; lost shards are then recovered from parity.
+func (f *FECStream) readGroup() error {
+	var shards []InterleavedShard
+	var first *fecFrame
+	if f.carry != nil {
+		first = f.carry
+		shards = append(shards, f.carry.InterleavedShard)
+		f.carry = nil
+	}
+	
+	var readErr error
+	for first == nil || len(shards) < first.total*first.Blocks {
+		frame, err := f.readFrame()
+		if err != nil {
+			readErr = err
+			break
+		}
+		if first == nil {
+			first = frame
+		} else if frame.group != first.group || frame.codec != first.codec || frame.dataShards != first.dataShards || frame.total != first.total {
+			f.carry = frame
+			break
+		}
+		shards = append(shards, frame.InterleavedShard)
+	}
+	if len(shards) == 0 {
+		return readErr
+	}
+	
+	codec, err := codecFromLayout(first.codec, first.dataShards, first.total)
+	if err != nil {
+		return fmt.Errorf("failed to decode group %d: %w", first.group, err)
+	}
+	blocks, err := decodeGroup(codec, first.total, shards)
+	if err != nil {
+		return fmt.Errorf("failed to decode group %d: %w", first.group, err)
+	}
+	for _, block := range blocks {
+		f.readBuf = append(f.readBuf, block...)
+	}
+	return readErr
+}
+
+// readFrame reads the next shard frame written by writeShard.
+func (f *FECStream) readFrame() (*fecFrame, error) {
+	var header [fecFrameHeaderSize]byte
+	if _, err := io.ReadFull(f.stream, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read frame header: %w", err)
+		}
+		return nil, err
+	}
+	
+	size := binary.BigEndian.Uint32(header[10:])
+	if size > maxFECShardSize {
+		return nil, fmt.Errorf("shard too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.stream, data); err != nil {
+		return nil, fmt.Errorf("failed to read shard: %w", err)
+	}
+	
+	return &fecFrame{
+		InterleavedShard: InterleavedShard{
+			Index:  int(header[2]),
+			Block:  int(header[4]),
+			Blocks: int(header[5]),
+			Data:   data,
+		},
+		codec:      header[0],
+		dataShards: int(header[1]),
+		total:      int(header[3]),
+		group:      binary.BigEndian.Uint32(header[6:]),
+	}, nil
+}
+
+// Close flushes any pending blocks and closes the underlying stream.
 func (f *FECStream) Close() error {
+	if err := f.Flush(); err != nil {
+		f.stream.Close()
+		return err
+	}
 	return f.stream.Close()
 }
 
+// CancelRead aborts receiving on the underlying stream.
+func (f *FECStream) CancelRead(code quic.StreamErrorCode) {
+	f.stream.CancelRead(code)
+}
+
+// CancelWrite aborts sending on the underlying stream.
+func (f *FECStream) CancelWrite(code quic.StreamErrorCode) {
+	f.stream.CancelWrite(code)
+}
+
+// Context returns the context of the underlying stream.
+func (f *FECStream) Context() context.Context {
+	return f.stream.Context()
+}
+
 // StreamID returns the ID of the underlying stream.
 func (f *FECStream) StreamID() quic.StreamID {
 	return f.stream.StreamID()
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"bytes"
+	"sync"
 	"testing"
 )
 
@@ -192,28 +194,222 @@ func TestFECMemoryPool(t *testing.T) {
 	
 	// Encode and decode multiple times to test pool usage
 	for i := 0; i < 5; i++ {
-		shards, err := fec.Encode(data)
+		block, err := fec.EncodeBlock(data)
 		if err != nil {
 			t.Fatalf("Failed to encode data: %v", err)
 		}
 
-		decodedData, err := fec.Decode(shards)
+		decoded, err := fec.DecodeBuffer(block.Shards)
 		if err != nil {
-			fec.ReturnShards(shards) // Return shards before failing
+			block.Release() // Release shards before failing
 			t.Fatalf("Failed to decode shards: %v", err)
 		}
 		
 		// Verify the decoded data matches the original
-		if string(decodedData[:len(data)]) != string(data) {
-			fec.ReturnShards(shards)
-			fec.ReturnData(decodedData)
+		if string(decoded.Bytes()) != string(data) {
 			t.Errorf("Decoded data does not match original. Got %s, expected %s", 
-				string(decodedData[:len(data)]), string(data))
-			break
+				string(decoded.Bytes()), string(data))
 		}
 		
-		// Return shards to pool
-		fec.ReturnShards(shards)
-		fec.ReturnData(decodedData)
+		// Return shards and data to pool
+		block.Release()
+		decoded.Release()
 	}
 }
+
+// TestFECBlockNotReusedWhileReferenced tests that pooled shards of a live
+// block are never handed out to another block.
+func TestFECBlockNotReusedWhileReferenced(t *testing.T) {
+	fec, err := NewFEC(4, 2)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+
+	held, err := fec.EncodeBlock(bytes.Repeat([]byte{0xAA}, 64))
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	snapshot := make([][]byte, len(held.Shards))
+	for i, shard := range held.Shards {
+		snapshot[i] = append([]byte(nil), shard...)
+	}
+
+	// Churn the pool, releasing every other block twice
+	for i := 0; i < 100; i++ {
+		block, err := fec.EncodeBlock(bytes.Repeat([]byte{byte(i)}, 64))
+		if err != nil {
+			t.Fatalf("Failed to encode data: %v", err)
+		}
+		for _, shard := range block.Shards {
+			for _, h := range held.Shards {
+				if &shard[:cap(shard)][cap(shard)-1] == &h[:cap(h)][cap(h)-1] {
+					t.Fatal("Pooled shard of a live block was handed out again")
+				}
+			}
+		}
+		block.Release()
+		if i%2 == 0 {
+			block.Release()
+		}
+	}
+
+	for i, shard := range held.Shards {
+		if !bytes.Equal(shard, snapshot[i]) {
+			t.Errorf("Shard %d of a live block was modified while referenced", i)
+		}
+	}
+	held.Release()
+}
+
+// TestFECDoubleReleaseDoesNotAlias tests that releasing a block twice does
+// not put its buffers into the pool twice, which would let two later blocks
+// share the same memory.
+func TestFECDoubleReleaseDoesNotAlias(t *testing.T) {
+	fec, err := NewFEC(2, 1)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+
+	block, err := fec.EncodeBlock([]byte("released twice"))
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	block.Release()
+	block.Release()
+
+	a, err := fec.EncodeBlock([]byte("first block data"))
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	defer a.Release()
+	b, err := fec.EncodeBlock([]byte("second block data"))
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	defer b.Release()
+
+	seen := make(map[*byte]bool)
+	for _, shard := range append(a.Shards, b.Shards...) {
+		base := &shard[:cap(shard)][0]
+		if seen[base] {
+			t.Fatal("Two live blocks share the same shard buffer")
+		}
+		seen[base] = true
+	}
+}
+
+// TestFECDecodeDoesNotTakeOwnership tests that decoding leaves the caller's
+// shards intact and usable.
+func TestFECDecodeDoesNotTakeOwnership(t *testing.T) {
+	fec, err := NewFEC(3, 2)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+
+	data := []byte("caller owned shards stay valid after decode")
+	shards, err := fec.Encode(data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %v", err)
+	}
+	snapshot := make([][]byte, len(shards))
+	for i, shard := range shards {
+		snapshot[i] = append([]byte(nil), shard...)
+	}
+
+	if _, err := fec.Decode(shards); err != nil {
+		t.Fatalf("Failed to decode shards: %v", err)
+	}
+
+	// Churn the pool; the caller's shards must not be handed out
+	for i := 0; i < 50; i++ {
+		block, err := fec.EncodeBlock(bytes.Repeat([]byte{0xFF}, len(data)))
+		if err != nil {
+			t.Fatalf("Failed to encode data: %v", err)
+		}
+		block.Release()
+	}
+
+	for i, shard := range shards {
+		if !bytes.Equal(shard, snapshot[i]) {
+			t.Errorf("Shard %d was modified after decode", i)
+		}
+	}
+}
+
+// TestFECConcurrentBlocks exercises pooled encode/decode from many
+// goroutines; run with -race to detect buffers shared between blocks.
+func TestFECConcurrentBlocks(t *testing.T) {
+	fec, err := NewFEC(4, 2)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte(worker)}, 256)
+			for i := 0; i < 200; i++ {
+				block, err := fec.EncodeBlock(data)
+				if err != nil {
+					t.Errorf("Failed to encode data: %v", err)
+					return
+				}
+				// Lose a shard so reconstruction runs too
+				block.Shards[i%len(block.Shards)] = nil
+
+				decoded, err := fec.DecodeBuffer(block.Shards)
+				if err != nil {
+					block.Release()
+					t.Errorf("Failed to decode shards: %v", err)
+					return
+				}
+				if !bytes.Equal(decoded.Bytes(), data) {
+					t.Errorf("Worker %d: decoded data corrupted", worker)
+				}
+				decoded.Release()
+				block.Release()
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// TestFECConcurrentMixedSizes tests that blocks of different sizes encoded
+// concurrently decode to their own length, which must not depend on the
+// last block the encoder saw.
+func TestFECConcurrentMixedSizes(t *testing.T) {
+	fec, err := NewFEC(4, 2)
+	if err != nil {
+		t.Fatalf("Failed to create FEC: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				data := bytes.Repeat([]byte{byte(worker)}, 1+(worker*131+i*17)%1500)
+				shards, err := fec.Encode(data)
+				if err != nil {
+					t.Errorf("Failed to encode data: %v", err)
+					return
+				}
+				shards[i%len(shards)] = nil
+
+				decoded, err := fec.Decode(shards)
+				if err != nil {
+					t.Errorf("Failed to decode shards: %v", err)
+					return
+				}
+				if !bytes.Equal(decoded, data) {
+					t.Errorf("Worker %d: decoded %d bytes, expected %d", worker, len(decoded), len(data))
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultLogMaxBackups is the number of rotated log files kept when no
+// number is configured.
+const defaultLogMaxBackups = 3
+
+// rotatingFile is a log file that is rotated when it reaches a maximum
+// size. The current file is renamed to <name>.1, <name>.1 to <name>.2 and
+// so on; files beyond the maximum number of backups are removed. It is safe
+// for concurrent use.
+type rotatingFile struct {
+	// path is the path of the current log file.
+	path string
+	// maxSize is the size in bytes at which the file is rotated.
+	// Zero disables rotation.
+	maxSize int64
+	// maxBackups is the number of rotated files to keep.
+	maxBackups int
+	// file is the current log file.
+	file *os.File
+	// size is the size of the current log file.
+	size int64
+	// mutex protects file and size.
+	mutex sync.Mutex
+}
+
+// newRotatingFile opens the log file at path for appending, creating it
+// and its directory if needed.
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if maxBackups <= 0 {
+		maxBackups = defaultLogMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the current log file.
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Write writes a log record, rotating the file first if the record would
+// make it exceed the maximum size.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, moves the current file to the first backup
+// and opens a new file.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	rf.file = nil
+
+	os.Remove(rf.backupPath(rf.maxBackups))
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+	}
+	if err := os.Rename(rf.path, rf.backupPath(1)); err != nil {
+		// Keep writing to the current file
+		if openErr := rf.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	return rf.open()
+}
+
+// backupPath returns the path of the n-th backup.
+func (rf *rotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
+
+// Close closes the log file.
+func (rf *rotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -1,9 +1,14 @@
 package core
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,127 +38,393 @@ func (l LogLevel) String() string {
 	}
 }
 
-// Logger represents a logger with configurable log level
-type Logger struct {
-	level LogLevel
-	mu    sync.Mutex
+// slogLevel returns the slog level of the log level
+func (l LogLevel) slogLevel() slog.Level {
+	switch l {
+	case DebugLevel:
+		return slog.LevelDebug
+	case WarnLevel:
+		return slog.LevelWarn
+	case ErrorLevel:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }
 
-// NewLogger creates a new logger with the specified log level
-func NewLogger(level string) *Logger {
-	l := &Logger{}
-	
-	switch level {
+// ParseLogLevel parses a log level name (debug, info, warn, error)
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
 	case "debug":
-		l.level = DebugLevel
-	case "info":
-		l.level = InfoLevel
-	case "warn":
-		l.level = WarnLevel
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
 	case "error":
-		l.level = ErrorLevel
+		return ErrorLevel, nil
 	default:
-		l.level = InfoLevel
+		return InfoLevel, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// LogOptions configures the output of a logger
+type LogOptions struct {
+	// Level is the default log level (debug, info, warn, error).
+	Level string
+	// Format is the output format, "text" (default) or "json".
+	Format string
+	// Components overrides the level of individual components,
+	// e.g. {"fec": "debug", "multipath": "warn"}.
+	Components map[string]string
+	// File is the log file. Logs are written to stderr if it is empty.
+	File string
+	// MaxSize is the size in bytes at which the log file is rotated.
+	// Zero disables rotation.
+	MaxSize int64
+	// MaxBackups is the number of rotated log files to keep.
+	MaxBackups int
+}
+
+// logConfig is the resolved configuration of a logger
+type logConfig struct {
+	// handler formats and writes the records.
+	handler slog.Handler
+	// level is the default log level.
+	level LogLevel
+	// components holds the per-component level overrides.
+	components map[string]LogLevel
+	// closer closes the log file once its writers are done, or is nil
+	// when logging to stderr.
+	closer *logFileCloser
+}
+
+// logFileCloser closes a log file that records may still be written to.
+// Writers hold the read lock while handing a record to the handler, so
+// Close waits for them, and records written after it go to the file of
+// the new configuration.
+type logFileCloser struct {
+	// file is the log file.
+	file io.Closer
+	// closed is true once the file was closed.
+	closed bool
+	// mutex is held for reading by writers and for writing by Close.
+	mutex sync.RWMutex
+}
+
+// Close waits for the writers of the file and closes it.
+func (c *logFileCloser) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+// newLogConfig resolves log options
+func newLogConfig(opts LogOptions) (*logConfig, error) {
+	level, err := ParseLogLevel(opts.Level)
+	if err != nil {
+		return nil, err
 	}
-	
+
+	components := make(map[string]LogLevel, len(opts.Components))
+	for component, name := range opts.Components {
+		componentLevel, err := ParseLogLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for component %s: %w", component, err)
+		}
+		components[component] = componentLevel
+	}
+
+	var out io.Writer = os.Stderr
+	var closer *logFileCloser
+	if opts.File != "" {
+		file, err := newRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out, closer = file, &logFileCloser{file: file}
+	}
+
+	// Levels are filtered before records reach the handler
+	handlerOptions := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(out, handlerOptions)
+	case "text", "":
+		handler = slog.NewTextHandler(out, handlerOptions)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	return &logConfig{
+		handler:    handler,
+		level:      level,
+		components: components,
+		closer:     closer,
+	}, nil
+}
+
+// Logger writes structured log records. Loggers derived with With or
+// Component share the configuration of their parent, so reconfiguring the
+// parent applies to all of them.
+type Logger struct {
+	// config is the shared configuration, or nil to discard all records.
+	config *atomic.Pointer[logConfig]
+	// component is the component the records belong to, if any.
+	component string
+	// attrs are the fields added to every record.
+	attrs []slog.Attr
+}
+
+// NewLogger creates a new logger with the specified log level that writes
+// text to stderr
+func NewLogger(level string) *Logger {
+	l := &Logger{config: new(atomic.Pointer[logConfig])}
+	l.SetLevel(level)
 	return l
 }
 
-// SetLevel sets the log level
+// NewLoggerWithOptions creates a new logger with the specified options
+func NewLoggerWithOptions(opts LogOptions) (*Logger, error) {
+	l := &Logger{config: new(atomic.Pointer[logConfig])}
+	if err := l.Configure(opts); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Configure replaces the output and levels of the logger and all loggers
+// derived from it. A previously opened log file is closed once the records
+// being written to it are written.
+func (l *Logger) Configure(opts LogOptions) error {
+	config, err := newLogConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	// New records go to the new output before the old file is closed
+	old := l.config.Swap(config)
+	if old != nil && old.closer != nil {
+		old.closer.Close()
+	}
+	return nil
+}
+
+// SetLevel sets the default log level, keeping the output and the
+// component overrides. Unknown levels fall back to info.
 func (l *Logger) SetLevel(level string) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	
-	switch level {
-	case "debug":
-		l.level = DebugLevel
-	case "info":
-		l.level = InfoLevel
-	case "warn":
-		l.level = WarnLevel
-	case "error":
-		l.level = ErrorLevel
-	default:
-		l.level = InfoLevel
+	parsed, _ := ParseLogLevel(level)
+
+	for {
+		old := l.config.Load()
+		config := &logConfig{
+			handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+			level:   parsed,
+		}
+		if old != nil {
+			updated := *old
+			updated.level = parsed
+			config = &updated
+		}
+		if l.config.CompareAndSwap(old, config) {
+			return
+		}
+	}
+}
+
+// With returns a logger that adds the given key/value pairs to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	child := *l
+	child.attrs = append(append([]slog.Attr(nil), l.attrs...), argsToAttrs(args)...)
+	return &child
+}
+
+// Component returns a logger for a component. Its records carry a
+// component field and are filtered by the component's level override.
+func (l *Logger) Component(name string) *Logger {
+	child := *l
+	child.component = name
+	return &child
+}
+
+// Enabled reports whether records of the given level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	if l == nil || l.config == nil {
+		return false
 	}
+	config := l.config.Load()
+	if config == nil {
+		return false
+	}
+	if componentLevel, ok := config.components[l.component]; ok {
+		return level >= componentLevel
+	}
+	return level >= config.level
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= DebugLevel {
-		l.log(DebugLevel, format, args...)
-	}
+	l.logf(DebugLevel, format, args...)
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= InfoLevel {
-		l.log(InfoLevel, format, args...)
-	}
+	l.logf(InfoLevel, format, args...)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= WarnLevel {
-		l.log(WarnLevel, format, args...)
-	}
+	l.logf(WarnLevel, format, args...)
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= ErrorLevel {
-		l.log(ErrorLevel, format, args...)
+	l.logf(ErrorLevel, format, args...)
+}
+
+// Log logs a message with key/value fields, e.g.
+// Log(InfoLevel, "path added", "path", addr, "rtt", rtt)
+func (l *Logger) Log(level LogLevel, msg string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, msg, argsToAttrs(args))
+}
+
+// logf logs a formatted message
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, args...), nil)
+}
+
+// write hands a record to the handler. A record whose log file was closed
+// while it was written is written to the output that replaced it.
+func (l *Logger) write(level LogLevel, msg string, attrs []slog.Attr) {
+	record := slog.NewRecord(time.Now(), level.slogLevel(), msg, 0)
+	if l.component != "" {
+		record.AddAttrs(slog.String("component", l.component))
+	}
+	record.AddAttrs(l.attrs...)
+	record.AddAttrs(attrs...)
+
+	for {
+		config := l.config.Load()
+		if config == nil {
+			return
+		}
+		if config.closer == nil {
+			config.handler.Handle(context.Background(), record)
+			return
+		}
+
+		config.closer.mutex.RLock()
+		if !config.closer.closed {
+			config.handler.Handle(context.Background(), record)
+			config.closer.mutex.RUnlock()
+			return
+		}
+		config.closer.mutex.RUnlock()
+		if l.config.Load() == config {
+			// Nothing replaced the closed file
+			return
+		}
 	}
 }
 
-// log writes a log message with the specified level
-func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	
-	timestamp := time.Now().Format("2006/01/02 15:04:05")
-	message := fmt.Sprintf(format, args...)
-	
-	// Write to stderr
-	log.Printf("[%s] %s: %s\n", timestamp, level.String(), message)
-	
-	// Also write to a log file if needed
-	// This is a simplified implementation - in a production system,
-	// you might want to use a more robust logging library like logrus or zap
+// argsToAttrs converts alternating keys and values to attributes. A value
+// without a key is recorded under "!BADKEY", as slog does.
+func argsToAttrs(args []interface{}) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		switch key := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, key)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs = append(attrs, slog.String("!BADKEY", key))
+				return attrs
+			}
+			attrs = append(attrs, slog.Any(key, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", key))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+// loggerContextKey is the context key of the logger attached to a context
+type loggerContextKey struct{}
+
+// ContextWithLogger returns a context that carries a logger
+func ContextWithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, l)
+}
+
+// LoggerFromContext returns the logger carried by a context, or the global
+// logger if there is none
+func LoggerFromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerContextKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger
 }
 
+// defaultLogConfig is the configuration of the global logger. It is nil
+// until InitLogger or ConfigureLogger is called, and nothing is logged
+// until then.
+var defaultLogConfig atomic.Pointer[logConfig]
+
 // Global logger instance
-var defaultLogger *Logger
+var defaultLogger = &Logger{config: &defaultLogConfig}
 
-// InitLogger initializes the global logger with the specified log level
+// InitLogger initializes the global logger with the specified log level,
+// keeping the configured output
 func InitLogger(level string) {
-	defaultLogger = NewLogger(level)
+	defaultLogger.SetLevel(level)
+}
+
+// ConfigureLogger configures the output and levels of the global logger
+// and all component loggers
+func ConfigureLogger(opts LogOptions) error {
+	return defaultLogger.Configure(opts)
+}
+
+// ComponentLogger returns a logger for a component that follows the
+// configuration of the global logger
+func ComponentLogger(name string) *Logger {
+	return defaultLogger.Component(name)
 }
 
 // Debug logs a debug message using the global logger
 func Debug(format string, args ...interface{}) {
-	if defaultLogger != nil {
-		defaultLogger.Debug(format, args...)
-	}
+	defaultLogger.Debug(format, args...)
 }
 
 // Info logs an info message using the global logger
 func Info(format string, args ...interface{}) {
-	if defaultLogger != nil {
-		defaultLogger.Info(format, args...)
-	}
+	defaultLogger.Info(format, args...)
 }
 
 // Warn logs a warning message using the global logger
 func Warn(format string, args ...interface{}) {
-	if defaultLogger != nil {
-		defaultLogger.Warn(format, args...)
-	}
+	defaultLogger.Warn(format, args...)
 }
 
 // Error logs an error message using the global logger
 func Error(format string, args ...interface{}) {
-	if defaultLogger != nil {
-		defaultLogger.Error(format, args...)
-	}
-}
\ No newline at end of file
+	defaultLogger.Error(format, args...)
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readJSONLogs returns the records of a JSON log file.
+func readJSONLogs(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer file.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Failed to decode log record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggerJSONComponentLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vantun.log")
+	logger, err := NewLoggerWithOptions(LogOptions{
+		Level:      "info",
+		Format:     "json",
+		Components: map[string]string{"fec": "debug", "multipath": "warn"},
+		File:       path,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	logger.Debug("hidden by the default level")
+	logger.Component("fec").Debug("shown by the fec override")
+	logger.Component("multipath").Info("hidden by the multipath override")
+	logger.Component("multipath").Warn("path %s lost", "10.0.0.1:4242")
+	logger.With("session_id", "abc123").Log(InfoLevel, "session opened", "remote_addr", "10.0.0.2:5000")
+
+	records := readJSONLogs(t, path)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d: %v", len(records), records)
+	}
+	if records[0]["msg"] != "shown by the fec override" || records[0]["component"] != "fec" || records[0]["level"] != "DEBUG" {
+		t.Errorf("Unexpected fec record %v", records[0])
+	}
+	if records[1]["msg"] != "path 10.0.0.1:4242 lost" || records[1]["level"] != "WARN" {
+		t.Errorf("Unexpected multipath record %v", records[1])
+	}
+	if records[2]["session_id"] != "abc123" || records[2]["remote_addr"] != "10.0.0.2:5000" {
+		t.Errorf("Expected session fields, got %v", records[2])
+	}
+
+	// Lowering the default level keeps the output and the overrides
+	logger.SetLevel("debug")
+	logger.Debug("now shown")
+	logger.Component("multipath").Info("still hidden")
+	if records := readJSONLogs(t, path); len(records) != 4 {
+		t.Errorf("Expected 4 records after lowering the level, got %d", len(records))
+	}
+
+	if _, err := NewLoggerWithOptions(LogOptions{Level: "loud"}); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := NewLoggerWithOptions(LogOptions{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestLoggerConfigureKeepsRecordsBeingWritten(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+	logger, err := NewLoggerWithOptions(LogOptions{Format: "json", File: first})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	// Records written while the file is replaced end up in one of the files
+	const writers = 8
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				logger.Component("test").Info("record %d from writer %d", j, i)
+				written.Add(1)
+			}
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := logger.Configure(LogOptions{Format: "json", File: second}); err != nil {
+		t.Fatalf("Failed to reconfigure logger: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	firstRecords := len(readJSONLogs(t, first))
+	secondRecords := len(readJSONLogs(t, second))
+	if firstRecords == 0 || secondRecords == 0 {
+		t.Fatalf("Expected records in both files, got %d and %d", firstRecords, secondRecords)
+	}
+	if total := int64(firstRecords + secondRecords); total != written.Load() {
+		t.Errorf("Expected %d records, got %d", written.Load(), total)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "vantun.log")
+	file, err := newRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatalf("Failed to open rotating file: %v", err)
+	}
+	defer file.Close()
+
+	line := strings.Repeat("x", 29) + "\n"
+	for i := 0; i < 12; i++ {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	// 360 bytes in files of at most 100 bytes, of which two backups are kept
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 100 {
+			t.Errorf("Expected %s to be at most 100 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected only two backups to be kept")
+	}
+}
+
+func TestEventsLoggedByComponent(t *testing.T) {
+	old := defaultLogConfig.Load()
+	defer func() {
+		if config := defaultLogConfig.Swap(old); config != nil && config.closer != nil {
+			config.closer.Close()
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "vantun.log")
+	if err := ConfigureLogger(LogOptions{
+		Level:      "warn",
+		Format:     "json",
+		Components: map[string]string{"rate": "debug"},
+		File:       path,
+	}); err != nil {
+		t.Fatalf("Failed to configure logger: %v", err)
+	}
+
+	logEvent(&RateChangedEvent{OldRate: 1000, NewRate: 900, Loss: 0.1, Reason: "high_loss"})
+	logEvent(&TelemetryReportedEvent{Data: &TelemetryData{}})
+
+	records := readJSONLogs(t, path)
+	if len(records) != 1 {
+		t.Fatalf("Expected only the rate event, got %v", records)
+	}
+	record := records[0]
+	if record["msg"] != "rate.changed" || record["component"] != "rate" || record["new_rate"] != 900.0 || record["reason"] != "high_loss" {
+		t.Errorf("Unexpected event record %v", record)
+	}
+}
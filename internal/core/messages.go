@@ -10,8 +10,23 @@ const (
 	SessionAccept MessageType = 0x02
 	// StreamType is sent on a stream to identify its type.
 	StreamType MessageType = 0x03
+	// Telemetry is sent on the telemetry stream to report connection
+	// statistics to the peer.
+	Telemetry MessageType = 0x04
+	// MultipathData carries a chunk of a multipath stream.
+	MultipathData MessageType = 0x05
+	// MultipathAck acknowledges the chunks of a multipath stream received
+	// in order.
+	MultipathAck MessageType = 0x06
 )
 
+// TelemetryVersion is the version of the telemetry payload format.
+const TelemetryVersion uint16 = 1
+
+// FeatureMultipath is listed in the handshake features by clients that
+// open multipath sessions and by servers that group their paths.
+const FeatureMultipath = "multipath"
+
 // Message represents a control message exchanged during session negotiation.
 type Message struct {
 	Type MessageType
@@ -26,6 +41,15 @@ type SessionInitPayload struct {
 	Token []byte
 	// SupportedFeatures is a list of features the client supports.
 	SupportedFeatures []string
+	// UpRate is the bandwidth from the client to the server the client
+	// declares (bytes per second), or zero to leave it to rate control.
+	UpRate uint64
+	// DownRate is the bandwidth from the server to the client the client
+	// declares (bytes per second), or zero to leave it to rate control.
+	DownRate uint64
+	// MultipathSessionID is the ID of the multipath session an additional
+	// path joins. It is empty on the first path.
+	MultipathSessionID string
 }
 
 // SessionAcceptPayload represents the payload for a SessionAccept message.
@@ -36,10 +60,67 @@ type SessionAcceptPayload struct {
 	Reason string
 	// ServerFeatures is a list of features the server supports.
 	ServerFeatures []string
+	// SessionID identifies the session in logs and telemetry on both
+	// sides. It is assigned by the server.
+	SessionID string
+	// UpRate is the negotiated bandwidth from the client to the server
+	// (bytes per second). Zero means it is left to rate control.
+	UpRate uint64
+	// DownRate is the negotiated bandwidth from the server to the client
+	// (bytes per second). Zero means it is left to rate control.
+	DownRate uint64
+	// MultipathSessionID is the ID additional paths of a multipath session
+	// present in their handshake. It is issued on the first path if the
+	// client supports FeatureMultipath, and confirmed on the other paths.
+	MultipathSessionID string
 }
 
 // StreamTypePayload represents the payload for a StreamType message.
 type StreamTypePayload struct {
 	// Type is the type of the stream.
 	Type uint8
-}
\ No newline at end of file
+	// Priority is the priority the opener set for the stream, or
+	// PriorityDefault to use the priority of the stream type.
+	Priority StreamPriority
+	// MultipathStream is the ID of the multipath stream whose chunks the
+	// stream carries, or zero.
+	MultipathStream uint64
+	// Data marks a multipath stream that carries the SendData messages of
+	// its opener rather than one opened with OpenMultipathStream.
+	Data bool
+	// FEC marks a stream whose data is sent as FEC frames in both
+	// directions (see FECStream).
+	FEC bool
+}
+
+// TelemetryPayload represents the payload for a Telemetry message.
+type TelemetryPayload struct {
+	// Version is the telemetry payload format version.
+	Version uint16
+	// Data is the reported telemetry data.
+	Data *TelemetryData
+}
+
+// MultipathDataPayload represents the payload for a MultipathData message.
+type MultipathDataPayload struct {
+	// Sequence is the number of the chunk in the multipath stream.
+	Sequence uint64
+	// Data is the chunk.
+	Data []byte
+	// Fin marks the last chunk of the stream. It has no data.
+	Fin bool
+}
+
+// MultipathAckPayload represents the payload for a MultipathAck message.
+type MultipathAckPayload struct {
+	// Next is the sequence number of the first chunk not received yet.
+	// All chunks before it were received.
+	Next uint64
+	// Retransmit asks the sender to send chunk Next again on another path,
+	// since later chunks arrived but it did not.
+	Retransmit bool
+	// Limit is the sequence number of the first chunk with data the
+	// receiver has no buffer for. The sender does not send it before an
+	// acknowledgement raises the limit.
+	Limit uint64
+}
@@ -0,0 +1,428 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// metricsLog logs metrics listener events.
+var metricsLog = ComponentLogger("metrics")
+
+// Bucket upper bounds for the telemetry histograms.
+var (
+	rttBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+	lossBuckets = []float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5}
+)
+
+// Metrics collects process-wide VANTUN metrics and exposes them in the
+// Prometheus text exposition format. It is safe for concurrent use.
+type Metrics struct {
+	// activeSessions is the number of established sessions.
+	activeSessions atomic.Int64
+	// streamsOpened counts opened and accepted streams by stream type.
+	streamsOpened *labeledCounter
+	// userBytes counts stream bytes by user and direction. The series of a
+	// user are deleted once none of its connections are open.
+	userBytes *labeledCounter
+	// pathBytes counts stream bytes by path and direction. The series of a
+	// path are deleted once its connection is closed.
+	pathBytes *labeledCounter
+	// handshakeFailures counts failed handshakes by reason.
+	handshakeFailures *labeledCounter
+	// events counts published events by kind.
+	events *labeledCounter
+	// rtt is the histogram of collected RTT samples in seconds.
+	rtt *histogram
+	// loss is the histogram of collected loss rates.
+	loss *histogram
+	// tokenBucketRate is the current token bucket rate in bytes per second.
+	tokenBucketRate atomic.Uint64
+	// fecDataShards is the current number of FEC data shards.
+	fecDataShards atomic.Int64
+	// fecParityShards is the current number of FEC parity shards.
+	fecParityShards atomic.Int64
+	// fecMetrics is the source of the FEC effectiveness counters.
+	fecMetrics *FECMetrics
+}
+
+// NewMetrics creates a new Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		streamsOpened:     newLabeledCounter("type"),
+		userBytes:         newLabeledCounter("user", "direction"),
+		pathBytes:         newLabeledCounter("path", "direction"),
+		handshakeFailures: newLabeledCounter("reason"),
+		events:            newLabeledCounter("kind"),
+		rtt:               newHistogram(rttBuckets),
+		loss:              newHistogram(lossBuckets),
+		fecMetrics:        defaultFECMetrics,
+	}
+}
+
+// defaultMetrics is the process-wide metrics instance.
+var defaultMetrics = NewMetrics()
+
+// DefaultMetrics returns the process-wide metrics.
+func DefaultMetrics() *Metrics {
+	return defaultMetrics
+}
+
+// SessionOpened records a newly established session.
+func (m *Metrics) SessionOpened() {
+	m.activeSessions.Add(1)
+}
+
+// SessionClosed records a closed session.
+func (m *Metrics) SessionClosed() {
+	m.activeSessions.Add(-1)
+}
+
+// StreamOpened records an opened or accepted stream of the given type.
+func (m *Metrics) StreamOpened(streamType uint8) {
+	m.streamsOpened.add(1, streamTypeName(streamType))
+}
+
+// AddBytesIn records bytes received from a user over a path.
+func (m *Metrics) AddBytesIn(user, path string, n int) {
+	m.userBytes.add(uint64(n), user, "in")
+	m.pathBytes.add(uint64(n), path, "in")
+}
+
+// AddBytesOut records bytes sent to a user over a path.
+func (m *Metrics) AddBytesOut(user, path string, n int) {
+	m.userBytes.add(uint64(n), user, "out")
+	m.pathBytes.add(uint64(n), path, "out")
+}
+
+// meterConnection keeps the byte counters of user and path while conn is
+// open. Their series are deleted once conn, and every other connection
+// metered with the same labels, is closed, so the series of past users and
+// connections do not pile up.
+func (m *Metrics) meterConnection(conn quic.Connection, user, path string) {
+	m.userBytes.acquire(user)
+	m.pathBytes.acquire(path)
+	go func() {
+		<-conn.Context().Done()
+		m.userBytes.release(user)
+		m.pathBytes.release(path)
+	}()
+}
+
+// HandshakeFailed records a failed handshake.
+func (m *Metrics) HandshakeFailed(reason string) {
+	m.handshakeFailures.add(1, reason)
+}
+
+// ObserveTelemetry records the RTT and loss of collected telemetry data.
+// Data without an RTT sample is ignored.
+func (m *Metrics) ObserveTelemetry(data *TelemetryData) {
+	if data == nil || data.RTT <= 0 {
+		return
+	}
+	m.rtt.observe(data.RTT.Seconds())
+	m.loss.observe(data.Loss)
+}
+
+// SetTokenBucketRate records the current token bucket rate.
+func (m *Metrics) SetTokenBucketRate(rate float64) {
+	m.tokenBucketRate.Store(uint64(rate))
+}
+
+// SetFECParams records the current FEC parameters.
+func (m *Metrics) SetFECParams(params FECParams) {
+	m.fecDataShards.Store(int64(params.DataShards))
+	m.fecParityShards.Store(int64(params.ParityShards))
+}
+
+// WatchAdaptiveFEC records the parameters of an AdaptiveFEC now and
+// whenever they change.
+func (m *Metrics) WatchAdaptiveFEC(adaptiveFEC *AdaptiveFEC) {
+	m.SetFECParams(adaptiveFEC.Params())
+	adaptiveFEC.OnParamsChange(func(_, newParams FECParams) {
+		m.SetFECParams(newParams)
+	})
+}
+
+// HandleEvent updates the metrics from a published event.
+func (m *Metrics) HandleEvent(event Event) {
+	m.events.add(1, event.Kind())
+
+	switch e := event.(type) {
+	case *RateChangedEvent:
+		m.SetTokenBucketRate(e.NewRate)
+	case *FECAdjustedEvent:
+		m.SetFECParams(e.New)
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	writeGauge(&b, "vantun_sessions_active", "Number of established sessions.", float64(m.activeSessions.Load()))
+	m.streamsOpened.write(&b, "vantun_streams_opened_total", "Number of streams opened or accepted by stream type.")
+	m.userBytes.write(&b, "vantun_user_bytes_total", "Stream bytes transferred by user and direction.")
+	m.pathBytes.write(&b, "vantun_path_bytes_total", "Stream bytes transferred by path and direction.")
+	m.rtt.write(&b, "vantun_rtt_seconds", "Round-trip time reported by telemetry.")
+	m.loss.write(&b, "vantun_loss_ratio", "Packet loss rate reported by telemetry.")
+	writeGauge(&b, "vantun_token_bucket_rate_bytes", "Current token bucket rate in bytes per second.", float64(m.tokenBucketRate.Load()))
+	writeGauge(&b, "vantun_fec_data_shards", "Current number of FEC data shards.", float64(m.fecDataShards.Load()))
+	writeGauge(&b, "vantun_fec_parity_shards", "Current number of FEC parity shards.", float64(m.fecParityShards.Load()))
+	if m.fecMetrics != nil {
+		stats := m.fecMetrics.Snapshot()
+		writeCounter(&b, "vantun_fec_blocks_encoded_total", "Number of FEC blocks encoded.", stats.BlocksEncoded)
+		writeCounter(&b, "vantun_fec_blocks_recovered_total", "Number of FEC blocks that needed parity to decode.", stats.BlocksRecovered)
+		writeCounter(&b, "vantun_fec_blocks_unrecoverable_total", "Number of FEC blocks that could not be reconstructed.", stats.BlocksUnrecoverable)
+	}
+	m.handshakeFailures.write(&b, "vantun_handshake_failures_total", "Number of failed handshakes by reason.")
+	m.events.write(&b, "vantun_events_total", "Number of events published by kind.")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); err != nil {
+		metricsLog.Warn("Failed to write metrics: %v", err)
+	}
+}
+
+// StartMetricsServer starts an HTTP server on addr that serves the default
+// metrics on /metrics. The server runs until it is closed.
+func StartMetricsServer(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", defaultMetrics)
+
+	server, err := startHTTPServer(addr, mux)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsLog.Info("Serving metrics on http://%s/metrics", server.Addr)
+	return server, nil
+}
+
+// startHTTPServer starts an HTTP server on addr with the given handler.
+// The returned server's Addr is the address actually listened on.
+func startHTTPServer(addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Addr:              listener.Addr().String(),
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			metricsLog.Error("HTTP server on %s failed: %v", server.Addr, err)
+		}
+	}()
+
+	return server, nil
+}
+
+// streamTypeName returns the metrics label of a stream type.
+func streamTypeName(streamType uint8) string {
+	switch streamType {
+	case StreamTypeInteractive:
+		return "interactive"
+	case StreamTypeBulk:
+		return "bulk"
+	case StreamTypeTelemetry:
+		return "telemetry"
+	default:
+		return "unknown"
+	}
+}
+
+// labelValueEscaper escapes label values as the Prometheus text exposition
+// format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labeledCounter is a family of counters that share label names.
+type labeledCounter struct {
+	// labels are the label names.
+	labels []string
+	// values maps joined label values to their counter.
+	values sync.Map
+	// holders counts the holders of the series with a given first label
+	// value. The series are deleted when the last holder releases them.
+	holders map[string]int
+	// mutex protects holders.
+	mutex sync.Mutex
+}
+
+// newLabeledCounter creates a counter family with the given label names.
+func newLabeledCounter(labels ...string) *labeledCounter {
+	return &labeledCounter{labels: labels, holders: make(map[string]int)}
+}
+
+// acquire registers a holder of the series whose first label value is first.
+func (lc *labeledCounter) acquire(first string) {
+	lc.mutex.Lock()
+	lc.holders[first]++
+	lc.mutex.Unlock()
+}
+
+// release unregisters a holder of the series whose first label value is
+// first, and deletes the series once no holder is left.
+func (lc *labeledCounter) release(first string) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	if lc.holders[first]--; lc.holders[first] > 0 {
+		return
+	}
+	delete(lc.holders, first)
+	lc.values.Range(func(key, _ any) bool {
+		if values := strings.Split(key.(string), "\x00"); values[0] == first {
+			lc.values.Delete(key)
+		}
+		return true
+	})
+}
+
+// add adds n to the counter with the given label values.
+func (lc *labeledCounter) add(n uint64, values ...string) {
+	key := strings.Join(values, "\x00")
+	counter, ok := lc.values.Load(key)
+	if !ok {
+		counter, _ = lc.values.LoadOrStore(key, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(n)
+}
+
+// write writes the counter family sorted by label values.
+func (lc *labeledCounter) write(b *strings.Builder, name, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	var keys []string
+	lc.values.Range(func(key, _ any) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		counter, _ := lc.values.Load(key)
+		values := strings.Split(key, "\x00")
+		pairs := make([]string, len(lc.labels))
+		for i, label := range lc.labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelValueEscaper.Replace(values[i]))
+		}
+		fmt.Fprintf(b, "%s{%s} %d\n", name, strings.Join(pairs, ","), counter.(*atomic.Uint64).Load())
+	}
+}
+
+// histogram is a cumulative histogram with fixed bucket upper bounds.
+type histogram struct {
+	// bounds are the bucket upper bounds in increasing order.
+	bounds []float64
+	// counts are the per-bucket observation counts, not cumulative.
+	// The last entry counts observations above every bound.
+	counts []uint64
+	// sum is the sum of all observations.
+	sum float64
+	// count is the number of observations.
+	count uint64
+	// mutex protects counts, sum and count.
+	mutex sync.Mutex
+}
+
+// newHistogram creates a histogram with the given bucket upper bounds.
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// observe records a single observation.
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mutex.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mutex.Unlock()
+}
+
+// write writes the histogram with cumulative buckets.
+func (h *histogram) write(b *strings.Builder, name, help string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+// writeGauge writes a single gauge.
+func writeGauge(b *strings.Builder, name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
+
+// writeCounter writes a single counter without labels.
+func writeCounter(b *strings.Builder, name, help string, value uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// meteredStream counts the bytes read from and written to a stream.
+type meteredStream struct {
+	quic.Stream
+	// metrics receives the byte counts.
+	metrics *Metrics
+	// user is the user label of the stream's session: the identity of the
+	// client on servers.
+	user string
+	// path is the path label of the stream's connection.
+	path string
+}
+
+// newMeteredStream wraps a stream so its bytes are counted for user and path.
+func newMeteredStream(stream quic.Stream, metrics *Metrics, user, path string) *meteredStream {
+	return &meteredStream{
+		Stream:  stream,
+		metrics: metrics,
+		user:    user,
+		path:    path,
+	}
+}
+
+// Read reads from the stream and counts the bytes received.
+func (s *meteredStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		s.metrics.AddBytesIn(s.user, s.path, n)
+	}
+	return n, err
+}
+
+// Write writes to the stream and counts the bytes sent.
+func (s *meteredStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	if n > 0 {
+		s.metrics.AddBytesOut(s.user, s.path, n)
+	}
+	return n, err
+}
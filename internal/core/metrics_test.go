@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.fecMetrics = &FECMetrics{}
+
+	metrics.SessionOpened()
+	metrics.SessionOpened()
+	metrics.SessionClosed()
+	metrics.StreamOpened(StreamTypeInteractive)
+	metrics.StreamOpened(StreamTypeBulk)
+	metrics.StreamOpened(StreamTypeBulk)
+	metrics.HandshakeFailed("rejected")
+	metrics.ObserveTelemetry(&TelemetryData{RTT: 30 * time.Millisecond, Loss: 0.02})
+	metrics.ObserveTelemetry(&TelemetryData{RTT: 2 * time.Second, Loss: 0})
+	metrics.ObserveTelemetry(&TelemetryData{})
+	metrics.SetTokenBucketRate(1500000)
+	metrics.SetFECParams(FECParams{DataShards: 10, ParityShards: 3})
+
+	// Bytes are counted through a metered stream
+	mockStream := &MockQUICStream{readData: []byte("hello")}
+	stream := newMeteredStream(mockStream, metrics, "10.0.0.1", "10.0.0.1:4242")
+	if _, err := stream.Write([]byte("ping!!")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := stream.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+
+	var b strings.Builder
+	if _, err := metrics.WriteTo(&b); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	output := b.String()
+
+	expected := []string{
+		"vantun_sessions_active 1\n",
+		`vantun_streams_opened_total{type="interactive"} 1`,
+		`vantun_streams_opened_total{type="bulk"} 2`,
+		`vantun_user_bytes_total{user="10.0.0.1",direction="in"} 5`,
+		`vantun_user_bytes_total{user="10.0.0.1",direction="out"} 6`,
+		`vantun_path_bytes_total{path="10.0.0.1:4242",direction="out"} 6`,
+		`vantun_rtt_seconds_bucket{le="0.025"} 0`,
+		`vantun_rtt_seconds_bucket{le="0.05"} 1`,
+		`vantun_rtt_seconds_bucket{le="+Inf"} 2`,
+		"vantun_rtt_seconds_count 2\n",
+		`vantun_loss_ratio_bucket{le="0.001"} 1`,
+		`vantun_loss_ratio_bucket{le="0.02"} 2`,
+		"vantun_token_bucket_rate_bytes 1.5e+06\n",
+		"vantun_fec_data_shards 10\n",
+		"vantun_fec_parity_shards 3\n",
+		`vantun_handshake_failures_total{reason="rejected"} 1`,
+		"# TYPE vantun_rtt_seconds histogram\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected metrics output to contain %q", line)
+		}
+	}
+}
+
+// closableConnection is a mock connection whose context ends when cancel
+// is called.
+type closableConnection struct {
+	*MockQUICConnection
+	ctx context.Context
+}
+
+func (c *closableConnection) Context() context.Context {
+	return c.ctx
+}
+
+func TestMetricsConnectionSeries(t *testing.T) {
+	metrics := NewMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &closableConnection{MockQUICConnection: &MockQUICConnection{}, ctx: ctx}
+
+	// Label values are escaped, not quoted as Go strings
+	metrics.meterConnection(conn, "alice\\\"\n\t", "primary")
+	metrics.AddBytesIn("alice\\\"\n\t", "primary", 7)
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	if line := "vantun_user_bytes_total{user=\"alice\\\\\\\"\\n\t\",direction=\"in\"} 7"; !strings.Contains(b.String(), line) {
+		t.Errorf("Expected metrics output to contain %q", line)
+	}
+
+	// The series are deleted once the connection is closed
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.Reset()
+		metrics.WriteTo(&b)
+		if !strings.Contains(b.String(), "alice") && !strings.Contains(b.String(), `path="primary"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the series of the closed connection to be deleted:\n%s", b.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsWatchAdaptiveFEC(t *testing.T) {
+	adaptiveFEC, err := NewAdaptiveFEC(4, 2, 1, 6)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+
+	metrics := NewMetrics()
+	metrics.WatchAdaptiveFEC(adaptiveFEC)
+	if got := metrics.fecParityShards.Load(); got != 2 {
+		t.Errorf("Expected 2 parity shards, got %d", got)
+	}
+
+	if err := adaptiveFEC.Adjust(&TelemetryData{Loss: 0.2}); err != nil {
+		t.Fatalf("Failed to adjust FEC: %v", err)
+	}
+	if got := metrics.fecParityShards.Load(); got != int64(adaptiveFEC.Params().ParityShards) {
+		t.Errorf("Expected parity shards to follow adjustment, got %d", got)
+	}
+}
+
+func TestHandshakeFailureReason(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{newHandshakeError("rejected", fmt.Errorf("server rejected session")), "rejected"},
+		{fmt.Errorf("handshake failed: %w", newHandshakeError("read", io.EOF)), "read"},
+		{fmt.Errorf("failed: %w", context.DeadlineExceeded), "timeout"},
+		{io.EOF, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := handshakeFailureReason(tt.err); got != tt.reason {
+			t.Errorf("handshakeFailureReason(%v) = %q, expected %q", tt.err, got, tt.reason)
+		}
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	server, err := StartMetricsServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start metrics server: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to fetch metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if !strings.Contains(string(body), "# TYPE vantun_sessions_active gauge") {
+		t.Errorf("Expected sessions gauge in response, got:\n%s", body)
+	}
+}
@@ -2,18 +2,32 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"io"
+	mathrand "math/rand"
 	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
 )
 
+// multipathLog logs multipath session and path events.
+var multipathLog = ComponentLogger("multipath")
+
+// multipathAcceptQueue is the number of accepted streams of each kind a
+// multipath session queues until they are taken.
+const multipathAcceptQueue = 64
+
 // Path represents a network path.
 type Path struct {
 	// addr is the address of the path.
 	addr string
+	// binding is the local address and interface the path is bound to.
+	// It is empty on paths over the default route and on servers.
+	binding PathOptions
 	// conn is the QUIC connection for the path.
 	conn quic.Connection
 	// rtt is the round-trip time for the path.
@@ -26,6 +40,30 @@ type Path struct {
 	active bool
 	// lastActive is the time when the path was last active.
 	lastActive time.Time
+	// history keeps the recent telemetry samples of the path.
+	history *TelemetryHistory
+	// sessionID is the session ID assigned by the server of the path.
+	sessionID string
+	// log is the path logger. Its records carry the session ID and the
+	// path address.
+	log *Logger
+}
+
+// name returns the name the path is known by: its address, followed by
+// its binding if it is bound, so paths to one address are told apart.
+func (p *Path) name() string {
+	if p.binding.bound() {
+		return p.addr + " via " + p.binding.String()
+	}
+	return p.addr
+}
+
+// logger returns the path logger.
+func (p *Path) logger() *Logger {
+	if p.log == nil {
+		return multipathLog.With("path", p.addr)
+	}
+	return p.log
 }
 
 // MultipathSession represents a multipath session.
@@ -38,14 +76,74 @@ type MultipathSession struct {
 	nextPath int
 	// config holds the TLS configuration for new connections.
 	config *Config
-	// dataSplitter handles data splitting across multiple paths.
-	dataSplitter *DataSplitter
 	// pathSelector selects the best path for data transmission.
 	pathSelector *PathSelector
 	// tokenBucketController controls the rate of data transmission.
 	tokenBucketController *TokenBucketController
 	// adaptiveFEC adjusts FEC parameters based on telemetry data.
 	adaptiveFEC *AdaptiveFEC
+	// id is the multipath session ID additional paths present in their
+	// handshake. It is issued by the server on the first path, and is
+	// empty on clients of servers without multipath support.
+	id string
+	// sessionID is the session ID of the paths on servers.
+	sessionID string
+	// user is the identity of the client on servers.
+	user string
+	// accountant accounts the traffic of server sessions, or is nil if
+	// traffic is not accounted.
+	accountant *TrafficAccountant
+	// rateLimitNode is the session's node in the rate limit tree on servers
+	// with one, or nil. Streams draw from a child per stream type.
+	rateLimitNode *RateLimitNode
+	// scheduler orders the writes of the session's streams by priority on
+	// servers, or is nil.
+	scheduler *SendScheduler
+	// rateLimitPolicy holds the stream type weights of the session.
+	rateLimitPolicy RateLimitPolicy
+	// streamPriorities overrides the default priorities of the stream types.
+	streamPriorities map[uint8]StreamPriority
+	// policyMutex protects rateLimitPolicy, streamPriorities and
+	// brutalLimit, which change while the session runs.
+	policyMutex sync.RWMutex
+	// brutalRates are the rates negotiated in the handshake of the first
+	// path on servers.
+	brutalRates BandwidthRates
+	// brutalLimit caps the session's node in the rate limit tree at the
+	// brutal mode rate on servers, or is zero.
+	brutalLimit float64
+	// brutalController raises brutalLimit to make up for loss, or is nil.
+	brutalController *BrutalRateController
+	// streams queues the interactive streams the peer opened on any path.
+	streams chan quic.Stream
+	// dataStream is the multipath stream SendData writes to, or nil until
+	// it is opened.
+	dataStream *MultipathStream
+	// dataMutex serializes SendData.
+	dataMutex sync.Mutex
+	// peerData queues the multipath streams carrying the peer's SendData
+	// messages.
+	peerData chan *MultipathStream
+	// receiveStream is the multipath stream ReceiveData reads from, or nil.
+	receiveStream *MultipathStream
+	// receiveMutex serializes ReceiveData.
+	receiveMutex sync.Mutex
+	// closed is closed when the session is closed.
+	closed chan struct{}
+	// closeOnce ensures the session is only closed once.
+	closeOnce sync.Once
+	// onClose is called when the session is closed, or is nil.
+	onClose func()
+	// multipathStreams holds the open multipath streams by ID.
+	multipathStreams map[uint64]*MultipathStream
+	// closedMultipathStreams holds the IDs of recently closed multipath
+	// streams, so late chunks do not open them again.
+	closedMultipathStreams closedStreamIDs
+	// acceptedMultipathStreams queues the multipath streams the peer
+	// opened.
+	acceptedMultipathStreams chan *MultipathStream
+	// multipathStreamsMutex protects the multipath stream maps.
+	multipathStreamsMutex sync.Mutex
 }
 
 // DataSplitter handles splitting data across multiple paths.
@@ -142,7 +240,7 @@ func (ps *PathSelector) SelectPath(paths []*Path, nextPath *int) *Path {
 		}
 
 		// Select a path based on its weight
-		randWeight := uint64(rand.Int63n(int64(totalWeight)))
+		randWeight := uint64(mathrand.Int63n(int64(totalWeight)))
 		currentWeight := uint64(0)
 		for _, path := range paths {
 			if path.active {
@@ -162,69 +260,251 @@ func NewMultipathSession(config *Config, tokenBucketController *TokenBucketContr
 	return &MultipathSession{
 		paths:                 make([]*Path, 0),
 		config:                config,
-		dataSplitter:          NewDataSplitter(1024), // 1KB chunks
 		pathSelector:          NewPathSelector(RoundRobinStrategy),
 		tokenBucketController: tokenBucketController,
 		adaptiveFEC:           adaptiveFEC,
+		streams:               make(chan quic.Stream, multipathAcceptQueue),
+		closed:                make(chan struct{}),
+
+		multipathStreams:         make(map[uint64]*MultipathStream),
+		acceptedMultipathStreams: make(chan *MultipathStream, multipathAcceptQueue),
+		peerData:                 make(chan *MultipathStream, multipathAcceptQueue),
+	}
+}
+
+// newMultipathSessionID returns a random multipath session ID. It is
+// longer than a session ID, since knowing it is what lets a connection
+// join the session.
+func newMultipathSessionID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate multipath session ID: %w", err)
 	}
+	return hex.EncodeToString(id[:]), nil
 }
 
-// AddPath adds a new path to the session.
+// ID returns the multipath session ID. It is empty if the server does not
+// support multipath.
+func (ms *MultipathSession) ID() string {
+	return ms.id
+}
+
+// AddPath adds a new path over the default route to the session. The
+// first path gets the multipath session ID from the server, and the others
+// present it so the server groups them into the same session.
 func (ms *MultipathSession) AddPath(ctx context.Context, addr string) error {
+	return ms.AddPathWithOptions(ctx, addr, PathOptions{})
+}
+
+// AddPathWithOptions adds a new path to the session that is bound to the
+// local address and interface of options.
+func (ms *MultipathSession) AddPathWithOptions(ctx context.Context, addr string, options PathOptions) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	Info("Adding path to %s", addr)
+	log := multipathLog.With("path", addr)
+	if options.bound() {
+		log = log.With("local", options.String())
+	}
+	log.Info("Adding path to %s", addr)
 	// Establish a new QUIC connection for the path
-	conn, err := quic.DialAddr(ctx, addr, ms.config.TLSConfig, nil)
+	var conn quic.Connection
+	var err error
+	if options.bound() {
+		conn, err = dialPath(ctx, addr, ms.config.TLSConfig, options)
+	} else {
+		conn, err = quic.DialAddr(ctx, addr, ms.config.TLSConfig, newQUICConfig())
+	}
 	if err != nil {
-		Error("Failed to dial path %s: %v", addr, err)
+		log.Error("Failed to dial path %s: %v", addr, err)
 		return fmt.Errorf("failed to dial path %s: %w", addr, err)
 	}
-	Info("Successfully dialed path %s", addr)
+	log.Info("Successfully dialed path %s", addr)
+
+	// Additional paths need a multipath session to join
+	if len(ms.paths) > 0 && ms.id == "" {
+		conn.CloseWithError(0, "multipath not supported")
+		return fmt.Errorf("failed to add path %s: server does not support multipath", addr)
+	}
 
 	// Perform session negotiation handshake on the control stream.
-	if err := performClientHandshake(ctx, conn); err != nil {
+	result, err := performClientHandshake(ctx, conn, ms.config.Token, ms.config.Brutal, &multipathRequest{id: ms.id})
+	if err != nil {
 		conn.CloseWithError(0, "handshake failed")
-		Error("Handshake failed for path %s: %v", addr, err)
+		defaultMetrics.HandshakeFailed(handshakeFailureReason(err))
+		log.Error("Handshake failed for path %s: %v", addr, err)
 		return fmt.Errorf("handshake failed for path %s: %w", addr, err)
 	}
-	Info("Session handshake completed for path %s", addr)
-
-	// Create a new path with initial placeholder values
-	path := &Path{
-		addr:       addr,
-		conn:       conn,
-		active:     true,
-		rtt:        50 * time.Millisecond,      // Initial RTT placeholder
-		loss:       0.01,                       // Initial loss placeholder (1%)
-		bandwidth:  1000000,                    // Initial bandwidth placeholder (1 MB/s)
-		lastActive: time.Now(),
+	if len(ms.paths) > 0 && result.multipathID != ms.id {
+		conn.CloseWithError(0, "multipath session mismatch")
+		return fmt.Errorf("failed to add path %s: server did not join it to multipath session", addr)
+	}
+	if len(ms.paths) == 0 {
+		ms.id = result.multipathID
+		ms.sessionID = result.sessionID
+		if ms.id == "" {
+			log.Warn("Server does not support multipath, only one path can be used")
+		}
 	}
+	if result.sessionID != "" {
+		log = multipathLog.With("session_id", result.sessionID, "path", addr)
+		if options.bound() {
+			log = log.With("local", options.String())
+		}
+	}
+	log.Info("Session handshake completed for path %s", addr)
 
 	// Add the path to the session
+	path := newPath(addr, conn, result.sessionID, log)
+	path.binding = options
 	ms.paths = append(ms.paths, path)
 
 	// Update the token bucket controller with the first path's connection
 	if ms.tokenBucketController != nil && len(ms.paths) == 1 {
 		// Update the token bucket controller with the connection
 		ms.tokenBucketController.UpdateConnection(conn)
-		Info("Updated token bucket controller with connection to %s", addr)
+		log.Info("Updated token bucket controller with connection to %s", addr)
+		
+		// Pace at the negotiated rate in brutal mode
+		if rate := float64(result.brutalRates.Up); rate > 0 {
+			ms.tokenBucketController.SetRateController(NewBrutalRateController(rate))
+			ms.tokenBucketController.Bucket().SetRate(rate)
+			log.Info("Brutal mode pacing at %.0f bytes/s", rate)
+		}
 	}
 
-	// Start path probing in a separate goroutine
+	ms.startPath(path)
+	return nil
+}
+
+// newPath creates a path on a connection with initial placeholder values.
+func newPath(addr string, conn quic.Connection, sessionID string, log *Logger) *Path {
+	return &Path{
+		addr:       addr,
+		conn:       conn,
+		active:     true,
+		rtt:        50 * time.Millisecond, // Initial RTT placeholder
+		loss:       0.01,                  // Initial loss placeholder (1%)
+		bandwidth:  1000000,               // Initial bandwidth placeholder (1 MB/s)
+		lastActive: time.Now(),
+		history:    NewTelemetryHistory(defaultTelemetryHistorySize),
+		sessionID:  sessionID,
+		log:        log,
+	}
+}
+
+// startPath starts probing a path and accepting the streams the peer opens
+// on it.
+func (ms *MultipathSession) startPath(path *Path) {
+	defaultMetrics.meterConnection(path.conn, ms.user, path.name())
 	go ms.probePath(path)
+	go ms.acceptStreams(path)
+}
 
-	return nil
+// acceptStreams accepts the streams the peer opens on a path until the
+// path's connection is closed.
+func (ms *MultipathSession) acceptStreams(path *Path) {
+	for {
+		stream, err := path.conn.AcceptStream(path.conn.Context())
+		if err != nil {
+			ms.pathClosed(path)
+			return
+		}
+		go ms.dispatchStream(path, stream)
+	}
 }
 
-// RemovePath removes a path from the session.
+// dispatchStream reads the type of a stream the peer opened and queues it
+// for AcceptStream or ReceiveData.
+func (ms *MultipathSession) dispatchStream(path *Path, stream quic.Stream) {
+	streamLog := path.logger().With("stream_id", int64(stream.StreamID()))
+	msg, err := ReadMessage(stream)
+	if err != nil {
+		streamLog.Warn("Failed to read stream type: %v", err)
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	if msg.Type != StreamType {
+		streamLog.Warn("Expected StreamType message, got %d", msg.Type)
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	payload, err := DecodeStreamType(msg.Data)
+	if err != nil {
+		streamLog.Warn("Failed to decode stream type payload: %v", err)
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+
+	var queue chan quic.Stream
+	switch payload.Type {
+	case StreamTypeInteractive:
+		queue = ms.streams
+		stream = ms.trackStream(stream, path, payload.Type, true)
+		if payload.FEC {
+			if stream, err = ms.wrapFEC(stream, payload.Type); err != nil {
+				streamLog.Warn("Failed to set up FEC: %v", err)
+				return
+			}
+		}
+	case StreamTypeBulk:
+		if payload.MultipathStream != 0 {
+			ms.attachSubflow(path, ms.trackStream(stream, path, payload.Type, true), payload)
+			return
+		}
+		streamLog.Warn("Bulk stream without a multipath stream on multipath session")
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	default:
+		streamLog.Warn("Unexpected stream type %d on multipath session", payload.Type)
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	select {
+	case queue <- stream:
+	case <-ms.closed:
+		stream.CancelRead(0)
+		stream.Close()
+	}
+}
+
+// pathClosed marks a path whose connection was closed as inactive. Server
+// sessions drop the path, and are closed once they have no path left.
+func (ms *MultipathSession) pathClosed(path *Path) {
+	ms.mutex.Lock()
+	path.active = false
+	empty := false
+	if ms.onClose != nil {
+		for i, p := range ms.paths {
+			if p == path {
+				ms.paths = append(ms.paths[:i], ms.paths[i+1:]...)
+				break
+			}
+		}
+		empty = len(ms.paths) == 0
+	}
+	ms.mutex.Unlock()
+
+	path.logger().Info("Path %s closed", path.addr)
+	if empty {
+		ms.Close()
+	}
+}
+
+// RemovePath removes a path from the session. Bound paths are known by
+// their address followed by " via " and their binding, e.g.
+// "example.com:4242 via 192.0.2.1%wlan0".
 func (ms *MultipathSession) RemovePath(addr string) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
 	for i, path := range ms.paths {
-		if path.addr == addr {
+		if path.name() == addr {
 			// Close the connection
 			if path.conn != nil {
 				path.conn.CloseWithError(0, "path removed")
@@ -240,38 +520,44 @@ func (ms *MultipathSession) RemovePath(addr string) error {
 }
 
 // probePath continuously probes a path to update its metrics.
+// The metrics come from the connection tracer of the path's QUIC connection.
+// The initial placeholder values are kept until the first RTT sample arrives.
 func (ms *MultipathSession) probePath(path *Path) {
+	collector := NewTelemetryCollector(path.conn)
+	collector.SetSessionID(path.sessionID)
+	unregister := registerTelemetryHistory("path", path.name(), path.history)
+	defer unregister()
+	
 	// Create a ticker for periodic probing
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-path.conn.Context().Done():
+			return
 		case <-ticker.C:
-			// Since quic-go Connection interface doesn't provide Stats method in all versions,
-			// we'll use placeholder values for path metrics
-			ms.mutex.Lock()
-			
-			// Update RTT with placeholder value
-			if path.rtt == 0 {
-				path.rtt = 50 * time.Millisecond
-			}
+			data := collector.Collect()
+			defaultMetrics.ObserveTelemetry(data)
+			path.history.Add(data)
 			
-			// Update loss with placeholder value
-			if path.loss == 0 {
-				path.loss = 0.01 // 1% default
+			ms.mutex.Lock()
+			if data.RTT > 0 {
+				path.rtt = data.RTT
+				path.loss = data.Loss
 			}
-			
-			// Update bandwidth with placeholder value
-			if path.bandwidth == 0 {
-				path.bandwidth = 1000000 // 1 MB/s placeholder
+			if data.Bandwidth > 0 {
+				path.bandwidth = data.Bandwidth
 			}
-			
 			path.lastActive = time.Now()
+			rtt, loss, bandwidth := path.rtt, path.loss, path.bandwidth
 			ms.mutex.Unlock()
 
-			Info("Path %s: RTT=%v, Loss=%.2f%%, Bandwidth=%d B/s",
-				path.addr, path.rtt, path.loss*100, path.bandwidth)
+			path.logger().Log(DebugLevel, "Path probed",
+				"rtt", rtt, "loss", loss, "bandwidth", bandwidth)
+			if ms.brutalController != nil {
+				ms.compensateBrutalLoss()
+			}
 		}
 	}
 }
@@ -287,24 +573,65 @@ func (ms *MultipathSession) OpenStream(ctx context.Context) (quic.Stream, error)
 		return nil, fmt.Errorf("no active paths available")
 	}
 
-	Info("Opening stream on path %s", path.addr)
+	path.logger().Info("Opening stream on path %s", path.addr)
 	// Stream type 1 is for interactive data.
+	payload := &StreamTypePayload{Type: StreamTypeInteractive, FEC: ms.adaptiveFEC != nil}
+	stream, err := ms.openTypedStream(ctx, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	stream = ms.trackStream(stream, path, payload.Type, true)
+	if payload.FEC {
+		return ms.wrapFEC(stream, payload.Type)
+	}
+	return stream, nil
+}
+
+// wrapFEC wraps a stream whose data is sent as FEC frames. Writes are
+// encoded with the codec the session's AdaptiveFEC picks for the stream
+// type, or interleaved with its current Reed-Solomon shards if the config
+// sets an interleave depth. Sessions without one, such as those of a
+// server, answer with XOR parity, which suits the interactive streams that
+// use FEC.
+func (ms *MultipathSession) wrapFEC(stream quic.Stream, streamType uint8) (quic.Stream, error) {
+	if ms.adaptiveFEC != nil && ms.config != nil && ms.config.FECInterleaveDepth > 1 {
+		params := ms.adaptiveFEC.Params()
+		fecStream, err := NewInterleavedFECStream(stream, params.DataShards, params.ParityShards, ms.config.FECInterleaveDepth)
+		if err != nil {
+			stream.CancelRead(0)
+			stream.Close()
+			return nil, err
+		}
+		return fecStream, nil
+	}
+	if ms.adaptiveFEC != nil {
+		return NewAdaptiveFECStream(stream, ms.adaptiveFEC, streamType), nil
+	}
+	codec, err := NewXORFEC(maxXORDataShards)
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, fmt.Errorf("failed to create XOR FEC: %w", err)
+	}
+	return NewFECStreamWithCodec(stream, codec), nil
+}
+
+// openTypedStream opens a stream on a path and sends its type.
+func (ms *MultipathSession) openTypedStream(ctx context.Context, path *Path, payload *StreamTypePayload) (quic.Stream, error) {
+	pathLog := path.logger()
 	stream, err := path.conn.OpenStreamSync(ctx)
 	if err != nil {
-		Info("Failed to open stream on path %s: %v", path.addr, err)
+		pathLog.Info("Failed to open stream on path %s: %v", path.addr, err)
 		return nil, err
 	}
-	Info("Successfully opened stream on path %s", path.addr)
+	streamLog := pathLog.With("stream_id", int64(stream.StreamID()))
+	streamLog.Debug("Successfully opened stream on path %s", path.addr)
 	
 	// Send stream type identifier on the stream.
-	Info("Sending stream type message on path %s", path.addr)
-	payload := &StreamTypePayload{
-		Type: StreamTypeInteractive,
-	}
 	data, err := EncodeStreamType(payload)
 	if err != nil {
 		stream.Close()
-		Info("Failed to encode stream type: %v", err)
+		streamLog.Info("Failed to encode stream type: %v", err)
 		return nil, fmt.Errorf("failed to encode stream type: %w", err)
 	}
 	
@@ -315,134 +642,267 @@ func (ms *MultipathSession) OpenStream(ctx context.Context) (quic.Stream, error)
 	
 	if err := WriteMessage(stream, msg); err != nil {
 		stream.Close()
-		Info("Failed to send stream type message on path %s: %v", path.addr, err)
+		streamLog.Info("Failed to send stream type message on path %s: %v", path.addr, err)
 		return nil, fmt.Errorf("failed to send stream type: %w", err)
 	}
 	
-	Info("Successfully opened stream on path %s and sent stream type message", path.addr)
+	streamLog.Debug("Sent stream type message on path %s", path.addr)
+	defaultMetrics.StreamOpened(payload.Type)
 	return stream, nil
 }
 
-// AcceptStream accepts a new stream on any path.
-func (ms *MultipathSession) AcceptStream(ctx context.Context) (quic.Stream, error) {
-	// For simplicity, we'll just accept on the first path
-	// A real implementation would need to handle streams from multiple paths
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+// trackStream wraps a stream of a path so its bytes are metered and, on
+// servers, accounted. paced streams of the given type are paced by the
+// session's rate limiter as well, and their writes are scheduled by
+// priority on servers.
+func (ms *MultipathSession) trackStream(stream quic.Stream, path *Path, streamType uint8, paced bool) quic.Stream {
+	if paced {
+		limiter := ms.streamLimiter(streamType)
+		if ms.scheduler != nil {
+			policy, priorities := ms.policy()
+			flow := ms.scheduler.NewFlow(streamPriority(streamType, PriorityDefault, priorities), policy.streamWeight(streamType))
+			stream = newScheduledStream(stream, limiter, flow)
+		} else if limiter != nil {
+			stream = newRateLimitedStream(stream, limiter)
+		}
+	}
+	if ms.accountant != nil {
+		stream = newAccountedStream(stream, ms.accountant, ms.user, ms.sessionID)
+	}
+	return newMeteredStream(stream, defaultMetrics, ms.user, path.name())
+}
 
-	if len(ms.paths) > 0 && ms.paths[0].active {
-		return ms.paths[0].conn.AcceptStream(ctx)
+// rateLimiter returns the token bucket that paces the data sent on the
+// session, or nil if sending is not rate limited.
+func (ms *MultipathSession) rateLimiter() *TokenBucket {
+	if ms.tokenBucketController != nil {
+		return ms.tokenBucketController.Bucket()
+	}
+	if ms.config != nil {
+		return ms.config.RateLimiter
 	}
+	return nil
+}
 
-	return nil, fmt.Errorf("no active paths available for accepting streams")
+// streamLimiter returns the rate limiter of a stream type: a child of the
+// session's node in the rate limit tree if it has one, or the session's
+// token bucket. It returns nil if sending is not rate limited.
+func (ms *MultipathSession) streamLimiter(streamType uint8) RateLimiter {
+	if ms.rateLimitNode != nil {
+		policy, _ := ms.policy()
+		return ms.rateLimitNode.Child(streamTypeName(streamType), policy.streamWeight(streamType), 0)
+	}
+	if bucket := ms.rateLimiter(); bucket != nil {
+		return bucket
+	}
+	return nil
 }
 
-// SendData sends data across multiple paths with FEC and rate control.
-func (ms *MultipathSession) SendData(ctx context.Context, data []byte) error {
-	// Apply FEC encoding if adaptiveFEC is available
-	var chunks [][]byte
-	if ms.adaptiveFEC != nil {
-		var err error
-		chunks, err = ms.adaptiveFEC.Encode(data)
-		if err != nil {
-			return fmt.Errorf("failed to encode data with FEC: %w", err)
+// startRateLimiting sets up the pacing and scheduling of the data a server
+// session sends, like Session.startRateLimiting does: with a rate limit
+// tree, the session gets a node below its user's node, capped at the
+// brutal mode rate if one was negotiated. It must be called before the
+// first path is started.
+func (ms *MultipathSession) startRateLimiting(config *Config) {
+	liveRateLimits.add(ms, config)
+	ms.scheduler = NewSendScheduler()
+
+	tree := config.RateLimitTree
+	if rate := float64(ms.brutalRates.Down); rate > 0 {
+		ms.brutalLimit = rate
+		ms.brutalController = NewBrutalRateController(rate)
+		if tree == nil {
+			tree = brutalRateLimitTree(config.RateLimiter)
 		}
-		Info("Encoded data with FEC: %d chunks", len(chunks))
-	} else {
-		// Split data into chunks without FEC
-		chunks = ms.dataSplitter.Split(data)
+	}
+	if tree == nil {
+		return
 	}
 
-	// Send each chunk on a different path
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+	ms.policyMutex.Lock()
+	defer ms.policyMutex.Unlock()
+	limit := brutalSessionLimit(ms.brutalLimit, ms.rateLimitPolicy)
+	ms.rateLimitNode = sessionRateLimitNode(tree, ms.user, ms.sessionID, ms.rateLimitPolicy, limit)
+}
 
-	for i, chunk := range chunks {
-		// Select a path for this chunk
-		path := ms.pathSelector.SelectPath(ms.paths, &ms.nextPath)
-		if path == nil {
-			return fmt.Errorf("no active paths available for sending data")
-		}
+// stopRateLimiting removes the session from the rate limit tree.
+func (ms *MultipathSession) stopRateLimiting() {
+	liveRateLimits.remove(ms)
+	if ms.rateLimitNode != nil {
+		ms.rateLimitNode.Remove()
+	}
+}
 
-		// Check token bucket if controller is available
-		if ms.tokenBucketController != nil {
-			// In a real implementation, you would check the token bucket before sending
-			// For now, we'll just log that we're checking
-			Info("Checking token bucket for path %s", path.addr)
-		}
+// applyRateLimitPolicy updates the policy and stream priorities of the
+// session, and the limits of its nodes in the rate limit tree.
+func (ms *MultipathSession) applyRateLimitPolicy(policy RateLimitPolicy, priorities map[uint8]StreamPriority) {
+	ms.policyMutex.Lock()
+	defer ms.policyMutex.Unlock()
 
-		// Open a stream on the selected path
-		stream, err := path.conn.OpenStreamSync(ctx)
-		if err != nil {
-			// Mark the path as inactive
-			path.active = false
-			Warn("Failed to open stream on path %s: %v", path.addr, err)
-			continue
-		}
+	ms.rateLimitPolicy = policy
+	ms.streamPriorities = priorities
+	if ms.rateLimitNode != nil {
+		applyNodePolicy(ms.rateLimitNode, brutalSessionLimit(ms.brutalLimit, policy), policy)
+	}
+}
 
-		// Send the chunk
-		_, err = stream.Write(chunk)
-		if err != nil {
-			// Mark the path as inactive
-			path.active = false
-			Error("Failed to send chunk on path %s: %v", path.addr, err)
-			stream.Close()
-			continue
-		}
+// policy returns the rate limit policy and stream priorities of the
+// session.
+func (ms *MultipathSession) policy() (RateLimitPolicy, map[uint8]StreamPriority) {
+	ms.policyMutex.RLock()
+	defer ms.policyMutex.RUnlock()
+	return ms.rateLimitPolicy, ms.streamPriorities
+}
 
-		// Close the stream
-		stream.Close()
+// compensateBrutalLoss raises the brutal mode cap of a server session's
+// node to make up for the highest loss of its paths.
+func (ms *MultipathSession) compensateBrutalLoss() {
+	ms.mutex.RLock()
+	data := &TelemetryData{}
+	for _, path := range ms.paths {
+		if path.active && path.loss > data.Loss {
+			data.Loss = path.loss
+		}
+	}
+	ms.mutex.RUnlock()
 
-		Info("Sent chunk %d/%d on path %s", i+1, len(chunks), path.addr)
+	ms.policyMutex.Lock()
+	defer ms.policyMutex.Unlock()
+	limit, reason := ms.brutalController.Update(ms.brutalLimit, data)
+	if reason == "" {
+		return
+	}
+	ms.brutalLimit = limit
+	if ms.rateLimitNode != nil {
+		ms.rateLimitNode.setLimit(1, brutalSessionLimit(limit, ms.rateLimitPolicy))
 	}
+}
 
-	return nil
+// AcceptStream accepts a new interactive stream the peer opened on any
+// path.
+func (ms *MultipathSession) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	return ms.accept(ctx, ms.streams)
 }
 
-// ReceiveData receives data from multiple paths and decodes FEC if necessary.
-// This is a simplified implementation
-// In a real implementation, you would need to:
-// 1. Receive chunks from multiple paths concurrently
-// 2. Reassemble the chunks in the correct order
-// 3. Handle lost chunks and retransmissions
-func (ms *MultipathSession) ReceiveData(ctx context.Context) ([]byte, error) {
-	// For now, we'll just receive data from the first path
+// accept takes the next stream from queue. It fails at once if there is
+// none queued and no active path to wait on.
+func (ms *MultipathSession) accept(ctx context.Context, queue chan quic.Stream) (quic.Stream, error) {
+	select {
+	case stream := <-queue:
+		return stream, nil
+	default:
+	}
+
 	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+	active := false
+	for _, path := range ms.paths {
+		active = active || path.active
+	}
+	ms.mutex.RUnlock()
+	if !active {
+		return nil, fmt.Errorf("no active paths available for accepting streams")
+	}
 
-	if len(ms.paths) > 0 && ms.paths[0].active {
-		stream, err := ms.paths[0].conn.AcceptStream(ctx)
-		if err != nil {
-			return nil, err
-		}
-		defer stream.Close()
+	select {
+	case stream := <-queue:
+		return stream, nil
+	case <-ms.closed:
+		return nil, fmt.Errorf("multipath session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-		buf := make([]byte, 4096) // Larger buffer for FEC chunks
-		n, err := stream.Read(buf)
+// SendData sends data as one message on a multipath stream of the session,
+// so it is spread across the paths, sent again if a path fails, and
+// received in order by ReceiveData. Retransmission takes the place of FEC.
+// The stream is opened on the first call, and again after it failed.
+func (ms *MultipathSession) SendData(ctx context.Context, data []byte) error {
+	if len(data) > maxMessageSize {
+		return fmt.Errorf("data too large: %d bytes", len(data))
+	}
+
+	ms.dataMutex.Lock()
+	defer ms.dataMutex.Unlock()
+
+	if ms.dataStream == nil {
+		stream, err := ms.openMultipathStream(true)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		ms.dataStream = stream
+	}
+
+	// The length prefix and data are written at once, so a failed write
+	// never leaves half a message on a stream that is used again
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	if _, err := ms.dataStream.Write(frame); err != nil {
+		ms.dataStream.Close()
+		ms.dataStream = nil
+		return fmt.Errorf("failed to send data: %w", err)
+	}
+	return nil
+}
+
+// ReceiveData receives the next message the peer sent with SendData, in
+// the order they were sent. ctx bounds the wait for the peer's data stream;
+// once it arrived, ReceiveData blocks until the message is complete or the
+// stream fails.
+func (ms *MultipathSession) ReceiveData(ctx context.Context) ([]byte, error) {
+	ms.receiveMutex.Lock()
+	defer ms.receiveMutex.Unlock()
 
-		// If we have FEC, try to decode the data
-		if ms.adaptiveFEC != nil {
-			// This is a simplified approach - in a real implementation,
-			// you would collect multiple chunks and then decode them
-			// For now, we'll just return the raw data
-			Info("Received data with potential FEC encoding")
-			return buf[:n], nil
+	for {
+		if ms.receiveStream == nil {
+			select {
+			case stream := <-ms.peerData:
+				ms.receiveStream = stream
+			case <-ms.closed:
+				return nil, fmt.Errorf("multipath session closed")
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
 		}
 
-		return buf[:n], nil
+		data, err := readDataMessage(ms.receiveStream)
+		if err == io.EOF {
+			// The peer closed the stream, and opens another one
+			ms.receiveStream = nil
+			continue
+		}
+		if err != nil {
+			ms.receiveStream.Close()
+			ms.receiveStream = nil
+			return nil, fmt.Errorf("failed to receive data: %w", err)
+		}
+		return data, nil
 	}
+}
 
-	return nil, fmt.Errorf("no active paths available for receiving data")
+// readDataMessage reads a message written by SendData. It returns io.EOF
+// if the stream ended before the message started.
+func readDataMessage(stream io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("message too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // Close closes all paths in the session.
 func (ms *MultipathSession) Close() error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
-
 	// Close all paths
 	for _, path := range ms.paths {
 		if path.conn != nil {
@@ -450,9 +910,24 @@ func (ms *MultipathSession) Close() error {
 		}
 	}
 
+	// The session is marked closed under the lock, so no path is added
+	// once its paths were closed
+	ms.closeOnce.Do(func() {
+		close(ms.closed)
+		ms.stopRateLimiting()
+		if ms.onClose != nil {
+			ms.onClose()
+		}
+	})
 	return nil
 }
 
+// Done returns a channel that is closed when the session is closed. Server
+// sessions are closed once all their paths are.
+func (ms *MultipathSession) Done() <-chan struct{} {
+	return ms.closed
+}
+
 // SetPathSelectionStrategy sets the path selection strategy.
 func (ms *MultipathSession) SetPathSelectionStrategy(strategy PathSelectionStrategy) {
 	ms.pathSelector.strategy = strategy
@@ -469,4 +944,20 @@ func (ms *MultipathSession) GetPathStats() []Path {
 	}
 
 	return stats
-}
\ No newline at end of file
+}
+
+// TelemetryHistory returns the telemetry samples of the path to addr
+// collected at or after since, oldest first. Bound paths are known by
+// their name as in RemovePath.
+func (ms *MultipathSession) TelemetryHistory(addr string, since time.Time) ([]TelemetryData, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	for _, path := range ms.paths {
+		if path.name() == addr {
+			return path.history.Since(since), nil
+		}
+	}
+
+	return nil, fmt.Errorf("path %s not found", addr)
+}
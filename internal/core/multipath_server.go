@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// errUnknownMultipathSession is returned when a path presents the ID of a
+// multipath session that does not exist or belongs to another user.
+var errUnknownMultipathSession = errors.New("unknown multipath session")
+
+// multipathRegistry groups the connections of a server into multipath
+// sessions by their multipath session ID. It is safe for concurrent use.
+type multipathRegistry struct {
+	// config is the configuration of the server.
+	config *Config
+	// sessions holds the open multipath sessions by ID.
+	sessions map[string]*MultipathSession
+	// mutex protects sessions.
+	mutex sync.Mutex
+}
+
+// newMultipathRegistry creates an empty multipathRegistry.
+func newMultipathRegistry(config *Config) *multipathRegistry {
+	return &multipathRegistry{
+		config:   config,
+		sessions: make(map[string]*MultipathSession),
+	}
+}
+
+// create creates a multipath session of a user with a new multipath
+// session ID and registers it.
+func (r *multipathRegistry) create(sessionID, user string) (*MultipathSession, error) {
+	id, err := newMultipathSessionID()
+	if err != nil {
+		return nil, err
+	}
+	ms := NewMultipathSession(r.config, nil, nil)
+	ms.id = id
+	ms.sessionID = sessionID
+	ms.user = user
+	ms.accountant = trafficAccountant.Load()
+	ms.onClose = func() {
+		r.remove(ms)
+	}
+
+	r.mutex.Lock()
+	r.sessions[id] = ms
+	r.mutex.Unlock()
+	return ms, nil
+}
+
+// join returns the multipath session with the given ID. The ID is what
+// authorizes the join: it is 128 random bits only given to the client in
+// the handshake of the first path. The identity of the joining connection
+// is not checked, since without a token it is the client's address, which
+// differs on each of its links.
+func (r *multipathRegistry) join(id string) (*MultipathSession, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ms, ok := r.sessions[id]
+	if !ok {
+		return nil, errUnknownMultipathSession
+	}
+	return ms, nil
+}
+
+// remove unregisters a multipath session.
+func (r *multipathRegistry) remove(ms *MultipathSession) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.sessions[ms.id] == ms {
+		delete(r.sessions, ms.id)
+	}
+}
+
+// MultipathListener accepts multipath sessions on a server. Each client
+// connection is a path: the first path of a session gets a multipath
+// session ID, and connections presenting it in their handshake are added
+// to the same session. Clients without multipath support get one-path
+// sessions.
+type MultipathListener struct {
+	// listener accepts the QUIC connections.
+	listener *quic.Listener
+	// config is the configuration of the server.
+	config *Config
+	// registry groups the connections into sessions.
+	registry *multipathRegistry
+	// accepted queues the sessions whose first path was added.
+	accepted chan *MultipathSession
+	// ctx is cancelled when the listener is closed.
+	ctx context.Context
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+}
+
+// ListenMultipath listens for multipath sessions on config.Address. The
+// listener stops when ctx is done or it is closed.
+func ListenMultipath(ctx context.Context, config *Config) (*MultipathListener, error) {
+	listener, err := quic.ListenAddr(config.Address, config.TLSConfig, newQUICConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", config.Address, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &MultipathListener{
+		listener: listener,
+		config:   config,
+		registry: newMultipathRegistry(config),
+		accepted: make(chan *MultipathSession, multipathAcceptQueue),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go l.serve()
+	return l, nil
+}
+
+// Accept returns the next new multipath session.
+func (l *MultipathListener) Accept(ctx context.Context) (*MultipathSession, error) {
+	select {
+	case ms := <-l.accepted:
+		return ms, nil
+	case <-l.ctx.Done():
+		return nil, fmt.Errorf("multipath listener closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the address the listener listens on.
+func (l *MultipathListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops accepting connections. Sessions already accepted are not
+// closed.
+func (l *MultipathListener) Close() error {
+	l.cancel()
+	return l.listener.Close()
+}
+
+// serve accepts connections until the listener is closed.
+func (l *MultipathListener) serve() {
+	for {
+		conn, err := l.listener.Accept(l.ctx)
+		if err != nil {
+			if l.ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				return
+			}
+			multipathLog.Error("Failed to accept connection: %v", err)
+			continue
+		}
+		go l.handleConn(conn)
+	}
+}
+
+// handleConn performs the handshake of a connection and adds it as a path
+// to its multipath session.
+func (l *MultipathListener) handleConn(conn quic.Connection) {
+	addr := conn.RemoteAddr().String()
+	result, err := performServerHandshake(l.ctx, conn, l.config.Brutal, l.registry)
+	if err != nil {
+		conn.CloseWithError(0, "handshake failed")
+		defaultMetrics.HandshakeFailed(handshakeFailureReason(err))
+		multipathLog.Log(ErrorLevel, "Handshake failed", "remote_addr", addr, "error", err)
+		return
+	}
+	l.addPath(conn, result)
+}
+
+// addPath adds a connection that completed its handshake as a path to its
+// multipath session, and queues the session for Accept if it is new. A
+// connection whose session closed since it joined is closed.
+func (l *MultipathListener) addPath(conn quic.Connection, result handshakeResult) {
+	addr := conn.RemoteAddr().String()
+	ms := result.multipathSession
+	log := multipathLog.With("session_id", result.sessionID, "path", addr)
+	path := newPath(addr, conn, result.sessionID, log)
+	ms.mutex.Lock()
+	// The session is closed under the lock, so a path added here is
+	// closed with the others
+	select {
+	case <-ms.closed:
+		ms.mutex.Unlock()
+		conn.CloseWithError(0, "multipath session closed")
+		log.Info("Not adding path %s to closed multipath session", addr)
+		return
+	default:
+	}
+	first := len(ms.paths) == 0
+	if first {
+		ms.brutalRates = result.brutalRates
+		ms.startRateLimiting(l.config)
+	}
+	ms.paths = append(ms.paths, path)
+	ms.mutex.Unlock()
+	log.Info("Added path %s to multipath session", addr)
+	ms.startPath(path)
+
+	if !first {
+		return
+	}
+	defaultMetrics.SessionOpened()
+	go func() {
+		<-ms.Done()
+		defaultMetrics.SessionClosed()
+		if ms.accountant != nil {
+			usage := ms.accountant.EndSession(ms.sessionID)
+			log.Log(InfoLevel, "Session traffic", "user", ms.user, "up", usage.Up, "down", usage.Down)
+		}
+	}()
+	select {
+	case l.accepted <- ms:
+	case <-l.ctx.Done():
+		ms.Close()
+	}
+}
@@ -0,0 +1,338 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// newTestMultipathListener starts a multipath listener on a local port.
+func newTestMultipathListener(t *testing.T, ctx context.Context) *MultipathListener {
+	t.Helper()
+	listener, err := ListenMultipath(ctx, &Config{Address: "localhost:0", TLSConfig: generateTLSConfig(), IsServer: true})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+func TestMultipathServerGroupsPaths(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+	addr := listener.Addr().String()
+
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig()}, nil, nil)
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		if err := client.AddPath(ctx, addr); err != nil {
+			t.Fatalf("Failed to add path %d: %v", i, err)
+		}
+	}
+	if client.ID() == "" {
+		t.Fatal("Expected the server to issue a multipath session ID")
+	}
+
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+	if server.ID() != client.ID() {
+		t.Errorf("Expected multipath session %s, got %s", client.ID(), server.ID())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.GetPathStats()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if paths := len(server.GetPathStats()); paths != 2 {
+		t.Fatalf("Expected both connections in one session, got %d paths", paths)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	if _, err := listener.Accept(shortCtx); err == nil {
+		t.Error("Expected the second path not to create a session")
+	}
+
+	// Streams opened round robin on both paths are accepted by the server
+	for i := 0; i < 2; i++ {
+		stream, err := client.OpenStream(ctx)
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if _, err := stream.Write([]byte("ping")); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		stream.Close()
+	}
+	for i := 0; i < 2; i++ {
+		stream, err := server.AcceptStream(ctx)
+		if err != nil {
+			t.Fatalf("Failed to accept stream: %v", err)
+		}
+		data, err := io.ReadAll(stream)
+		if err != nil || string(data) != "ping" {
+			t.Errorf("Expected ping, got %q: %v", data, err)
+		}
+	}
+
+	// The server sends across both paths as well
+	if err := server.SendData(ctx, make([]byte, 3000)); err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	if data, err := client.ReceiveData(ctx); err != nil || len(data) != 3000 {
+		t.Fatalf("Expected 3000 bytes, got %d: %v", len(data), err)
+	}
+
+	// The session ends with its last path
+	client.Close()
+	select {
+	case <-server.Done():
+	case <-ctx.Done():
+		t.Error("Expected the server session to close with its paths")
+	}
+}
+
+func TestMultipathServerRejectsUnknownSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+
+	conn, err := quic.DialAddr(ctx, listener.Addr().String(), generateTLSConfig(), newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	_, err = performClientHandshake(ctx, conn, nil, BandwidthRates{}, &multipathRequest{id: "0123456789abcdef"})
+	if err == nil || !strings.Contains(err.Error(), errUnknownMultipathSession.Error()) {
+		t.Errorf("Expected the path to be rejected, got %v", err)
+	}
+}
+
+func TestMultipathServerJoinFromOtherIdentity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig(), Token: []byte("first")}, nil, nil)
+	defer client.Close()
+	if err := client.AddPath(ctx, listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to add path: %v", err)
+	}
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+
+	// A path with another identity, as a second link without a token would
+	// have, joins with the session ID alone
+	conn, err := quic.DialAddr(ctx, listener.Addr().String(), generateTLSConfig(), newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+	result, err := performClientHandshake(ctx, conn, []byte("second"), BandwidthRates{}, &multipathRequest{id: client.ID()})
+	if err != nil {
+		t.Fatalf("Expected the path to join, got %v", err)
+	}
+	if result.sessionID != server.sessionID {
+		t.Errorf("Expected the session ID %s, got %s", server.sessionID, result.sessionID)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.GetPathStats()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if paths := len(server.GetPathStats()); paths != 2 {
+		t.Errorf("Expected 2 paths, got %d", paths)
+	}
+}
+
+func TestMultipathServerFECStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+
+	adaptiveFEC, err := NewAdaptiveFEC(4, 2, 1, 4)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig()}, nil, adaptiveFEC)
+	defer client.Close()
+	if err := client.AddPath(ctx, listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to add path: %v", err)
+	}
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+
+	// Interactive streams of a client with adaptive FEC carry XOR frames
+	stream, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer stream.Close()
+	if _, ok := stream.(*FECStream); !ok {
+		t.Fatalf("Expected an FEC stream, got %T", stream)
+	}
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	// The server decodes them and answers in kind
+	accepted, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	defer accepted.Close()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(accepted, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected ping, got %q: %v", buf, err)
+	}
+	if _, err := accepted.Write([]byte("pong")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("Expected pong, got %q: %v", buf, err)
+	}
+}
+
+func TestMultipathServerSessionLimitedByUserNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	bucket := NewTokenBucket(10000000, 10000000)
+	listener, err := ListenMultipath(ctx, &Config{
+		Address:         "localhost:0",
+		TLSConfig:       generateTLSConfig(),
+		IsServer:        true,
+		RateLimiter:     bucket,
+		RateLimitTree:   NewRateLimitTree(bucket),
+		RateLimitPolicy: RateLimitPolicy{UserRate: 100000},
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	client, server := connectTestMultipathPair(t, ctx, listener)
+
+	node := server.rateLimitNode
+	if node == nil || node.parent.name != server.user || node.parent.limit != 100000 {
+		t.Fatal("Expected the server session to pace below its user's node")
+	}
+
+	// Data sent over both paths is paced at the user's rate
+	data := make([]byte, 300*1024)
+	rand.Read(data)
+	start := time.Now()
+	stream, err := server.OpenMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open multipath stream: %v", err)
+	}
+	go func() {
+		if _, err := stream.Write(data); err != nil {
+			t.Errorf("Failed to write: %v", err)
+		}
+	}()
+	accepted, err := client.AcceptMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath stream: %v", err)
+	}
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted, received); err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("Expected the data in order: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("Expected 300KB at 100KB/s to take about 3s, took %v", elapsed)
+	}
+
+	server.Close()
+	if _, ok := node.parent.children[node.name]; ok {
+		t.Error("Expected the closed session to leave the rate limit tree")
+	}
+}
+
+func TestMultipathServerClosesPathOfClosedSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+
+	// A path that joined just as the session closed is not added to it
+	ms, err := listener.registry.create("s1", "alice")
+	if err != nil {
+		t.Fatalf("Failed to create multipath session: %v", err)
+	}
+	ms.Close()
+	conn := &MockQUICConnection{}
+	listener.addPath(conn, handshakeResult{sessionID: "s1", multipathSession: ms})
+
+	if paths := len(ms.GetPathStats()); paths != 0 {
+		t.Errorf("Expected no paths on the closed session, got %d", paths)
+	}
+	conn.mutex.RLock()
+	closed := conn.closed
+	conn.mutex.RUnlock()
+	if !closed {
+		t.Error("Expected the connection of the path to be closed")
+	}
+}
+
+func TestMultipathServerInterleavedFECStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+
+	adaptiveFEC, err := NewAdaptiveFEC(4, 2, 1, 4)
+	if err != nil {
+		t.Fatalf("Failed to create AdaptiveFEC: %v", err)
+	}
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig(), FECInterleaveDepth: 4}, nil, adaptiveFEC)
+	defer client.Close()
+	if err := client.AddPath(ctx, listener.Addr().String()); err != nil {
+		t.Fatalf("Failed to add path: %v", err)
+	}
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+
+	// The configured depth interleaves the shards of the client's writes
+	stream, err := client.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer stream.Close()
+	fecStream, ok := stream.(*FECStream)
+	if !ok || fecStream.interleaver == nil || fecStream.interleaver.Depth() != 4 {
+		t.Fatalf("Expected an FEC stream interleaving 4 writes, got %T", stream)
+	}
+	var sent []byte
+	for i := 0; i < 6; i++ {
+		write := bytes.Repeat([]byte{byte('a' + i)}, 100+i)
+		if _, err := stream.Write(write); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		sent = append(sent, write...)
+	}
+
+	// The server decodes the groups, including the partial one flushed
+	// after the delay
+	accepted, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept stream: %v", err)
+	}
+	defer accepted.Close()
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(accepted, received); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Error("Expected the interleaved writes in order")
+	}
+}
@@ -0,0 +1,845 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// multipathStreamChunkSize is the largest chunk of a multipath stream.
+	// Each chunk is sent on one path.
+	multipathStreamChunkSize = 16 * 1024
+	// defaultMultipathStreamBuffer is the default number of bytes a
+	// multipath stream buffers in each direction.
+	defaultMultipathStreamBuffer = 1024 * 1024
+	// defaultHeadOfLineTimeout is the default time a multipath stream waits
+	// for a missing chunk while later chunks are buffered before it asks
+	// for it again.
+	defaultHeadOfLineTimeout = 2 * time.Second
+	// multipathStreamRetransmits is how many times a receiver asks for a
+	// missing chunk before the stream fails.
+	multipathStreamRetransmits = 3
+	// multipathStreamAckEvery is the number of chunks after which the
+	// receiver acknowledges at once rather than on the next tick.
+	multipathStreamAckEvery = 8
+	// multipathStreamTick is how often pending acknowledgements are sent
+	// and head-of-line blocking is checked.
+	multipathStreamTick = 50 * time.Millisecond
+	// multipathStreamSendAttempts is how many paths a chunk is tried on
+	// before the stream fails.
+	multipathStreamSendAttempts = 3
+	// multipathStreamInitialWindow is the number of chunks a sender may
+	// send before the receiver advertised a limit.
+	multipathStreamInitialWindow = 4
+	// maxMultipathStreams is the number of multipath streams a session
+	// keeps open at once.
+	maxMultipathStreams = 256
+	// multipathClosedStreamMemory is the number of closed multipath stream
+	// IDs a session remembers.
+	multipathClosedStreamMemory = 1024
+)
+
+// ErrHeadOfLineTimeout is returned by a multipath stream when a missing
+// chunk did not arrive although it was asked for again.
+var ErrHeadOfLineTimeout = errors.New("multipath stream: missing chunk timed out")
+
+// errMultipathStreamClosed is returned when a closed multipath stream is
+// used.
+var errMultipathStreamClosed = errors.New("multipath stream closed")
+
+// MultipathStream is an ordered, reliable byte stream over all paths of a
+// multipath session. Writes are split into numbered chunks that are sent
+// on the paths chosen by the session's path selector, and the receiver
+// puts them back in order. The receiver acknowledges the chunks it has,
+// so chunks sent on a path that fails are sent again on another one.
+// Each side buffers at most a bounded number of bytes: writes block while
+// too much is unacknowledged or the receiver has no room, and the receiver
+// advertises the chunks it has room for in its acknowledgements. Chunks
+// beyond that limit are dropped and sent again later. A chunk missing for the head-of-line
+// timeout is asked for again, and the stream fails with
+// ErrHeadOfLineTimeout if it still does not arrive.
+type MultipathStream struct {
+	// session is the multipath session the stream belongs to.
+	session *MultipathSession
+	// id identifies the stream on both sides.
+	id uint64
+	// data is true for the streams that carry SendData messages.
+	data bool
+	// maxBuffer is the number of bytes buffered in each direction.
+	maxBuffer int
+	// headOfLineTimeout is how long a missing chunk is waited for before
+	// it is asked for again.
+	headOfLineTimeout time.Duration
+	// log is the stream logger.
+	log *Logger
+	// ctx is cancelled when the stream is shut down.
+	ctx context.Context
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// subflows holds the stream's QUIC stream on each path.
+	subflows map[*Path]*subflow
+	// openMutex serializes the opening of subflows.
+	openMutex sync.Mutex
+
+	// sendSequence is the sequence number of the next chunk written.
+	sendSequence uint64
+	// unacked holds the chunks sent but not acknowledged, in order.
+	unacked []*sentChunk
+	// unackedBytes is the number of bytes in unacked.
+	unackedBytes int
+	// writeClosed is true once the last chunk was queued.
+	writeClosed bool
+	// peerLimit is the sequence number of the first chunk the peer has no
+	// room for.
+	peerLimit uint64
+
+	// next is the sequence number of the next chunk to read.
+	next uint64
+	// pending holds the chunks received out of order by sequence number.
+	pending map[uint64]*MultipathDataPayload
+	// pendingBytes is the number of bytes in pending.
+	pendingBytes int
+	// ready holds the bytes received in order and not read yet.
+	ready []byte
+	// finReceived is true once the last chunk of the peer arrived.
+	finReceived bool
+	// finSequence is the sequence number of the peer's last chunk.
+	finSequence uint64
+	// readClosed is true once the stream was closed locally. Chunks still
+	// arriving are acknowledged and dropped.
+	readClosed bool
+	// ackDue is true if the peer should be sent an acknowledgement.
+	ackDue bool
+	// sinceAck is the number of chunks received since the last
+	// acknowledgement.
+	sinceAck int
+	// gapSince is when the receiver started waiting for chunk next while
+	// later chunks are buffered, or zero.
+	gapSince time.Time
+	// retransmits is the number of times chunk next was asked for again.
+	retransmits int
+	// limit is the sequence number of the first chunk there is no room
+	// for.
+	limit uint64
+	// advertised is the limit last sent to the peer.
+	advertised uint64
+
+	// err is the error the stream failed with, or nil.
+	err error
+	// shutdown is true once the stream's subflows are being closed.
+	shutdown bool
+	// closeOnce ensures the stream is only closed once.
+	closeOnce sync.Once
+	// mutex protects the fields above.
+	mutex sync.Mutex
+	// cond is signalled when buffer space, data or an error is available.
+	cond *sync.Cond
+}
+
+// subflow is a multipath stream's QUIC stream on one path. It carries
+// chunks and acknowledgements in both directions.
+type subflow struct {
+	// path is the path of the stream.
+	path *Path
+	// stream is the QUIC stream.
+	stream quic.Stream
+	// mutex serializes the messages written to the stream.
+	mutex sync.Mutex
+}
+
+// write sends a message on the subflow.
+func (sf *subflow) write(msgType MessageType, data []byte) error {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	return WriteMessage(sf.stream, &Message{Type: msgType, Data: data})
+}
+
+// sentChunk is a chunk waiting for its acknowledgement.
+type sentChunk struct {
+	// payload is the chunk.
+	payload *MultipathDataPayload
+	// subflow is the subflow the chunk was last sent on, or nil if it was
+	// not sent yet.
+	subflow *subflow
+}
+
+// OpenMultipathStream opens an ordered byte stream over all paths of the
+// session. The peer accepts it with AcceptMultipathStream once the first
+// chunk arrives.
+func (ms *MultipathSession) OpenMultipathStream(ctx context.Context) (*MultipathStream, error) {
+	return ms.openMultipathStream(false)
+}
+
+// openMultipathStream opens a multipath stream. data marks the stream that
+// carries the session's SendData messages.
+func (ms *MultipathSession) openMultipathStream(data bool) (*MultipathStream, error) {
+	var id uint64
+	for id == 0 {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate multipath stream ID: %w", err)
+		}
+		id = binary.BigEndian.Uint64(b[:])
+	}
+
+	ms.multipathStreamsMutex.Lock()
+	defer ms.multipathStreamsMutex.Unlock()
+	if len(ms.multipathStreams) >= maxMultipathStreams {
+		return nil, fmt.Errorf("too many multipath streams open")
+	}
+	s := ms.newMultipathStream(id)
+	s.data = data
+	return s, nil
+}
+
+// AcceptMultipathStream accepts the next multipath stream the peer opened.
+func (ms *MultipathSession) AcceptMultipathStream(ctx context.Context) (*MultipathStream, error) {
+	select {
+	case stream := <-ms.acceptedMultipathStreams:
+		return stream, nil
+	case <-ms.closed:
+		return nil, fmt.Errorf("multipath session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// newMultipathStream creates and registers a multipath stream. The caller
+// must hold multipathStreamsMutex.
+func (ms *MultipathSession) newMultipathStream(id uint64) *MultipathStream {
+	maxBuffer := defaultMultipathStreamBuffer
+	headOfLineTimeout := defaultHeadOfLineTimeout
+	if ms.config != nil && ms.config.MultipathStreamBuffer > 0 {
+		maxBuffer = ms.config.MultipathStreamBuffer
+	}
+	if ms.config != nil && ms.config.HeadOfLineTimeout > 0 {
+		headOfLineTimeout = ms.config.HeadOfLineTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &MultipathStream{
+		session:           ms,
+		id:                id,
+		maxBuffer:         maxBuffer,
+		headOfLineTimeout: headOfLineTimeout,
+		log:               multipathLog.With("multipath_stream", fmt.Sprintf("%016x", id)),
+		ctx:               ctx,
+		cancel:            cancel,
+		subflows:          make(map[*Path]*subflow),
+		peerLimit:         multipathStreamInitialWindow,
+		pending:           make(map[uint64]*MultipathDataPayload),
+		limit:             multipathStreamInitialWindow,
+		advertised:        multipathStreamInitialWindow,
+	}
+	s.cond = sync.NewCond(&s.mutex)
+	ms.multipathStreams[id] = s
+	go s.maintain()
+	return s
+}
+
+// attachSubflow adds a QUIC stream the peer opened for a multipath stream.
+// The multipath stream is queued for AcceptMultipathStream, or for
+// ReceiveData if it carries SendData messages, if it is new.
+func (ms *MultipathSession) attachSubflow(path *Path, stream quic.Stream, payload *StreamTypePayload) {
+	id := payload.MultipathStream
+	ms.multipathStreamsMutex.Lock()
+	s, ok := ms.multipathStreams[id]
+	if !ok && (ms.closedMultipathStreams.contains(id) || len(ms.multipathStreams) >= maxMultipathStreams) {
+		if !ms.closedMultipathStreams.contains(id) {
+			multipathLog.Warn("Refusing multipath stream %016x: %d streams open", id, len(ms.multipathStreams))
+		}
+		ms.multipathStreamsMutex.Unlock()
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	if !ok {
+		s = ms.newMultipathStream(id)
+		s.data = payload.Data
+	}
+	ms.multipathStreamsMutex.Unlock()
+
+	if !ok {
+		queue := ms.acceptedMultipathStreams
+		if s.data {
+			queue = ms.peerData
+		}
+		select {
+		case queue <- s:
+		case <-ms.closed:
+			return
+		}
+	}
+
+	sf := &subflow{path: path, stream: stream}
+	s.mutex.Lock()
+	if s.subflows[path] == nil {
+		s.subflows[path] = sf
+	}
+	s.mutex.Unlock()
+	s.readSubflow(sf)
+}
+
+// removeMultipathStream unregisters a multipath stream.
+func (ms *MultipathSession) removeMultipathStream(id uint64) {
+	ms.multipathStreamsMutex.Lock()
+	defer ms.multipathStreamsMutex.Unlock()
+
+	delete(ms.multipathStreams, id)
+	ms.closedMultipathStreams.add(id)
+}
+
+// closedStreamIDs remembers the most recently closed multipath stream IDs.
+// The oldest ID is forgotten once multipathClosedStreamMemory are held.
+type closedStreamIDs struct {
+	// ids holds the remembered IDs.
+	ids map[uint64]struct{}
+	// order holds the remembered IDs from oldest to newest.
+	order []uint64
+}
+
+// add remembers a closed stream ID.
+func (c *closedStreamIDs) add(id uint64) {
+	if c.ids == nil {
+		c.ids = make(map[uint64]struct{})
+	}
+	if _, ok := c.ids[id]; ok {
+		return
+	}
+	if len(c.order) >= multipathClosedStreamMemory {
+		delete(c.ids, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.ids[id] = struct{}{}
+	c.order = append(c.order, id)
+}
+
+// contains reports whether a stream ID is remembered as closed.
+func (c *closedStreamIDs) contains(id uint64) bool {
+	_, ok := c.ids[id]
+	return ok
+}
+
+// selectPath selects a path with the session's path selector, avoiding
+// exclude unless it is the only active path.
+func (ms *MultipathSession) selectPath(exclude *Path) *Path {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for i := 0; i < len(ms.paths); i++ {
+		path := ms.pathSelector.SelectPath(ms.paths, &ms.nextPath)
+		if path == nil {
+			break
+		}
+		if path != exclude {
+			return path
+		}
+	}
+	if exclude != nil && exclude.active {
+		return exclude
+	}
+	return nil
+}
+
+// Write writes p as chunks spread over the paths. It blocks while the
+// peer has not acknowledged the stream's buffer size.
+func (s *MultipathStream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := len(p)
+		if size > multipathStreamChunkSize {
+			size = multipathStreamChunkSize
+		}
+		chunk, err := s.queue(p[:size], false)
+		if err != nil {
+			return written, err
+		}
+		if err := s.send(chunk, nil); err != nil {
+			s.fail(err)
+			return written, err
+		}
+		written += size
+		p = p[size:]
+	}
+	return written, nil
+}
+
+// Read reads the bytes received in order. It returns io.EOF once the peer
+// closed the stream and everything was read.
+func (s *MultipathStream) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.ready) == 0 && s.err == nil && !s.readClosed && !s.eof() {
+		s.cond.Wait()
+	}
+	if len(s.ready) > 0 {
+		n := copy(p, s.ready)
+		s.ready = s.ready[n:]
+		if len(s.ready) == 0 {
+			s.ready = nil
+		}
+		s.raiseLimit()
+		if s.windowClosed() {
+			go s.sendAck(false)
+		}
+		return n, nil
+	}
+	if s.readClosed {
+		return 0, errMultipathStreamClosed
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return 0, io.EOF
+}
+
+// Close sends the end of the stream to the peer and stops reading. The
+// stream's subflows are closed once the peer acknowledged everything.
+func (s *MultipathStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		var chunk *sentChunk
+		if chunk, err = s.queue(nil, true); err == nil {
+			if err = s.send(chunk, nil); err != nil {
+				s.fail(err)
+			}
+		}
+
+		s.mutex.Lock()
+		s.readClosed = true
+		s.ready = nil
+		s.raiseLimit()
+		s.cond.Broadcast()
+		s.mutex.Unlock()
+		go s.linger()
+	})
+	if errors.Is(err, errMultipathStreamClosed) {
+		return nil
+	}
+	return err
+}
+
+// eof reports whether the peer's last chunk was read into ready. The
+// caller must hold the mutex.
+func (s *MultipathStream) eof() bool {
+	return s.finReceived && s.next > s.finSequence
+}
+
+// queue numbers a chunk and keeps it until it is acknowledged. It blocks
+// while the unacknowledged bytes fill the buffer or the peer has no room
+// for the chunk. fin queues the last chunk, which has no data.
+func (s *MultipathStream) queue(data []byte, fin bool) (*sentChunk, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for !fin && (s.unackedBytes >= s.maxBuffer || s.sendSequence >= s.peerLimit) && s.err == nil && !s.writeClosed {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.writeClosed {
+		return nil, errMultipathStreamClosed
+	}
+
+	chunk := &sentChunk{payload: &MultipathDataPayload{
+		Sequence: s.sendSequence,
+		Data:     append([]byte(nil), data...),
+		Fin:      fin,
+	}}
+	s.sendSequence++
+	s.unacked = append(s.unacked, chunk)
+	s.unackedBytes += len(data)
+	s.writeClosed = fin
+	return chunk, nil
+}
+
+// send sends a chunk on a path chosen by the path selector, avoiding
+// exclude. It tries other paths if sending fails.
+func (s *MultipathStream) send(chunk *sentChunk, exclude *Path) error {
+	data, err := EncodeMultipathData(chunk.payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < multipathStreamSendAttempts; attempt++ {
+		path := s.session.selectPath(exclude)
+		if path == nil {
+			return fmt.Errorf("no active paths available for sending data")
+		}
+		sf, err := s.subflow(path)
+		if err == nil {
+			s.mutex.Lock()
+			chunk.subflow = sf
+			s.mutex.Unlock()
+			if err = sf.write(MultipathData, data); err == nil {
+				return nil
+			}
+			s.dropSubflow(sf)
+		}
+		path.logger().Warn("Failed to send chunk %d on path %s: %v", chunk.payload.Sequence, path.addr, err)
+		exclude = path
+	}
+	return fmt.Errorf("failed to send chunk %d on any path", chunk.payload.Sequence)
+}
+
+// resend sends chunks again that were sent on a path that failed or were
+// not received, avoiding that path.
+func (s *MultipathStream) resend(chunks []*sentChunk, exclude *Path) {
+	for _, chunk := range chunks {
+		s.mutex.Lock()
+		acked := len(s.unacked) == 0 || s.unacked[0].payload.Sequence > chunk.payload.Sequence
+		stop := s.err != nil || s.shutdown
+		s.mutex.Unlock()
+		if stop {
+			return
+		}
+		if acked {
+			continue
+		}
+		if err := s.send(chunk, exclude); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// subflow returns the stream's subflow on a path, opening it if needed.
+func (s *MultipathStream) subflow(path *Path) (*subflow, error) {
+	s.openMutex.Lock()
+	defer s.openMutex.Unlock()
+
+	s.mutex.Lock()
+	sf := s.subflows[path]
+	s.mutex.Unlock()
+	if sf != nil {
+		return sf, nil
+	}
+
+	stream, err := s.session.openTypedStream(s.ctx, path, &StreamTypePayload{
+		Type:            StreamTypeBulk,
+		MultipathStream: s.id,
+		Data:            s.data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sf = &subflow{path: path, stream: s.session.trackStream(stream, path, StreamTypeBulk, true)}
+
+	s.mutex.Lock()
+	s.subflows[path] = sf
+	s.mutex.Unlock()
+	go s.readSubflow(sf)
+	return sf, nil
+}
+
+// readSubflow reads the chunks and acknowledgements the peer sends on a
+// subflow until it fails.
+func (s *MultipathStream) readSubflow(sf *subflow) {
+	for {
+		msg, err := ReadMessage(sf.stream)
+		if err != nil {
+			s.dropSubflow(sf)
+			return
+		}
+		switch msg.Type {
+		case MultipathData:
+			payload, err := DecodeMultipathData(msg.Data)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			s.receive(payload)
+		case MultipathAck:
+			payload, err := DecodeMultipathAck(msg.Data)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			s.acknowledge(payload)
+		default:
+			s.fail(fmt.Errorf("unexpected message %d on multipath stream", msg.Type))
+			return
+		}
+	}
+}
+
+// dropSubflow closes a subflow that failed and sends the chunks that were
+// sent on it and not acknowledged on the other paths.
+func (s *MultipathStream) dropSubflow(sf *subflow) {
+	s.mutex.Lock()
+	if s.subflows[sf.path] == sf {
+		delete(s.subflows, sf.path)
+	}
+	var lost []*sentChunk
+	for _, chunk := range s.unacked {
+		if chunk.subflow == sf {
+			lost = append(lost, chunk)
+		}
+	}
+	stop := s.err != nil || s.shutdown
+	s.mutex.Unlock()
+
+	sf.stream.CancelRead(0)
+	sf.stream.Close()
+	if len(lost) > 0 && !stop {
+		s.log.Info("Sending %d chunks of path %s again", len(lost), sf.path.addr)
+		go s.resend(lost, sf.path)
+	}
+}
+
+// receive puts a chunk of the peer into the reorder buffer. It never
+// blocks, so acknowledgements on the subflow keep being read; a chunk
+// beyond the advertised limit is dropped, and the peer sends it again.
+func (s *MultipathStream) receive(payload *MultipathDataPayload) {
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return
+	}
+	if s.isDuplicate(payload.Sequence) {
+		// The acknowledgement may have been lost with a path
+		s.ackDue = true
+		s.mutex.Unlock()
+		return
+	}
+	if payload.Sequence >= s.limit && len(payload.Data) > 0 && !s.readClosed {
+		s.log.Debug("Dropping chunk %d beyond the limit %d", payload.Sequence, s.limit)
+		s.mutex.Unlock()
+		return
+	}
+
+	if payload.Fin {
+		s.finReceived = true
+		s.finSequence = payload.Sequence
+	}
+	s.pending[payload.Sequence] = payload
+	s.pendingBytes += len(payload.Data)
+	advanced := false
+	for {
+		chunk, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.pendingBytes -= len(chunk.Data)
+		if !s.readClosed {
+			s.ready = append(s.ready, chunk.Data...)
+		}
+		s.next++
+		s.sinceAck++
+		advanced = true
+	}
+
+	if advanced {
+		s.ackDue = true
+		s.retransmits = 0
+		s.gapSince = time.Time{}
+		s.raiseLimit()
+		s.cond.Broadcast()
+	}
+	if len(s.pending) > 0 && s.gapSince.IsZero() {
+		s.gapSince = time.Now()
+	}
+	ackNow := s.sinceAck >= multipathStreamAckEvery || s.eof() || s.windowClosed()
+	s.mutex.Unlock()
+
+	if ackNow {
+		s.sendAck(false)
+	}
+}
+
+// isDuplicate reports whether a chunk was already received. The caller
+// must hold the mutex.
+func (s *MultipathStream) isDuplicate(sequence uint64) bool {
+	_, pending := s.pending[sequence]
+	return sequence < s.next || pending
+}
+
+// raiseLimit raises the limit to the chunks the buffer has room for once
+// the unread bytes are taken into account. An empty buffer always has
+// room for one chunk. The limit never shrinks, as the peer may already
+// have sent up to it. The caller must hold the mutex.
+func (s *MultipathStream) raiseLimit() {
+	chunks := (s.maxBuffer - len(s.ready)) / multipathStreamChunkSize
+	if chunks < 1 && len(s.ready) == 0 {
+		chunks = 1
+	}
+	if chunks < 1 {
+		return
+	}
+	if limit := s.next + uint64(chunks); limit > s.limit {
+		s.limit = limit
+		s.ackDue = true
+	}
+}
+
+// windowClosed reports whether the peer used up the advertised limit
+// although the limit was raised since, so it waits for an
+// acknowledgement. The caller must hold the mutex.
+func (s *MultipathStream) windowClosed() bool {
+	return s.limit > s.advertised && s.next >= s.advertised
+}
+
+// sendAck acknowledges the chunks received in order on every subflow, so
+// it reaches the peer if a path fails. retransmit asks for the missing
+// chunk again.
+func (s *MultipathStream) sendAck(retransmit bool) {
+	s.mutex.Lock()
+	payload := &MultipathAckPayload{Next: s.next, Retransmit: retransmit, Limit: s.limit}
+	s.advertised = s.limit
+	s.ackDue = false
+	s.sinceAck = 0
+	subflows := make([]*subflow, 0, len(s.subflows))
+	for _, sf := range s.subflows {
+		subflows = append(subflows, sf)
+	}
+	s.mutex.Unlock()
+
+	data, err := EncodeMultipathAck(payload)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	for _, sf := range subflows {
+		// A failed subflow is dropped by its reader
+		sf.write(MultipathAck, data)
+	}
+}
+
+// acknowledge releases the chunks the peer received and sends the missing
+// chunk again if the peer asks for it.
+func (s *MultipathStream) acknowledge(payload *MultipathAckPayload) {
+	s.mutex.Lock()
+	released := false
+	for len(s.unacked) > 0 && s.unacked[0].payload.Sequence < payload.Next {
+		s.unackedBytes -= len(s.unacked[0].payload.Data)
+		s.unacked[0] = nil
+		s.unacked = s.unacked[1:]
+		released = true
+	}
+	if payload.Limit > s.peerLimit {
+		s.peerLimit = payload.Limit
+		released = true
+	}
+	if released {
+		s.cond.Broadcast()
+	}
+	var missing *sentChunk
+	var exclude *Path
+	if payload.Retransmit && len(s.unacked) > 0 && s.unacked[0].payload.Sequence == payload.Next {
+		missing = s.unacked[0]
+		if missing.subflow != nil {
+			exclude = missing.subflow.path
+		}
+	}
+	s.mutex.Unlock()
+
+	if missing != nil {
+		s.log.Info("Peer is missing chunk %d, sending it again", payload.Next)
+		go s.resend([]*sentChunk{missing}, exclude)
+	}
+}
+
+// maintain sends pending acknowledgements and handles head-of-line
+// blocking until the stream is shut down.
+func (s *MultipathStream) maintain() {
+	ticker := time.NewTicker(multipathStreamTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.session.closed:
+			s.fail(fmt.Errorf("multipath session closed"))
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			ack := s.ackDue
+			retransmit := false
+			if !s.gapSince.IsZero() && now.Sub(s.gapSince) > s.headOfLineTimeout {
+				if s.retransmits >= multipathStreamRetransmits {
+					next := s.next
+					s.mutex.Unlock()
+					s.log.Warn("Chunk %d did not arrive, giving up", next)
+					s.fail(ErrHeadOfLineTimeout)
+					return
+				}
+				s.retransmits++
+				s.gapSince = now
+				retransmit = true
+			}
+			s.mutex.Unlock()
+
+			if ack || retransmit {
+				s.sendAck(retransmit)
+			}
+		}
+	}
+}
+
+// fail stops the stream with err. Reads and writes return it.
+func (s *MultipathStream) fail(err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+		s.log.Warn("Multipath stream failed: %v", err)
+	}
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	s.close()
+}
+
+// linger waits until the peer acknowledged everything or the stream
+// failed, then closes the stream's subflows.
+func (s *MultipathStream) linger() {
+	deadline := time.Now().Add(s.headOfLineTimeout * (multipathStreamRetransmits + 1))
+	ticker := time.NewTicker(multipathStreamTick)
+	defer ticker.Stop()
+
+	for {
+		s.mutex.Lock()
+		done := len(s.unacked) == 0 || s.err != nil
+		s.mutex.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+	s.close()
+}
+
+// close closes the stream's subflows and unregisters it.
+func (s *MultipathStream) close() {
+	s.mutex.Lock()
+	if s.shutdown {
+		s.mutex.Unlock()
+		return
+	}
+	s.shutdown = true
+	subflows := s.subflows
+	s.subflows = make(map[*Path]*subflow)
+	s.mutex.Unlock()
+
+	s.cancel()
+	for _, sf := range subflows {
+		sf.stream.CancelRead(0)
+		sf.stream.Close()
+	}
+	s.session.removeMultipathStream(s.id)
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestMultipathStream creates a multipath stream on a session without
+// paths, which is fed chunks directly.
+func newTestMultipathStream(config *Config) *MultipathStream {
+	ms := NewMultipathSession(config, nil, nil)
+	ms.multipathStreamsMutex.Lock()
+	defer ms.multipathStreamsMutex.Unlock()
+	return ms.newMultipathStream(1)
+}
+
+func TestMultipathStreamReordering(t *testing.T) {
+	s := newTestMultipathStream(&Config{})
+	defer s.Close()
+
+	s.receive(&MultipathDataPayload{Sequence: 2, Data: []byte("c")})
+	s.receive(&MultipathDataPayload{Sequence: 0, Data: []byte("a")})
+	s.receive(&MultipathDataPayload{Sequence: 1, Data: []byte("b")})
+	s.receive(&MultipathDataPayload{Sequence: 1, Data: []byte("b")})
+	s.receive(&MultipathDataPayload{Sequence: 3, Fin: true})
+
+	data, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(data) != "abc" {
+		t.Errorf("Expected the chunks in order without duplicates, got %q", data)
+	}
+}
+
+func TestMultipathStreamBoundedBuffer(t *testing.T) {
+	s := newTestMultipathStream(&Config{MultipathStreamBuffer: multipathStreamInitialWindow * multipathStreamChunkSize})
+	defer s.Close()
+
+	// Chunks beyond the limit are dropped rather than waited for
+	chunk := bytes.Repeat([]byte("a"), multipathStreamChunkSize)
+	received := make(chan struct{})
+	go func() {
+		s.receive(&MultipathDataPayload{Sequence: multipathStreamInitialWindow, Data: chunk})
+		close(received)
+	}()
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a chunk beyond the limit not to block")
+	}
+	s.mutex.Lock()
+	_, kept := s.pending[multipathStreamInitialWindow]
+	s.mutex.Unlock()
+	if kept {
+		t.Fatal("Expected the chunk beyond the limit to be dropped")
+	}
+
+	// Unread chunks fill the buffer, so the limit stays put
+	for i := uint64(0); i < multipathStreamInitialWindow; i++ {
+		s.receive(&MultipathDataPayload{Sequence: i, Data: chunk})
+	}
+	s.mutex.Lock()
+	limit := s.limit
+	s.mutex.Unlock()
+	if limit != multipathStreamInitialWindow {
+		t.Fatalf("Expected the limit to stay at %d, got %d", multipathStreamInitialWindow, limit)
+	}
+
+	// Reading makes room, which raises the limit
+	if _, err := io.ReadFull(s, make([]byte, multipathStreamInitialWindow*multipathStreamChunkSize)); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	s.mutex.Lock()
+	limit = s.limit
+	s.mutex.Unlock()
+	if limit != 2*multipathStreamInitialWindow {
+		t.Errorf("Expected the limit to be raised to %d, got %d", 2*multipathStreamInitialWindow, limit)
+	}
+}
+
+func TestMultipathStreamHeadOfLineTimeout(t *testing.T) {
+	timeout := 30 * time.Millisecond
+	s := newTestMultipathStream(&Config{HeadOfLineTimeout: timeout})
+	defer s.Close()
+
+	// Chunk 0 never arrives
+	start := time.Now()
+	s.receive(&MultipathDataPayload{Sequence: 1, Data: []byte("b")})
+	_, err := s.Read(make([]byte, 1))
+	if !errors.Is(err, ErrHeadOfLineTimeout) {
+		t.Fatalf("Expected a head-of-line timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < multipathStreamRetransmits*timeout {
+		t.Errorf("Expected the chunk to be asked for %d times first, failed after %v", multipathStreamRetransmits, elapsed)
+	}
+}
+
+// newTestMultipathPair connects a client with two paths to a multipath
+// listener and returns both sessions.
+func newTestMultipathPair(t *testing.T, ctx context.Context) (*MultipathSession, *MultipathSession) {
+	t.Helper()
+	return connectTestMultipathPair(t, ctx, newTestMultipathListener(t, ctx))
+}
+
+// connectTestMultipathPair connects a client with two paths to listener
+// and returns both sessions.
+func connectTestMultipathPair(t *testing.T, ctx context.Context, listener *MultipathListener) (*MultipathSession, *MultipathSession) {
+	t.Helper()
+	addr := listener.Addr().String()
+
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig()}, nil, nil)
+	t.Cleanup(func() { client.Close() })
+	for i := 0; i < 2; i++ {
+		if err := client.AddPath(ctx, addr); err != nil {
+			t.Fatalf("Failed to add path %d: %v", i, err)
+		}
+	}
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.GetPathStats()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return client, server
+}
+
+func TestMultipathStreamAcrossPaths(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, server := newTestMultipathPair(t, ctx)
+
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+	stream, err := client.OpenMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open multipath stream: %v", err)
+	}
+	go func() {
+		if _, err := stream.Write(data); err != nil {
+			t.Errorf("Failed to write: %v", err)
+		}
+	}()
+
+	// The server echoes the data back over the same subflows
+	accepted, err := server.AcceptMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath stream: %v", err)
+	}
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted, received); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("Expected the data in order")
+	}
+	if _, err := accepted.Write(received); err != nil {
+		t.Fatalf("Failed to echo: %v", err)
+	}
+	echo := make([]byte, len(data))
+	if _, err := io.ReadFull(stream, echo); err != nil || !bytes.Equal(echo, data) {
+		t.Fatalf("Expected the echo in order: %v", err)
+	}
+
+	stream.mutex.Lock()
+	subflows := len(stream.subflows)
+	stream.mutex.Unlock()
+	if subflows != 2 {
+		t.Errorf("Expected chunks on both paths, got %d subflows", subflows)
+	}
+
+	// Closing ends the peer's stream
+	stream.Close()
+	if n, err := accepted.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Expected EOF, got %d bytes: %v", n, err)
+	}
+}
+
+func TestMultipathStreamSurvivesPathFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, server := newTestMultipathPair(t, ctx)
+
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+	stream, err := client.OpenMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open multipath stream: %v", err)
+	}
+	go func() {
+		half := len(data) / 2
+		if _, err := stream.Write(data[:half]); err != nil {
+			t.Errorf("Failed to write: %v", err)
+			return
+		}
+		// Chunks in flight on the removed path are sent again on the other
+		client.RemovePath(client.GetPathStats()[0].addr)
+		if _, err := stream.Write(data[half:]); err != nil {
+			t.Errorf("Failed to write after the path failed: %v", err)
+		}
+	}()
+
+	accepted, err := server.AcceptMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath stream: %v", err)
+	}
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted, received); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Error("Expected all data in order despite the failed path")
+	}
+}
+
+func TestMultipathStreamSurvivesPathFailureWithFullBuffer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	listener, err := ListenMultipath(ctx, &Config{
+		Address:               "localhost:0",
+		TLSConfig:             generateTLSConfig(),
+		IsServer:              true,
+		MultipathStreamBuffer: 4 * multipathStreamChunkSize,
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	client, server := connectTestMultipathPair(t, ctx, listener)
+
+	data := make([]byte, 512*1024)
+	rand.Read(data)
+	stream, err := client.OpenMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open multipath stream: %v", err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(data)
+		written <- err
+	}()
+
+	// Nothing is read until the server's buffer is full and the writer waits
+	accepted, err := server.AcceptMultipathStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath stream: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		accepted.mutex.Lock()
+		full := len(accepted.ready) >= accepted.maxBuffer
+		accepted.mutex.Unlock()
+		if full {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the receive buffer to fill")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.RemovePath(client.GetPathStats()[0].addr)
+
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(accepted, received); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Error("Expected all data in order despite the failed path")
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Failed to write: %v", err)
+	}
+}
+
+func TestMultipathStreamClosedIDsAreBounded(t *testing.T) {
+	ms := NewMultipathSession(&Config{}, nil, nil)
+	defer ms.Close()
+
+	for id := uint64(1); id <= multipathClosedStreamMemory+1; id++ {
+		ms.removeMultipathStream(id)
+	}
+	ms.multipathStreamsMutex.Lock()
+	defer ms.multipathStreamsMutex.Unlock()
+	if n := len(ms.closedMultipathStreams.order); n != multipathClosedStreamMemory {
+		t.Errorf("Expected %d closed IDs, got %d", multipathClosedStreamMemory, n)
+	}
+	if ms.closedMultipathStreams.contains(1) {
+		t.Error("Expected the oldest closed ID to be forgotten")
+	}
+	if !ms.closedMultipathStreams.contains(multipathClosedStreamMemory + 1) {
+		t.Error("Expected the newest closed ID to be remembered")
+	}
+}
+
+func TestMultipathStreamLimit(t *testing.T) {
+	ms := NewMultipathSession(&Config{}, nil, nil)
+	defer ms.Close()
+
+	streams := make([]*MultipathStream, 0, maxMultipathStreams)
+	for i := 0; i < maxMultipathStreams; i++ {
+		stream, err := ms.OpenMultipathStream(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open multipath stream %d: %v", i, err)
+		}
+		streams = append(streams, stream)
+	}
+	if _, err := ms.OpenMultipathStream(context.Background()); err == nil {
+		t.Error("Expected opening a stream beyond the limit to fail")
+	}
+
+	// Closing a stream makes room for another
+	streams[0].fail(errMultipathStreamClosed)
+	if _, err := ms.OpenMultipathStream(context.Background()); err != nil {
+		t.Errorf("Expected a stream to open once one closed: %v", err)
+	}
+	for _, stream := range streams {
+		stream.fail(errMultipathStreamClosed)
+	}
+}
+
+func TestMultipathSendDataInOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, server := newTestMultipathPair(t, ctx)
+
+	messages := make([][]byte, 4)
+	for i := range messages {
+		messages[i] = make([]byte, 100*1024+i)
+		rand.Read(messages[i])
+	}
+	go func() {
+		for i, message := range messages {
+			// Messages in flight on the removed path are sent again
+			if i == len(messages)/2 {
+				client.RemovePath(client.GetPathStats()[0].addr)
+			}
+			if err := client.SendData(ctx, message); err != nil {
+				t.Errorf("Failed to send message %d: %v", i, err)
+				return
+			}
+		}
+	}()
+
+	for i, message := range messages {
+		data, err := server.ReceiveData(ctx)
+		if err != nil {
+			t.Fatalf("Failed to receive message %d: %v", i, err)
+		}
+		if !bytes.Equal(data, message) {
+			t.Fatalf("Expected message %d whole and in order, got %d bytes", i, len(data))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// PathOptions configures the local end of a multipath path, so paths to
+// the same server can leave through different networks, e.g. Wi-Fi and
+// LTE or two ISPs.
+type PathOptions struct {
+	// LocalAddress is the local IP address the path sends from, with an
+	// optional port. The routing table picks it if it is empty.
+	LocalAddress string
+	// Interface is the network interface the path is bound to with
+	// SO_BINDTODEVICE, so its packets leave through it whatever the
+	// routing table says. It is only supported on Linux.
+	Interface string
+}
+
+// bound reports whether the options bind the path to a local address or
+// interface.
+func (o PathOptions) bound() bool {
+	return o.LocalAddress != "" || o.Interface != ""
+}
+
+// String returns the local address and interface of the options.
+func (o PathOptions) String() string {
+	switch {
+	case o.LocalAddress != "" && o.Interface != "":
+		return o.LocalAddress + "%" + o.Interface
+	case o.Interface != "":
+		return "%" + o.Interface
+	default:
+		return o.LocalAddress
+	}
+}
+
+// listenPath opens the UDP socket of a path to addr, bound to the local
+// address and interface of options. It also returns addr resolved in the
+// address family of the local address.
+func listenPath(ctx context.Context, addr string, options PathOptions) (net.PacketConn, net.Addr, error) {
+	network := "udp"
+	local := ":0"
+	if options.LocalAddress != "" {
+		local = options.LocalAddress
+		if ip := net.ParseIP(local); ip != nil {
+			local = net.JoinHostPort(local, "0")
+		}
+		localAddr, err := net.ResolveUDPAddr(network, local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve local address %s: %w", options.LocalAddress, err)
+		}
+		if localAddr.IP.To4() != nil {
+			network = "udp4"
+		} else if localAddr.IP != nil {
+			network = "udp6"
+		}
+		local = localAddr.String()
+	}
+
+	remote, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve %s: %w", addr, err)
+	}
+
+	var listenConfig net.ListenConfig
+	if options.Interface != "" {
+		if _, err := net.InterfaceByName(options.Interface); err != nil {
+			return nil, nil, fmt.Errorf("failed to find interface %s: %w", options.Interface, err)
+		}
+		listenConfig.Control = bindToDevice(options.Interface)
+	}
+	conn, err := listenConfig.ListenPacket(ctx, network, local)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to bind path to %s: %w", options, err)
+	}
+	return conn, remote, nil
+}
+
+// dialPath dials a QUIC connection to addr over a socket bound as options
+// say. The socket is closed with the connection.
+func dialPath(ctx context.Context, addr string, tlsConfig *tls.Config, options PathOptions) (quic.Connection, error) {
+	packetConn, remote, err := listenPath(ctx, addr, options)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.Dial(ctx, packetConn, remote, tlsConfig, newQUICConfig())
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+
+	// quic-go does not close sockets it did not create
+	go func() {
+		<-conn.Context().Done()
+		packetConn.Close()
+	}()
+	return conn, nil
+}
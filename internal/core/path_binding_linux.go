@@ -0,0 +1,24 @@
+package core
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice returns a socket control function that binds the socket to
+// a network interface with SO_BINDTODEVICE. It needs CAP_NET_RAW on
+// kernels before 5.7.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", iface, bindErr)
+		}
+		return nil
+	}
+}
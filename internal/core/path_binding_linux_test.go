@@ -0,0 +1,38 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenPathInterface(t *testing.T) {
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer peer.Close()
+
+	conn, remote, err := listenPath(context.Background(), peer.LocalAddr().String(), PathOptions{Interface: "lo"})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("Binding to an interface needs CAP_NET_RAW")
+	}
+	if err != nil {
+		t.Fatalf("Failed to bind path to lo: %v", err)
+	}
+	defer conn.Close()
+
+	// Packets of the bound socket reach the peer over lo
+	if _, err := conn.WriteTo([]byte("probe"), remote); err != nil {
+		t.Fatalf("Failed to send over lo: %v", err)
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, _, err := peer.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "probe" {
+		t.Errorf("Expected the probe over lo, got %q: %v", buf[:n], err)
+	}
+}
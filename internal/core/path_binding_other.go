@@ -0,0 +1,17 @@
+//go:build !linux
+
+package core
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice returns a socket control function that fails, since binding
+// a socket to an interface needs SO_BINDTODEVICE, which only Linux has.
+// Bind paths to the interface's local address instead.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("failed to bind to interface %s: only supported on Linux", iface)
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenPathLocalAddress(t *testing.T) {
+	conn, remote, err := listenPath(context.Background(), "localhost:4242", PathOptions{LocalAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to bind path: %v", err)
+	}
+	defer conn.Close()
+
+	if local := conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) || local.Port == 0 {
+		t.Errorf("Expected the socket on 127.0.0.1, got %v", local)
+	}
+	// The remote address is resolved in the family of the local address
+	if ip := remote.(*net.UDPAddr).IP; ip.To4() == nil {
+		t.Errorf("Expected an IPv4 remote address, got %v", ip)
+	}
+}
+
+func TestListenPathUnknownInterface(t *testing.T) {
+	if _, _, err := listenPath(context.Background(), "localhost:4242", PathOptions{Interface: "vantun-none0"}); err == nil {
+		t.Error("Expected binding to a missing interface to fail")
+	}
+}
+
+func TestMultipathPathBinding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	listener := newTestMultipathListener(t, ctx)
+	addr := listener.Addr().String()
+
+	client := NewMultipathSession(&Config{TLSConfig: generateTLSConfig()}, nil, nil)
+	defer client.Close()
+	if err := client.AddPath(ctx, addr); err != nil {
+		t.Fatalf("Failed to add path: %v", err)
+	}
+	options := PathOptions{LocalAddress: "127.0.0.1"}
+	if err := client.AddPathWithOptions(ctx, addr, options); err != nil {
+		t.Fatalf("Failed to add bound path: %v", err)
+	}
+
+	paths := client.GetPathStats()
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 paths, got %d", len(paths))
+	}
+	bound := paths[1]
+	if local := bound.conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected the bound path to send from 127.0.0.1, got %v", local)
+	}
+	name := addr + " via 127.0.0.1"
+	if bound.name() != name {
+		t.Errorf("Expected the bound path to be named %q, got %q", name, bound.name())
+	}
+
+	// Both paths to the same address are in one server session
+	server, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept multipath session: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.GetPathStats()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := len(server.GetPathStats()); count != 2 {
+		t.Errorf("Expected 2 server paths, got %d", count)
+	}
+
+	// The bound path is removed by its name, which closes its socket
+	if err := client.RemovePath(name); err != nil {
+		t.Fatalf("Failed to remove bound path: %v", err)
+	}
+	if count := len(client.GetPathStats()); count != 1 {
+		t.Errorf("Expected 1 path left, got %d", count)
+	}
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/quic-go/quic-go/qlog"
+)
+
+// qlogLog logs qlog export events.
+var qlogLog = ComponentLogger("qlog")
+
+// qlogFileSuffix is the file name suffix of qlog traces.
+const qlogFileSuffix = ".qlog"
+
+// qlogFileNameReplacer replaces characters that are not safe in file names
+// on every platform.
+var qlogFileNameReplacer = strings.NewReplacer(":", "-", "[", "", "]", "", "/", "-", "\\", "-")
+
+// QlogExporter writes a qlog trace per QUIC connection to a directory and
+// removes old traces when they exceed the configured size or age.
+type QlogExporter struct {
+	// dir is the directory the traces are written to.
+	dir string
+	// maxSize is the maximum total size of all traces in bytes.
+	// Zero disables size-based retention.
+	maxSize int64
+	// maxAge is the maximum age of a trace. Zero disables age-based retention.
+	maxAge time.Duration
+	// open holds the names of traces that are still being written.
+	open map[string]bool
+	// mutex protects open and serializes retention.
+	mutex sync.Mutex
+}
+
+// NewQlogExporter creates a new QlogExporter that writes to dir, creating
+// the directory if needed.
+func NewQlogExporter(dir string, maxSize int64, maxAge time.Duration) (*QlogExporter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create qlog directory: %w", err)
+	}
+
+	return &QlogExporter{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		open:    make(map[string]bool),
+	}, nil
+}
+
+// Tracer returns a qlog connection tracer for a new connection. The trace
+// file is named after the session ID and the remote address, which is only
+// known once the connection has started. Until the first session message
+// assigns a session ID, the original destination connection ID identifies
+// the session.
+func (qe *QlogExporter) Tracer(p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+	file := &qlogFile{
+		exporter:    qe,
+		sessionID:   connID.String(),
+		perspective: p,
+	}
+	tracer := qlog.NewConnectionTracer(file, p, connID)
+
+	return logging.NewMultiplexedConnectionTracer(&logging.ConnectionTracer{
+		StartedConnection: func(_, remote net.Addr, _, _ logging.ConnectionID) {
+			file.start(remote.String())
+		},
+	}, tracer)
+}
+
+// fileName returns the trace file name for a connection.
+func (qe *QlogExporter) fileName(sessionID, remoteAddr string, p logging.Perspective) string {
+	side := "client"
+	if p == logging.PerspectiveServer {
+		side = "server"
+	}
+	name := fmt.Sprintf("%s_%s_%s%s", sessionID, remoteAddr, side, qlogFileSuffix)
+	return qlogFileNameReplacer.Replace(name)
+}
+
+// opened records that a trace is being written.
+func (qe *QlogExporter) opened(name string) {
+	qe.mutex.Lock()
+	qe.open[name] = true
+	qe.mutex.Unlock()
+}
+
+// closed records that a trace is complete and applies the retention policy.
+func (qe *QlogExporter) closed(name string) {
+	qe.mutex.Lock()
+	delete(qe.open, name)
+	qe.mutex.Unlock()
+
+	if err := qe.Cleanup(); err != nil {
+		qlogLog.Warn("Failed to clean up qlog directory: %v", err)
+	}
+}
+
+// Cleanup removes completed traces that are older than the maximum age,
+// then removes the oldest completed traces until the total size of all
+// traces is within the maximum size. Traces still being written are kept.
+func (qe *QlogExporter) Cleanup() error {
+	qe.mutex.Lock()
+	defer qe.mutex.Unlock()
+
+	entries, err := os.ReadDir(qe.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read qlog directory: %w", err)
+	}
+
+	type trace struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var traces []trace
+	var total int64
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), qlogFileSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if qe.open[entry.Name()] {
+			total += info.Size()
+			continue
+		}
+		if qe.maxAge > 0 && now.Sub(info.ModTime()) > qe.maxAge {
+			qe.remove(entry.Name())
+			continue
+		}
+		traces = append(traces, trace{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	if qe.maxSize <= 0 {
+		return nil
+	}
+
+	// Remove the oldest traces first
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].modTime.Before(traces[j].modTime)
+	})
+	for _, t := range traces {
+		if total <= qe.maxSize {
+			break
+		}
+		qe.remove(t.name)
+		total -= t.size
+	}
+
+	return nil
+}
+
+// remove deletes a trace file.
+func (qe *QlogExporter) remove(name string) {
+	if err := os.Remove(filepath.Join(qe.dir, name)); err != nil && !os.IsNotExist(err) {
+		qlogLog.Warn("Failed to remove qlog trace %s: %v", name, err)
+	}
+}
+
+// qlogFile is the destination of a single qlog trace. Writes are buffered
+// in memory until the connection has started and the file name is known.
+type qlogFile struct {
+	// exporter is the exporter the trace belongs to.
+	exporter *QlogExporter
+	// sessionID identifies the session in the file name.
+	sessionID string
+	// perspective is the local perspective of the connection.
+	perspective logging.Perspective
+	// name is the file name, set once the file is created.
+	name string
+	// file is the trace file, or nil until the connection has started.
+	file *os.File
+	// writer buffers writes to the file.
+	writer *bufio.Writer
+	// pending holds the data written before the file was created.
+	pending bytes.Buffer
+	// err is the first error encountered while writing.
+	err error
+	// mutex protects all fields above.
+	mutex sync.Mutex
+}
+
+// start creates the trace file for the given remote address and writes
+// the buffered data to it.
+func (f *qlogFile) start(remoteAddr string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.createLocked(remoteAddr)
+}
+
+// createLocked creates the trace file if it does not exist yet.
+func (f *qlogFile) createLocked(remoteAddr string) {
+	if f.file != nil || f.err != nil {
+		return
+	}
+
+	name := f.exporter.fileName(f.sessionID, remoteAddr, f.perspective)
+	file, err := os.Create(filepath.Join(f.exporter.dir, name))
+	if err != nil {
+		f.err = fmt.Errorf("failed to create qlog trace: %w", err)
+		qlogLog.Warn("%v", f.err)
+		return
+	}
+	f.exporter.opened(name)
+	f.name = name
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+
+	if _, err := f.writer.Write(f.pending.Bytes()); err != nil {
+		f.err = fmt.Errorf("failed to write qlog trace: %w", err)
+	}
+	f.pending = bytes.Buffer{}
+}
+
+// Write writes trace data to the file, or buffers it until the file exists.
+func (f *qlogFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.file == nil {
+		return f.pending.Write(p)
+	}
+	return f.writer.Write(p)
+}
+
+// Close closes the trace file and applies the retention policy.
+func (f *qlogFile) Close() error {
+	f.mutex.Lock()
+	// A connection that never started is still recorded
+	f.createLocked("unknown")
+	file, writer, name := f.file, f.writer, f.name
+	f.file, f.writer = nil, nil
+	f.mutex.Unlock()
+
+	if file == nil {
+		return f.err
+	}
+	err := writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	f.exporter.closed(name)
+	return err
+}
+
+// qlogExporter is the exporter used for new connections, or nil if qlog
+// export is disabled.
+var qlogExporter atomic.Pointer[QlogExporter]
+
+// SetQlogExporter enables qlog export for connections created from now on.
+// Passing nil disables qlog export.
+func SetQlogExporter(exporter *QlogExporter) {
+	qlogExporter.Store(exporter)
+}
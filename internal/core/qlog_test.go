@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestQlogExporterRetention(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := NewQlogExporter(dir, 250, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create qlog exporter: %v", err)
+	}
+
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"expired.qlog", 2 * time.Hour},
+		{"oldest.qlog", 30 * time.Minute},
+		{"older.qlog", 20 * time.Minute},
+		{"newest.qlog", 10 * time.Minute},
+		{"notes.txt", 3 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, make([]byte, 100), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", f.name, err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatalf("Failed to set times of %s: %v", f.name, err)
+		}
+	}
+
+	if err := exporter.Cleanup(); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+
+	// The expired trace is removed by age and the oldest remaining by size
+	for name, exists := range map[string]bool{
+		"expired.qlog": false,
+		"oldest.qlog":  false,
+		"older.qlog":   true,
+		"newest.qlog":  true,
+		"notes.txt":    true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists && err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+}
+
+func TestQlogExportPerConnection(t *testing.T) {
+	dir := t.TempDir()
+	exporter, err := NewQlogExporter(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create qlog exporter: %v", err)
+	}
+	SetQlogExporter(exporter)
+	defer SetQlogExporter(nil)
+
+	cert, key, err := generateTestCert()
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	tlsCert, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"vantun"},
+	}, newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan quic.Connection, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := quic.DialAddr(ctx, listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"vantun"},
+	}, newQUICConfig())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	serverConn := <-accepted
+	conn.CloseWithError(0, "")
+	serverConn.CloseWithError(0, "")
+
+	// Traces are written when the connections are torn down
+	var names []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ := os.ReadDir(dir)
+		names = names[:0]
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && info.Size() > 0 {
+				names = append(names, entry.Name())
+			}
+		}
+		if len(names) == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(names) != 2 {
+		t.Fatalf("Expected a client and a server trace, got %v", names)
+	}
+
+	serverAddr := qlogFileNameReplacer.Replace(listener.Addr().String())
+	var sawClient, sawServer bool
+	for _, name := range names {
+		if strings.HasSuffix(name, "_"+serverAddr+"_client.qlog") {
+			sawClient = true
+		}
+		if strings.HasSuffix(name, "_server.qlog") {
+			sawServer = true
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if !strings.Contains(string(data), "connection_started") {
+			t.Errorf("Expected %s to contain qlog events", name)
+		}
+	}
+	if !sawClient || !sawServer {
+		t.Errorf("Unexpected trace names: %v", names)
+	}
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rate control algorithms that can be selected with NewRateController.
+const (
+	// RateControlAIMD raises the rate while loss is low and lowers it when
+	// loss is high.
+	RateControlAIMD = "aimd"
+	// RateControlBBR paces at the measured bottleneck bandwidth and probes
+	// for more, largely ignoring loss.
+	RateControlBBR = "bbr"
+	// RateControlFixed keeps the rate constant.
+	RateControlFixed = "fixed"
+)
+
+// RateController decides the sending rate of a TokenBucketController from
+// the telemetry collected every second.
+type RateController interface {
+	// Name returns the name of the algorithm.
+	Name() string
+	// Update returns the new rate (bytes per second) given the current rate
+	// and the latest telemetry, and the reason for the change. The reason
+	// is empty if the rate is unchanged.
+	Update(rate float64, data *TelemetryData) (float64, string)
+}
+
+// NewRateController creates the rate controller with the given name. rate
+// is the configured rate (bytes per second) the fixed controller keeps. An
+// empty name selects AIMD.
+func NewRateController(name string, rate float64) (RateController, error) {
+	switch name {
+	case "", RateControlAIMD:
+		return NewAIMDRateController(), nil
+	case RateControlBBR:
+		return NewBBRRateController(), nil
+	case RateControlFixed:
+		return NewFixedRateController(rate), nil
+	default:
+		return nil, fmt.Errorf("unknown rate control algorithm %q", name)
+	}
+}
+
+// AIMDRateController lowers the rate by a factor when loss is high and
+// raises it by a factor when loss is low, up to a maximum rate.
+type AIMDRateController struct {
+	// HighLoss is the loss rate above which the rate is lowered.
+	HighLoss float64
+	// LowLoss is the loss rate below which the rate is raised.
+	LowLoss float64
+	// Decrease is the factor the rate is lowered by.
+	Decrease float64
+	// Increase is the factor the rate is raised by.
+	Increase float64
+	// MaxRate is the rate (bytes per second) the controller does not
+	// raise the rate beyond.
+	MaxRate float64
+}
+
+// NewAIMDRateController creates an AIMDRateController that lowers the rate
+// by 10% above 5% loss and raises it by 10% below 1% loss, up to 10 MB/s.
+func NewAIMDRateController() *AIMDRateController {
+	return &AIMDRateController{
+		HighLoss: 0.05,
+		LowLoss:  0.01,
+		Decrease: 0.9,
+		Increase: 1.1,
+		MaxRate:  10000000,
+	}
+}
+
+// Name returns the name of the algorithm.
+func (c *AIMDRateController) Name() string {
+	return RateControlAIMD
+}
+
+// Update returns the new rate based on the loss rate.
+func (c *AIMDRateController) Update(rate float64, data *TelemetryData) (float64, string) {
+	if data.Loss > c.HighLoss {
+		return rate * c.Decrease, "high_loss"
+	}
+	if data.Loss < c.LowLoss {
+		// The rate is left alone rather than capped once the next step
+		// would exceed the maximum
+		if newRate := rate * c.Increase; newRate < c.MaxRate {
+			return newRate, "low_loss"
+		}
+	}
+	return rate, ""
+}
+
+// FixedRateController keeps the rate at a configured value regardless of
+// the network conditions.
+type FixedRateController struct {
+	// Rate is the rate in bytes per second.
+	Rate float64
+}
+
+// NewFixedRateController creates a FixedRateController.
+func NewFixedRateController(rate float64) *FixedRateController {
+	return &FixedRateController{Rate: rate}
+}
+
+// Name returns the name of the algorithm.
+func (c *FixedRateController) Name() string {
+	return RateControlFixed
+}
+
+// Update returns the configured rate.
+func (c *FixedRateController) Update(rate float64, data *TelemetryData) (float64, string) {
+	if rate == c.Rate {
+		return rate, ""
+	}
+	return c.Rate, "fixed"
+}
+
+const (
+	// bbrBandwidthWindow is the number of delivery rate samples the
+	// bottleneck bandwidth is the maximum of.
+	bbrBandwidthWindow = 10
+	// bbrMinRTTWindow is how long the minimum RTT is remembered.
+	bbrMinRTTWindow = 10 * time.Second
+	// bbrStartupGain is the gain the rate grows by each second in startup.
+	bbrStartupGain = 2.885
+	// bbrFullBandwidthGrowth is the growth of the bandwidth below which
+	// startup counts a round without growth.
+	bbrFullBandwidthGrowth = 1.25
+	// bbrFullBandwidthRounds is the number of rounds without growth after
+	// which startup ends.
+	bbrFullBandwidthRounds = 3
+	// bbrQueueRTTFactor is how far the RTT may grow above the minimum
+	// before the controller drains the queue it built.
+	bbrQueueRTTFactor = 1.25
+	// bbrMinRate is the lowest rate (bytes per second) the controller sets.
+	bbrMinRate = 16 * 1024
+)
+
+// bbrPacingGains is the cycle of gains applied to the bottleneck bandwidth
+// once startup is over: probe for more bandwidth, drain the queue the
+// probe built, then cruise.
+var bbrPacingGains = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// BBRRateController is a BBR-like rate controller. It estimates the
+// bottleneck bandwidth as the maximum recent delivery rate and paces at
+// it, periodically probing above it. In startup the rate grows by
+// bbrStartupGain until the delivery rate stops growing. Loss does not
+// lower the rate, which suits links with random loss such as satellite and
+// mobile networks.
+type BBRRateController struct {
+	// samples holds the recent delivery rates (bytes per second).
+	samples []float64
+	// minRTT is the lowest RTT seen within bbrMinRTTWindow.
+	minRTT time.Duration
+	// minRTTTime is when minRTT was measured.
+	minRTTTime time.Time
+	// startup is true until the bottleneck bandwidth has been found.
+	startup bool
+	// fullBandwidth is the bandwidth startup last saw grow.
+	fullBandwidth float64
+	// fullBandwidthRounds is the number of rounds since the bandwidth last
+	// grew in startup.
+	fullBandwidthRounds int
+	// cycle is the index in bbrPacingGains.
+	cycle int
+	// now returns the current time.
+	now func() time.Time
+}
+
+// NewBBRRateController creates a BBRRateController in startup.
+func NewBBRRateController() *BBRRateController {
+	return &BBRRateController{
+		startup: true,
+		now:     time.Now,
+	}
+}
+
+// Name returns the name of the algorithm.
+func (c *BBRRateController) Name() string {
+	return RateControlBBR
+}
+
+// Bandwidth returns the estimated bottleneck bandwidth in bytes per second.
+func (c *BBRRateController) Bandwidth() float64 {
+	var bandwidth float64
+	for _, sample := range c.samples {
+		bandwidth = math.Max(bandwidth, sample)
+	}
+	return bandwidth
+}
+
+// Update returns the bottleneck bandwidth times the current gain.
+func (c *BBRRateController) Update(rate float64, data *TelemetryData) (float64, string) {
+	now := c.now()
+	if data.DeliveryRate > 0 {
+		c.samples = append(c.samples, float64(data.DeliveryRate))
+		if len(c.samples) > bbrBandwidthWindow {
+			c.samples = c.samples[1:]
+		}
+	}
+	if data.RTT > 0 && (c.minRTT == 0 || data.RTT <= c.minRTT || now.Sub(c.minRTTTime) > bbrMinRTTWindow) {
+		c.minRTT = data.RTT
+		c.minRTTTime = now
+	}
+
+	// Nothing was delivered yet, so there is nothing to pace at
+	bandwidth := c.Bandwidth()
+	if bandwidth == 0 {
+		return rate, ""
+	}
+
+	var gain float64
+	var reason string
+	if c.startup {
+		if bandwidth >= c.fullBandwidth*bbrFullBandwidthGrowth {
+			c.fullBandwidth = bandwidth
+			c.fullBandwidthRounds = 0
+		} else {
+			c.fullBandwidthRounds++
+		}
+		c.startup = c.fullBandwidthRounds < bbrFullBandwidthRounds
+	}
+	if c.startup {
+		gain = bbrStartupGain
+		reason = "startup"
+	} else {
+		gain = bbrPacingGains[c.cycle]
+		c.cycle = (c.cycle + 1) % len(bbrPacingGains)
+		reason = "probe_bandwidth"
+
+		// A grown RTT means the probe built a queue, so drain it
+		if gain >= 1 && c.minRTT > 0 && float64(data.RTT) > float64(c.minRTT)*bbrQueueRTTFactor {
+			gain = bbrPacingGains[1]
+			reason = "drain"
+		}
+	}
+
+	newRate := math.Max(bandwidth*gain, bbrMinRate)
+	if newRate == rate {
+		return rate, ""
+	}
+	return newRate, reason
+}
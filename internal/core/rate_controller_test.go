@@ -0,0 +1,101 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRateController(t *testing.T) {
+	for name, want := range map[string]string{
+		"":      RateControlAIMD,
+		"aimd":  RateControlAIMD,
+		"bbr":   RateControlBBR,
+		"fixed": RateControlFixed,
+	} {
+		controller, err := NewRateController(name, 1000)
+		if err != nil {
+			t.Fatalf("Failed to create %q rate controller: %v", name, err)
+		}
+		if controller.Name() != want {
+			t.Errorf("Expected %q to select %s, got %s", name, want, controller.Name())
+		}
+	}
+	if _, err := NewRateController("cubic", 1000); err == nil {
+		t.Error("Expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestAIMDRateController(t *testing.T) {
+	controller := NewAIMDRateController()
+
+	if rate, reason := controller.Update(1000, &TelemetryData{Loss: 0.1}); rate != 900 || reason != "high_loss" {
+		t.Errorf("Expected high loss to lower the rate, got %v %q", rate, reason)
+	}
+	if rate, reason := controller.Update(1000, &TelemetryData{Loss: 0}); rate != 1100 || reason != "low_loss" {
+		t.Errorf("Expected low loss to raise the rate, got %v %q", rate, reason)
+	}
+	if rate, reason := controller.Update(1000, &TelemetryData{Loss: 0.03}); rate != 1000 || reason != "" {
+		t.Errorf("Expected moderate loss to keep the rate, got %v %q", rate, reason)
+	}
+	if rate, reason := controller.Update(9500000, &TelemetryData{}); rate != 9500000 || reason != "" {
+		t.Errorf("Expected the rate to stay below the maximum, got %v %q", rate, reason)
+	}
+}
+
+func TestFixedRateController(t *testing.T) {
+	controller := NewFixedRateController(5000)
+
+	if rate, reason := controller.Update(1000, &TelemetryData{Loss: 0.5}); rate != 5000 || reason != "fixed" {
+		t.Errorf("Expected the fixed rate, got %v %q", rate, reason)
+	}
+	if rate, reason := controller.Update(5000, &TelemetryData{Loss: 0.5}); rate != 5000 || reason != "" {
+		t.Errorf("Expected no change at the fixed rate, got %v %q", rate, reason)
+	}
+}
+
+func TestBBRRateController(t *testing.T) {
+	controller := NewBBRRateController()
+	now := time.Now()
+	controller.now = func() time.Time { return now }
+	update := func(rate float64, deliveryRate uint64, rtt time.Duration, loss float64) (float64, string) {
+		now = now.Add(time.Second)
+		return controller.Update(rate, &TelemetryData{DeliveryRate: deliveryRate, RTT: rtt, Loss: loss})
+	}
+
+	// Nothing delivered yet
+	if rate, reason := update(100000, 0, 0, 0); rate != 100000 || reason != "" {
+		t.Errorf("Expected no change without samples, got %v %q", rate, reason)
+	}
+
+	// Startup grows the rate while the delivery rate grows
+	rate, reason := update(100000, 100000, 50*time.Millisecond, 0)
+	if reason != "startup" || rate < 280000 {
+		t.Errorf("Expected startup to grow the rate, got %v %q", rate, reason)
+	}
+
+	// The delivery rate plateaus at the bottleneck, ending startup
+	for i := 0; i <= bbrFullBandwidthRounds; i++ {
+		rate, reason = update(rate, 200000, 50*time.Millisecond, 0)
+	}
+	if controller.startup {
+		t.Fatal("Expected startup to end once the bandwidth stopped growing")
+	}
+	if reason != "probe_bandwidth" || rate != 200000*bbrPacingGains[0] {
+		t.Errorf("Expected a bandwidth probe, got %v %q", rate, reason)
+	}
+
+	// Loss does not lower the rate
+	rate, _ = update(rate, 200000, 50*time.Millisecond, 0.2)
+	if rate != 200000*bbrPacingGains[1] {
+		t.Errorf("Expected the drain gain, got %v", rate)
+	}
+	rate, _ = update(rate, 200000, 50*time.Millisecond, 0.2)
+	if rate != 200000 {
+		t.Errorf("Expected to cruise at the bottleneck bandwidth despite loss, got %v", rate)
+	}
+
+	// A queue building up drains it
+	if rate, reason = update(rate, 200000, 100*time.Millisecond, 0); reason != "drain" || rate >= 200000 {
+		t.Errorf("Expected a grown RTT to drain the queue, got %v %q", rate, reason)
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// rateLimitedWriteSize is the largest part of a write that is paced at
+// once, so large writes leave at the limiter's rate.
+const rateLimitedWriteSize = 16 * 1024
+
+// rateLimitedStream is a stream whose writes are paced by a rate limiter
+// and ordered by a send scheduler. One token is taken per byte written.
+// Reads are not limited.
+type rateLimitedStream struct {
+	quic.Stream
+	// limiter paces the writes, or is nil if they are not paced.
+	limiter RateLimiter
+	// flow orders the writes among the session's streams, or is nil if
+	// they are not scheduled.
+	flow *SchedulerFlow
+}
+
+// newRateLimitedStream wraps a stream so its writes are paced by limiter.
+func newRateLimitedStream(stream quic.Stream, limiter RateLimiter) *rateLimitedStream {
+	return &rateLimitedStream{
+		Stream:  stream,
+		limiter: limiter,
+	}
+}
+
+// newScheduledStream wraps a stream so its writes are ordered by flow and
+// paced by limiter, which may be nil.
+func newScheduledStream(stream quic.Stream, limiter RateLimiter, flow *SchedulerFlow) *rateLimitedStream {
+	return &rateLimitedStream{
+		Stream:  stream,
+		limiter: limiter,
+		flow:    flow,
+	}
+}
+
+// Write writes to the stream, blocking until the limiter has tokens for the
+// bytes. Large writes are split so the bytes leave at the limiter's rate.
+// It fails if the stream is closed or cancelled while waiting.
+func (s *rateLimitedStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		part := len(p) - written
+		if limit := s.partSize(); part > limit {
+			part = limit
+		}
+		release, err := s.wait(part)
+		if err != nil {
+			return written, err
+		}
+		n, err := s.Stream.Write(p[written : written+part])
+		release()
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// partSize returns the largest part of a write that is sent at once: the
+// flow's quantum if the writes are scheduled.
+func (s *rateLimitedStream) partSize() int {
+	if s.flow != nil {
+		return s.flow.Quantum()
+	}
+	return rateLimitedWriteSize
+}
+
+// wait blocks until a write of the given size may be sent. With a flow,
+// the write first takes the tokens of its own stream type, then waits for
+// the send turn, and takes the tokens shared with the other streams while
+// holding it, so the highest priority write gets them first. The returned
+// function gives the turn back.
+func (s *rateLimitedStream) wait(bytes int) (func(), error) {
+	ctx := s.Stream.Context()
+	release := func() {}
+	acquire := func() error {
+		if s.flow == nil {
+			return nil
+		}
+		turn, err := s.flow.Acquire(ctx, bytes)
+		if err != nil {
+			return err
+		}
+		release = turn
+		return nil
+	}
+
+	var err error
+	switch limiter := s.limiter.(type) {
+	case nil:
+		err = acquire()
+	case *RateLimitNode:
+		err = limiter.waitThen(ctx, float64(bytes), acquire)
+	default:
+		if err = acquire(); err == nil {
+			err = limiter.Wait(ctx, float64(bytes))
+		}
+	}
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to wait for send tokens: %w", err)
+	}
+	return release, nil
+}
+
+// RateLimitedDatagramSender sends datagrams on a connection, paced by a
+// rate limiter. One token is taken per byte sent.
+type RateLimitedDatagramSender struct {
+	// conn is the connection the datagrams are sent on.
+	conn quic.Connection
+	// limiter paces the datagrams.
+	limiter RateLimiter
+}
+
+// NewRateLimitedDatagramSender creates a new RateLimitedDatagramSender.
+func NewRateLimitedDatagramSender(conn quic.Connection, limiter RateLimiter) *RateLimitedDatagramSender {
+	return &RateLimitedDatagramSender{
+		conn:    conn,
+		limiter: limiter,
+	}
+}
+
+// SendDatagram sends a datagram once the limiter has tokens for it. It
+// fails if ctx is done or the connection is closed while waiting.
+func (s *RateLimitedDatagramSender) SendDatagram(ctx context.Context, p []byte) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.conn.Context(), cancel)
+	defer stop()
+
+	if err := s.limiter.Wait(ctx, float64(len(p))); err != nil {
+		return fmt.Errorf("failed to wait for send tokens: %w", err)
+	}
+	return s.conn.SendDatagram(p)
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// contextStream is a mock stream with a cancellable context.
+type contextStream struct {
+	*MockQUICStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestRateLimitedStreamPacesWrites(t *testing.T) {
+	// 1000 bytes are available at once, the rest arrive at 10000 bytes/s
+	bucket := NewTokenBucket(10000, 1000)
+	mock := &MockQUICStream{}
+	stream := newRateLimitedStream(mock, bucket)
+
+	data := bytes.Repeat([]byte("vantun"), 500)
+	start := time.Now()
+	n, err := stream.Write(data)
+	if err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if n != len(data) || !bytes.Equal(mock.writeData, data) {
+		t.Errorf("Expected all %d bytes to be written intact, got %d", len(data), n)
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("Expected 3000 bytes to take about 200ms, took %v", elapsed)
+	}
+}
+
+func TestRateLimitedStreamUnblocksOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bucket := NewTokenBucket(0, 10)
+	stream := newRateLimitedStream(&contextStream{MockQUICStream: &MockQUICStream{}, ctx: ctx}, bucket)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(make([]byte, 100))
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the write to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after the stream was cancelled")
+	}
+}
+
+func TestRateLimitedDatagramSender(t *testing.T) {
+	sender := NewRateLimitedDatagramSender(&MockQUICConnection{}, NewTokenBucket(1000, 100))
+
+	if err := sender.SendDatagram(context.Background(), make([]byte, 100)); err != nil {
+		t.Fatalf("Failed to send datagram: %v", err)
+	}
+
+	// The bucket is empty, so 500 bytes take half a second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sender.SendDatagram(ctx, make([]byte, 500)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the send to time out, got %v", err)
+	}
+}
+
+func TestSessionStreamsAreRateLimited(t *testing.T) {
+	session := newSession(&MockQUICConnection{}, "")
+	session.rateLimiter = NewTokenBucket(1000, 1000)
+
+	stream, err := session.OpenBulkStream(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open bulk stream: %v", err)
+	}
+	metered, ok := stream.(*meteredStream)
+	if !ok {
+		t.Fatalf("Expected a metered stream, got %T", stream)
+	}
+	if _, ok := metered.Stream.(*rateLimitedStream); !ok {
+		t.Errorf("Expected the stream to be rate limited, got %T", metered.Stream)
+	}
+}
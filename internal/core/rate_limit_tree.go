@@ -0,0 +1,370 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// unlimitedRate is the rate of nodes without a limit (bytes per second).
+	unlimitedRate = 1e12
+	// rateLimitBurst is how much of its rate a node may send at once.
+	rateLimitBurst = 100 * time.Millisecond
+	// minRateLimitCapacity is the smallest capacity of a node's bucket, so
+	// slow nodes can still send a full packet at once.
+	minRateLimitCapacity = 1500
+	// rateLimitRebalanceInterval is how often the rates of the nodes are
+	// redistributed.
+	rateLimitRebalanceInterval = 100 * time.Millisecond
+	// rateLimitHeadroom is the factor by which a node may exceed its recent
+	// demand, so it can ramp up to its fair share.
+	rateLimitHeadroom = 2
+)
+
+// RateLimiter paces data. One token is taken per byte.
+type RateLimiter interface {
+	// Wait blocks until the tokens have been taken, or until ctx is done.
+	Wait(ctx context.Context, tokens float64) error
+}
+
+// defaultStreamWeights are the weights of the stream types within a
+// session. Interactive and telemetry streams keep most of the session's
+// rate when bulk streams saturate it.
+var defaultStreamWeights = map[uint8]float64{
+	StreamTypeInteractive: 8,
+	StreamTypeBulk:        1,
+	StreamTypeTelemetry:   8,
+}
+
+// RateLimitPolicy configures the rate limits below the server-wide cap.
+type RateLimitPolicy struct {
+	// UserRate caps each user (bytes per second). Zero means users are
+	// only limited by their share of the server-wide cap.
+	UserRate float64
+	// SessionRate caps each session (bytes per second). Zero means
+	// sessions are only limited by their share of the user's rate.
+	SessionRate float64
+	// StreamWeights are the weights of the stream types within a session,
+	// both for sharing its rate and for scheduling streams of equal
+	// priority. Missing stream types use the default weights.
+	StreamWeights map[uint8]float64
+}
+
+// streamWeight returns the weight of a stream type.
+func (p RateLimitPolicy) streamWeight(streamType uint8) float64 {
+	if weight, ok := p.StreamWeights[streamType]; ok && weight > 0 {
+		return weight
+	}
+	if weight, ok := defaultStreamWeights[streamType]; ok {
+		return weight
+	}
+	return 1
+}
+
+// rateLimitTree holds the state shared by the nodes of a tree.
+type rateLimitTree struct {
+	// lastRebalance is the time the rates were last redistributed.
+	lastRebalance time.Time
+	// mutex protects the tree structure, the node settings and the demand
+	// counters.
+	mutex sync.Mutex
+}
+
+// RateLimitNode is a node in a tree of token buckets, e.g. server → user →
+// session → stream type. Sending through a node takes tokens from the node
+// and all of its ancestors, so every level's limit holds. The rate of a
+// node is shared among its children by weight: children that need less
+// than their share keep what they use, and the rest is split among the
+// others in proportion to their weights. It is safe for concurrent use.
+type RateLimitNode struct {
+	// name identifies the node among its siblings.
+	name string
+	// parent is the parent node, or nil for the root.
+	parent *RateLimitNode
+	// tree is the state shared by the nodes of the tree.
+	tree *rateLimitTree
+	// bucket paces the data sent through the node.
+	bucket *TokenBucket
+	// weight is the node's weight relative to its siblings.
+	weight float64
+	// limit caps the rate of the node. Zero means no cap.
+	limit float64
+	// children maps names to child nodes.
+	children map[string]*RateLimitNode
+	// drawn is the number of tokens taken since the last rebalance.
+	drawn float64
+	// waiters is the number of senders waiting for tokens.
+	waiters int
+}
+
+// NewRateLimitTree creates the root of a tree of rate limits. The rate of
+// the root is the rate of bucket, which may be adjusted while the tree is
+// in use. A nil bucket makes the root unlimited.
+func NewRateLimitTree(bucket *TokenBucket) *RateLimitNode {
+	if bucket == nil {
+		bucket = NewTokenBucket(unlimitedRate, unlimitedRate*rateLimitBurst.Seconds())
+	}
+	return &RateLimitNode{
+		tree:     &rateLimitTree{lastRebalance: time.Now()},
+		bucket:   bucket,
+		weight:   1,
+		children: make(map[string]*RateLimitNode),
+	}
+}
+
+// Child returns the child node with the given name, creating it if needed.
+// The weight and limit (bytes per second, zero for none) of an existing
+// child are updated.
+func (n *RateLimitNode) Child(name string, weight, limit float64) *RateLimitNode {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	n.tree.mutex.Lock()
+	defer n.tree.mutex.Unlock()
+
+	child, ok := n.children[name]
+	if !ok {
+		child = &RateLimitNode{
+			name:     name,
+			parent:   n,
+			tree:     n.tree,
+			children: make(map[string]*RateLimitNode),
+		}
+		n.children[name] = child
+	}
+	child.weight = weight
+	child.limit = limit
+	if !ok {
+		// Start with the share the child would get if it were busy
+		rate := n.bucket.GetRate() * weight / (n.busyWeight() + weight)
+		if limit > 0 && limit < rate {
+			rate = limit
+		}
+		child.bucket = NewTokenBucket(rate, rateLimitCapacity(rate))
+	}
+	return child
+}
+
+// setLimit updates the weight and limit (bytes per second, zero for none)
+// of the node. They take effect at the next rebalance.
+func (n *RateLimitNode) setLimit(weight, limit float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	n.tree.mutex.Lock()
+	defer n.tree.mutex.Unlock()
+
+	n.weight = weight
+	n.limit = limit
+}
+
+// setChildLimit updates the weight and limit of the child with the given
+// name, if there is one.
+func (n *RateLimitNode) setChildLimit(name string, weight, limit float64) {
+	n.tree.mutex.Lock()
+	child, ok := n.children[name]
+	n.tree.mutex.Unlock()
+
+	if ok {
+		child.setLimit(weight, limit)
+	}
+}
+
+// Remove detaches the node from its parent. Senders using the node or its
+// children are no longer limited by the node's share. Ancestors left
+// without children, such as the node of a user whose last session ended,
+// are removed too, except the root.
+func (n *RateLimitNode) Remove() {
+	n.tree.mutex.Lock()
+	defer n.tree.mutex.Unlock()
+
+	for node := n; node.parent != nil; node = node.parent {
+		if node.parent.children[node.name] == node {
+			delete(node.parent.children, node.name)
+		}
+		if len(node.parent.children) > 0 {
+			break
+		}
+	}
+}
+
+// Rate returns the current rate of the node (bytes per second).
+func (n *RateLimitNode) Rate() float64 {
+	return n.bucket.GetRate()
+}
+
+// Wait blocks until the tokens have been taken from the node and all of
+// its ancestors, or until ctx is done.
+func (n *RateLimitNode) Wait(ctx context.Context, tokens float64) error {
+	return n.waitThen(ctx, tokens, nil)
+}
+
+// waitThen is Wait, calling between, if set, once the node has given its
+// tokens and before the ancestors are asked for theirs.
+func (n *RateLimitNode) waitThen(ctx context.Context, tokens float64, between func() error) error {
+	n.tree.maybeRebalance(n.root())
+
+	// The whole path counts as waiting until every level has given its
+	// tokens, so a node held back by an ancestor still asks for its share
+	n.tree.mutex.Lock()
+	for node := n; node != nil; node = node.parent {
+		node.waiters++
+	}
+	n.tree.mutex.Unlock()
+	defer func() {
+		n.tree.mutex.Lock()
+		for node := n; node != nil; node = node.parent {
+			node.waiters--
+		}
+		n.tree.mutex.Unlock()
+	}()
+
+	for node := n; node != nil; node = node.parent {
+		if err := node.bucket.Wait(ctx, tokens); err != nil {
+			return err
+		}
+
+		n.tree.mutex.Lock()
+		node.drawn += tokens
+		n.tree.mutex.Unlock()
+
+		if node == n && between != nil {
+			if err := between(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// root returns the root of the tree.
+func (n *RateLimitNode) root() *RateLimitNode {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// busyWeight returns the total weight of the children that sent data since
+// the last rebalance or are waiting to. The caller must hold the mutex.
+func (n *RateLimitNode) busyWeight() float64 {
+	var total float64
+	for _, child := range n.children {
+		if child.busy() {
+			total += child.weight
+		}
+	}
+	return total
+}
+
+// busy reports whether the node sent data since the last rebalance or is
+// waiting to. The caller must hold the mutex.
+func (n *RateLimitNode) busy() bool {
+	return n.drawn > 0 || n.waiters > 0
+}
+
+// maybeRebalance redistributes the rates if the last rebalance is older
+// than the rebalance interval.
+func (t *rateLimitTree) maybeRebalance(root *RateLimitNode) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(t.lastRebalance)
+	if elapsed < rateLimitRebalanceInterval {
+		return
+	}
+	t.lastRebalance = now
+	root.rebalance(elapsed)
+}
+
+// rebalance shares the rate of the node among its children and resets the
+// demand counters of the subtree. The caller must hold the mutex.
+func (n *RateLimitNode) rebalance(elapsed time.Duration) {
+	rate := n.bucket.GetRate()
+
+	// Children waiting for tokens want as much as they can get, the others
+	// as much as they recently sent plus headroom
+	var busy []*RateLimitNode
+	demand := make(map[*RateLimitNode]float64)
+	for _, child := range n.children {
+		if !child.busy() {
+			continue
+		}
+		busy = append(busy, child)
+		if child.waiters > 0 {
+			demand[child] = math.Inf(1)
+		} else {
+			demand[child] = child.drawn / elapsed.Seconds() * rateLimitHeadroom
+		}
+		if child.limit > 0 && child.limit < demand[child] {
+			demand[child] = child.limit
+		}
+	}
+	shares := waterFill(rate, busy, demand)
+
+	var busyWeight float64
+	for _, child := range busy {
+		busyWeight += child.weight
+	}
+	for _, child := range n.children {
+		share, ok := shares[child]
+		if !ok {
+			// Idle children get the share they would get if they started
+			// sending, so they can start without waiting for a rebalance
+			share = rate * child.weight / (busyWeight + child.weight)
+		}
+		if child.limit > 0 && child.limit < share {
+			share = child.limit
+		}
+		child.setRate(share)
+		child.rebalance(elapsed)
+	}
+	n.drawn = 0
+}
+
+// waterFill shares rate among nodes by weight, giving nodes that demand
+// less than their share their demand and splitting the rest among the
+// others. This is weighted max-min fairness.
+func waterFill(rate float64, nodes []*RateLimitNode, demand map[*RateLimitNode]float64) map[*RateLimitNode]float64 {
+	shares := make(map[*RateLimitNode]float64, len(nodes))
+
+	// Satisfy the nodes in order of demand per weight
+	sort.Slice(nodes, func(i, j int) bool {
+		return demand[nodes[i]]/nodes[i].weight < demand[nodes[j]]/nodes[j].weight
+	})
+	var totalWeight float64
+	for _, node := range nodes {
+		totalWeight += node.weight
+	}
+	for _, node := range nodes {
+		fair := rate * node.weight / totalWeight
+		share := math.Min(demand[node], fair)
+		shares[node] = share
+		rate -= share
+		totalWeight -= node.weight
+	}
+	return shares
+}
+
+// setRate sets the rate of the node's bucket and sizes its capacity for
+// the rate.
+func (n *RateLimitNode) setRate(rate float64) {
+	// Small changes are not worth waking up the waiters
+	if current := n.bucket.GetRate(); math.Abs(current-rate) <= current*0.01 {
+		return
+	}
+	n.bucket.SetCapacity(rateLimitCapacity(rate))
+	n.bucket.SetRate(rate)
+}
+
+// rateLimitCapacity returns the bucket capacity of a node with the given
+// rate.
+func rateLimitCapacity(rate float64) float64 {
+	return math.Max(rate*rateLimitBurst.Seconds(), minRateLimitCapacity)
+}
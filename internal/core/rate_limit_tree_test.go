@@ -0,0 +1,159 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaterFill(t *testing.T) {
+	a := &RateLimitNode{name: "a", weight: 1}
+	b := &RateLimitNode{name: "b", weight: 1}
+	c := &RateLimitNode{name: "c", weight: 2}
+
+	// a needs little, so b and c split the rest 1:2
+	shares := waterFill(1000, []*RateLimitNode{a, b, c}, map[*RateLimitNode]float64{
+		a: 100,
+		b: math.Inf(1),
+		c: math.Inf(1),
+	})
+	if shares[a] != 100 || shares[b] != 300 || shares[c] != 600 {
+		t.Errorf("Unexpected shares a=%v b=%v c=%v", shares[a], shares[b], shares[c])
+	}
+}
+
+func TestRateLimitTreeKeepsInteractiveShare(t *testing.T) {
+	root := NewRateLimitTree(NewTokenBucket(100000, 10000))
+	session := root.Child("s1", 1, 0)
+	interactive := session.Child("interactive", 8, 0)
+	bulk := session.Child("bulk", 1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var interactiveSent, bulkSent atomic.Int64
+	var wg sync.WaitGroup
+	send := func(node *RateLimitNode, sent *atomic.Int64) {
+		defer wg.Done()
+		for node.Wait(ctx, 1000) == nil {
+			sent.Add(1000)
+		}
+	}
+	wg.Add(2)
+	go send(interactive, &interactiveSent)
+	go send(bulk, &bulkSent)
+
+	// Skip the initial bursts, then measure both saturating the session
+	time.Sleep(300 * time.Millisecond)
+	interactiveStart, bulkStart := interactiveSent.Load(), bulkSent.Load()
+	time.Sleep(time.Second)
+	interactiveBytes := interactiveSent.Load() - interactiveStart
+	bulkBytes := bulkSent.Load() - bulkStart
+	cancel()
+	wg.Wait()
+
+	if interactiveBytes < 4*bulkBytes {
+		t.Errorf("Expected interactive traffic to keep most of the rate, sent %d interactive and %d bulk bytes",
+			interactiveBytes, bulkBytes)
+	}
+	if total := interactiveBytes + bulkBytes; total > 120000 {
+		t.Errorf("Expected the server-wide cap to hold, sent %d bytes", total)
+	}
+}
+
+func TestRateLimitTreeChildLimit(t *testing.T) {
+	root := NewRateLimitTree(nil)
+	user := root.Child("alice", 1, 5000)
+	session := user.Child("s1", 1, 0)
+
+	if rate := session.Rate(); rate > 5000 {
+		t.Errorf("Expected the session to start below the user's limit, got %v", rate)
+	}
+
+	// The user's bucket holds at most 1500 bytes at 5000 bytes/s
+	start := time.Now()
+	if err := session.Wait(context.Background(), 2500); err != nil {
+		t.Fatalf("Failed to wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the user's limit to pace the session, took %v", elapsed)
+	}
+}
+
+func TestRateLimitTreeRemove(t *testing.T) {
+	root := NewRateLimitTree(nil)
+	session := root.Child("s1", 1, 0)
+	if root.Child("s1", 1, 0) != session {
+		t.Fatal("Expected the existing child to be returned")
+	}
+
+	session.Remove()
+	if root.Child("s1", 1, 0) == session {
+		t.Error("Expected a removed child to be replaced")
+	}
+}
+
+func TestSessionRateLimitTree(t *testing.T) {
+	root := NewRateLimitTree(nil)
+	session := newSession(&MockQUICConnection{}, "s1")
+	session.startRateLimiting(&Config{
+		RateLimitTree:   root,
+		RateLimitPolicy: RateLimitPolicy{SessionRate: 1000},
+	}, "alice")
+
+	limiter, ok := session.streamLimiter(StreamTypeBulk).(*RateLimitNode)
+	if !ok {
+		t.Fatalf("Expected a rate limit node, got %T", session.streamLimiter(StreamTypeBulk))
+	}
+	if limiter.name != "bulk" || limiter.parent.name != "s1" || limiter.parent.parent.name != "alice" {
+		t.Errorf("Unexpected node path %s/%s/%s", limiter.parent.parent.name, limiter.parent.name, limiter.name)
+	}
+	if limiter.parent.limit != 1000 {
+		t.Errorf("Expected the session limit to be applied, got %v", limiter.parent.limit)
+	}
+
+	session.stopRateLimiting()
+	if _, ok := root.children["alice"]; ok {
+		t.Error("Expected the session and its user to be removed from the tree")
+	}
+}
+
+func TestSetRateLimitPolicy(t *testing.T) {
+	t.Cleanup(func() {
+		liveRateLimits.mutex.Lock()
+		liveRateLimits.reloaded = false
+		liveRateLimits.mutex.Unlock()
+	})
+
+	root := NewRateLimitTree(nil)
+	config := &Config{
+		RateLimitTree:   root,
+		RateLimitPolicy: RateLimitPolicy{UserRate: 5000, SessionRate: 1000},
+	}
+	running := newSession(&MockQUICConnection{}, "s1")
+	running.startRateLimiting(config, "alice")
+	defer running.stopRateLimiting()
+	bulk := running.streamLimiter(StreamTypeBulk).(*RateLimitNode)
+
+	// The reloaded policy is applied to the running session's nodes
+	SetRateLimitPolicy(RateLimitPolicy{UserRate: 4000, SessionRate: 2000, StreamWeights: map[uint8]float64{StreamTypeBulk: 3}},
+		map[uint8]StreamPriority{StreamTypeBulk: PriorityHigh})
+	if running.rateLimitNode.limit != 2000 || running.rateLimitNode.parent.limit != 4000 {
+		t.Errorf("Expected the reloaded limits, got session %v and user %v", running.rateLimitNode.limit, running.rateLimitNode.parent.limit)
+	}
+	if bulk.weight != 3 {
+		t.Errorf("Expected the reloaded bulk weight, got %v", bulk.weight)
+	}
+	if _, priorities := running.policy(); priorities[StreamTypeBulk] != PriorityHigh {
+		t.Errorf("Expected the reloaded priorities, got %v", priorities)
+	}
+
+	// Sessions started later use it instead of the one they were given
+	later := newSession(&MockQUICConnection{}, "s2")
+	later.startRateLimiting(config, "bob")
+	defer later.stopRateLimiting()
+	if later.rateLimitNode.limit != 2000 {
+		t.Errorf("Expected a new session to start with the reloaded limit, got %v", later.rateLimitNode.limit)
+	}
+}
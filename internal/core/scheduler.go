@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// schedulerTurnTimeout is how long a write may hold the send turn. A
+	// write blocked by the flow control of its stream lets the others go
+	// after it.
+	schedulerTurnTimeout = 100 * time.Millisecond
+	// schedulerQuantum is the number of bytes a flow of weight 1 may write
+	// per turn.
+	schedulerQuantum = 16 * 1024
+	// minSchedulerQuantum is the smallest number of bytes a flow may write
+	// per turn.
+	minSchedulerQuantum = 1024
+)
+
+// StreamPriority is the priority of a stream's writes. Writes of a higher
+// priority are sent before any write of a lower priority.
+type StreamPriority uint8
+
+// Stream priorities.
+const (
+	// PriorityDefault uses the priority of the stream type.
+	PriorityDefault StreamPriority = iota
+	// PriorityLow is for background transfers.
+	PriorityLow
+	// PriorityNormal is the default for bulk streams.
+	PriorityNormal
+	// PriorityHigh is the default for interactive and telemetry streams.
+	PriorityHigh
+)
+
+// defaultStreamPriorities are the priorities of the stream types.
+var defaultStreamPriorities = map[uint8]StreamPriority{
+	StreamTypeInteractive: PriorityHigh,
+	StreamTypeBulk:        PriorityNormal,
+	StreamTypeTelemetry:   PriorityHigh,
+}
+
+// ParseStreamPriority parses a priority name (low, normal, high).
+func ParseStreamPriority(name string) (StreamPriority, error) {
+	switch name {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityDefault, fmt.Errorf("unknown stream priority %q", name)
+	}
+}
+
+// streamPriority returns the priority of a stream of the given type. An
+// explicit priority wins over the configured and the default priorities of
+// the type.
+func streamPriority(streamType uint8, priority StreamPriority, priorities map[uint8]StreamPriority) StreamPriority {
+	if priority != PriorityDefault {
+		return priority
+	}
+	if priority, ok := priorities[streamType]; ok && priority != PriorityDefault {
+		return priority
+	}
+	if priority, ok := defaultStreamPriorities[streamType]; ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// SendScheduler orders the writes of a session's streams. One write holds
+// the send turn at a time, so when the token bucket or the connection is
+// saturated the next turn goes to the waiting write of the highest
+// priority. Streams of equal priority take turns in the order of their
+// finish tags (weighted fair queuing by bytes), and write up to a quantum
+// proportional to their weight per turn, so they share the bytes sent in
+// proportion to their weights. It is safe for concurrent use.
+type SendScheduler struct {
+	// busy is true while a write holds the turn.
+	busy bool
+	// waiting holds the writes waiting for the turn.
+	waiting []*sendRequest
+	// virtualTime is the finish tag of the last write given the turn.
+	virtualTime float64
+	// mutex protects the fields above and the finish tags of the flows.
+	mutex sync.Mutex
+}
+
+// SchedulerFlow is a stream's handle on a SendScheduler.
+type SchedulerFlow struct {
+	// scheduler is the scheduler the flow belongs to.
+	scheduler *SendScheduler
+	// priority is the priority of the flow's writes.
+	priority StreamPriority
+	// weight is the flow's share among flows of equal priority.
+	weight float64
+	// finish is the finish tag of the flow's last write.
+	finish float64
+}
+
+// sendRequest is a write waiting for the turn.
+type sendRequest struct {
+	// flow is the flow of the write.
+	flow *SchedulerFlow
+	// tag is the virtual time the write finishes at. Lower tags go first.
+	tag float64
+	// ready is closed when the write gets the turn.
+	ready chan struct{}
+}
+
+// NewSendScheduler creates a new SendScheduler.
+func NewSendScheduler() *SendScheduler {
+	return &SendScheduler{}
+}
+
+// NewFlow creates a flow with the given priority and weight.
+func (s *SendScheduler) NewFlow(priority StreamPriority, weight float64) *SchedulerFlow {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &SchedulerFlow{
+		scheduler: s,
+		priority:  priority,
+		weight:    weight,
+	}
+}
+
+// Priority returns the priority of the flow.
+func (f *SchedulerFlow) Priority() StreamPriority {
+	return f.priority
+}
+
+// Quantum returns the number of bytes the flow may write per turn.
+func (f *SchedulerFlow) Quantum() int {
+	quantum := int(schedulerQuantum * f.weight)
+	if quantum < minSchedulerQuantum {
+		return minSchedulerQuantum
+	}
+	return quantum
+}
+
+// Acquire blocks until the flow holds the send turn for a write of the
+// given size, or until ctx is done. The returned function gives the turn
+// back; it may be called more than once. The turn is given back anyway
+// after schedulerTurnTimeout.
+func (f *SchedulerFlow) Acquire(ctx context.Context, bytes int) (func(), error) {
+	s := f.scheduler
+	s.mutex.Lock()
+	start := f.finish
+	if s.virtualTime > start {
+		start = s.virtualTime
+	}
+	request := &sendRequest{
+		flow:  f,
+		tag:   start + float64(bytes)/f.weight,
+		ready: make(chan struct{}),
+	}
+	f.finish = request.tag
+	if !s.busy {
+		s.busy = true
+		s.virtualTime = request.tag
+		s.mutex.Unlock()
+		return s.turn(), nil
+	}
+	s.waiting = append(s.waiting, request)
+	s.mutex.Unlock()
+
+	select {
+	case <-request.ready:
+		return s.turn(), nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		for i, waiting := range s.waiting {
+			if waiting == request {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				s.mutex.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		s.mutex.Unlock()
+
+		// The turn was given to the request while it was cancelled
+		s.turn()()
+		return nil, ctx.Err()
+	}
+}
+
+// turn returns the function that gives back the turn the caller holds,
+// which is also called once the turn has been held too long.
+func (s *SendScheduler) turn() func() {
+	var once sync.Once
+	release := func() {
+		once.Do(s.next)
+	}
+	timer := time.AfterFunc(schedulerTurnTimeout, release)
+	return func() {
+		timer.Stop()
+		release()
+	}
+}
+
+// next gives the turn to the waiting write of the highest priority with
+// the lowest finish tag, or frees it if nothing is waiting.
+func (s *SendScheduler) next() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	best := -1
+	for i, request := range s.waiting {
+		if best < 0 {
+			best = i
+			continue
+		}
+		current := s.waiting[best]
+		if request.flow.priority > current.flow.priority ||
+			(request.flow.priority == current.flow.priority && request.tag < current.tag) {
+			best = i
+		}
+	}
+	if best < 0 {
+		s.busy = false
+		return
+	}
+
+	request := s.waiting[best]
+	s.waiting = append(s.waiting[:best], s.waiting[best+1:]...)
+	if request.tag > s.virtualTime {
+		s.virtualTime = request.tag
+	}
+	close(request.ready)
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendSchedulerPriority(t *testing.T) {
+	scheduler := NewSendScheduler()
+	bulk := scheduler.NewFlow(PriorityNormal, 1)
+	interactive := scheduler.NewFlow(PriorityHigh, 1)
+
+	release, err := bulk.Acquire(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("Failed to acquire the turn: %v", err)
+	}
+
+	// Bulk queues first, but interactive gets the turn first
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	acquire := func(flow *SchedulerFlow, name string) {
+		defer wg.Done()
+		next, err := flow.Acquire(context.Background(), 1000)
+		if err != nil {
+			t.Errorf("Failed to acquire the turn: %v", err)
+			return
+		}
+		order <- name
+		next()
+	}
+	wg.Add(2)
+	go acquire(bulk, "bulk")
+	time.Sleep(20 * time.Millisecond)
+	go acquire(interactive, "interactive")
+	time.Sleep(20 * time.Millisecond)
+
+	release()
+	wg.Wait()
+	if first := <-order; first != "interactive" {
+		t.Errorf("Expected interactive to preempt bulk, got %s first", first)
+	}
+}
+
+func TestSendSchedulerWeights(t *testing.T) {
+	scheduler := NewSendScheduler()
+	heavy := scheduler.NewFlow(PriorityNormal, 3)
+	light := scheduler.NewFlow(PriorityNormal, 1)
+
+	// Both flows always have more to write
+	var mutex sync.Mutex
+	sent := map[*SchedulerFlow]int{}
+	turns := 0
+	var wg sync.WaitGroup
+	run := func(flow *SchedulerFlow) {
+		defer wg.Done()
+		for {
+			release, err := flow.Acquire(context.Background(), flow.Quantum())
+			if err != nil {
+				t.Errorf("Failed to acquire the turn: %v", err)
+				return
+			}
+			mutex.Lock()
+			done := turns >= 400
+			if !done {
+				sent[flow] += flow.Quantum()
+				turns++
+			}
+			mutex.Unlock()
+			time.Sleep(100 * time.Microsecond)
+			release()
+			if done {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go run(heavy)
+	go run(light)
+	wg.Wait()
+
+	if ratio := float64(sent[heavy]) / float64(sent[light]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("Expected bytes in proportion to the weights, sent %d and %d", sent[heavy], sent[light])
+	}
+}
+
+func TestSendSchedulerCancelAndTimeout(t *testing.T) {
+	scheduler := NewSendScheduler()
+	flow := scheduler.NewFlow(PriorityNormal, 1)
+
+	// The turn is never given back, so the waiter gets it after the timeout
+	if _, err := flow.Acquire(context.Background(), 1000); err != nil {
+		t.Fatalf("Failed to acquire the turn: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := flow.Acquire(ctx, 1000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+
+	start := time.Now()
+	release, err := flow.Acquire(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("Failed to acquire the turn: %v", err)
+	}
+	release()
+	release()
+	if elapsed := time.Since(start); elapsed > 2*schedulerTurnTimeout {
+		t.Errorf("Expected the held turn to time out, waited %v", elapsed)
+	}
+}
+
+func TestOpenStreamWithPriority(t *testing.T) {
+	conn := &MockQUICConnection{}
+	session := newSession(conn, "s1")
+	session.startRateLimiting(&Config{StreamPriorities: map[uint8]StreamPriority{StreamTypeInteractive: PriorityLow}}, "")
+
+	stream, err := session.OpenStreamWithPriority(context.Background(), StreamTypeBulk, PriorityHigh)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	scheduled := stream.(*meteredStream).Stream.(*rateLimitedStream)
+	if scheduled.flow.Priority() != PriorityHigh {
+		t.Errorf("Expected the explicit priority, got %d", scheduled.flow.Priority())
+	}
+
+	// The peer learns the priority from the stream type message
+	msg, err := ReadMessage(&MockQUICStream{readData: conn.streams[0].(*MockQUICStream).writeData})
+	if err != nil {
+		t.Fatalf("Failed to read stream type: %v", err)
+	}
+	payload, err := DecodeStreamType(msg.Data)
+	if err != nil || payload.Type != StreamTypeBulk || payload.Priority != PriorityHigh {
+		t.Errorf("Unexpected stream type payload %+v: %v", payload, err)
+	}
+
+	// Without an explicit priority, the configured one applies
+	stream, err = session.OpenInteractiveStream(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if priority := stream.(*meteredStream).Stream.(*rateLimitedStream).flow.Priority(); priority != PriorityLow {
+		t.Errorf("Expected the configured priority, got %d", priority)
+	}
+}
@@ -2,18 +2,70 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
 )
 
+// sessionLog logs session and stream events.
+var sessionLog = ComponentLogger("session")
+
+// rejectionLinger is how long the server waits for a rejected client to
+// read the reason before the connection is closed.
+const rejectionLinger = 2 * time.Second
+
 // Session represents a VANTUN session over a QUIC connection.
 type Session struct {
 	conn quic.Connection
+	// id is the session ID assigned by the server during the handshake.
+	id string
+	// log is the session logger. Its records carry the session ID.
+	log *Logger
+	// user is the identity of the client on server sessions.
+	user string
+	// accountant accounts the traffic of server sessions, or is nil if
+	// traffic is not accounted.
+	accountant *TrafficAccountant
+	// rateLimiter paces the data sent on the session's streams and
+	// datagrams if there is no rate limit tree, or is nil if sending is
+	// not rate limited.
+	rateLimiter *TokenBucket
+	// rateLimitNode is the session's node in the rate limit tree, or nil if
+	// there is no tree. Streams draw from a child per stream type.
+	rateLimitNode *RateLimitNode
+	// rateLimitPolicy holds the stream type weights of the session.
+	rateLimitPolicy RateLimitPolicy
+	// scheduler orders the writes of the session's streams by priority.
+	scheduler *SendScheduler
+	// streamPriorities overrides the default priorities of the stream types.
+	streamPriorities map[uint8]StreamPriority
+	// policyMutex protects rateLimitPolicy and streamPriorities, which
+	// change when the policy is reloaded.
+	policyMutex sync.RWMutex
+	// brutalRates are the rates negotiated in the handshake. Directions
+	// with a rate are paced at it regardless of loss.
+	brutalRates BandwidthRates
+	// brutalLimit caps the session's node in the rate limit tree at the
+	// brutal mode rate on servers, or is zero.
+	brutalLimit float64
+	// brutalController raises brutalLimit to make up for loss, or is nil.
+	brutalController *BrutalRateController
+	// closeOnce ensures the session is only closed once.
+	closeOnce sync.Once
+	// meterOnce ensures the session's byte counters are only kept once.
+	meterOnce sync.Once
 	// telemetryManager manages telemetry collection and reporting for this session.
 	telemetryManager *TelemetryManager
+	// unregisterHistory removes the telemetry history from the debug
+	// endpoint, or is nil if there is no telemetry manager.
+	unregisterHistory func()
 }
 
 // Config holds the configuration for a VANTUN session.
@@ -24,6 +76,40 @@ type Config struct {
 	TLSConfig *tls.Config
 	// IsServer indicates if this session is for a server.
 	IsServer bool
+	// Token is the optional token a client identifies itself with. The
+	// server accounts traffic and enforces quotas per token.
+	Token []byte
+	// RateLimiter paces the data sent on every stream and datagram of the
+	// session, one token per byte. On a server it is shared by all
+	// sessions. It is ignored if RateLimitTree is set. Sending is not rate
+	// limited if both are nil.
+	RateLimiter *TokenBucket
+	// RateLimitTree is the root of the rate limits. Each session gets a
+	// node below its user's node, with a child per stream type.
+	RateLimitTree *RateLimitNode
+	// RateLimitPolicy configures the user, session and stream type levels
+	// of RateLimitTree.
+	RateLimitPolicy RateLimitPolicy
+	// StreamPriorities overrides the priorities of the stream types. By
+	// default interactive and telemetry streams are sent before bulk ones.
+	StreamPriorities map[uint8]StreamPriority
+	// Brutal holds the rates of brutal mode, which paces at a fixed rate
+	// raised to make up for loss instead of lowering the token bucket rate
+	// on loss. QUIC's congestion control still applies below it. A client declares them in the
+	// handshake; a server caps the declared rates at them. Zero rates
+	// disable it.
+	Brutal BandwidthRates
+	// MultipathStreamBuffer is the number of bytes a multipath stream
+	// buffers in each direction. Zero means 1 MB.
+	MultipathStreamBuffer int
+	// HeadOfLineTimeout is how long a multipath stream waits for a missing
+	// chunk before it asks for it again. Zero means 2 seconds.
+	HeadOfLineTimeout time.Duration
+	// FECInterleaveDepth is the number of writes whose shards the FEC
+	// streams a multipath session with an AdaptiveFEC opens interleave, so
+	// a burst of lost packets is spread across several blocks. Values up
+	// to 1 disable interleaving.
+	FECInterleaveDepth int
 }
 
 // NewSession creates a new VANTUN session based on the provided configuration.
@@ -37,38 +123,41 @@ func NewSession(ctx context.Context, config *Config) (*Session, error) {
 }
 
 func newClientSession(ctx context.Context, config *Config) (*Session, error) {
-	conn, err := quic.DialAddr(ctx, config.Address, config.TLSConfig, nil)
+	conn, err := quic.DialAddr(ctx, config.Address, config.TLSConfig, newQUICConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial server: %w", err)
 	}
 
 	// Perform session negotiation handshake on the control stream.
-	if err := performClientHandshake(ctx, conn); err != nil {
+	result, err := performClientHandshake(ctx, conn, config.Token, config.Brutal, nil)
+	if err != nil {
 		conn.CloseWithError(0, "handshake failed")
+		defaultMetrics.HandshakeFailed(handshakeFailureReason(err))
 		return nil, fmt.Errorf("handshake failed: %w", err)
 	}
 
-	Info("Client connected to %s", config.Address)
-	
 	// Create a session with telemetry manager
-	session := &Session{conn: conn}
+	session := newSession(conn, result.sessionID)
+	session.brutalRates = result.brutalRates
+	session.startRateLimiting(config, "")
+	session.log.Info("Client connected to %s", config.Address)
+	defaultMetrics.SessionOpened()
 	
 	// Open a telemetry stream for this session
 	telemetryStream, err := session.OpenTelemetryStream(ctx)
 	if err != nil {
 		// Log the error but don't fail the session creation
-		Warn("Failed to open telemetry stream: %v", err)
+		session.log.Warn("Failed to open telemetry stream: %v", err)
 	} else {
 		// Create and start telemetry manager
-		session.telemetryManager = NewTelemetryManager(conn, telemetryStream, 1*time.Second)
-		session.telemetryManager.Start()
+		session.startTelemetry(telemetryStream)
 	}
 
 	return session, nil
 }
 
 func newServerSession(ctx context.Context, config *Config) (*Session, error) {
-	listener, err := quic.ListenAddr(config.Address, config.TLSConfig, nil)
+	listener, err := quic.ListenAddr(config.Address, config.TLSConfig, newQUICConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on %s: %w", config.Address, err)
 	}
@@ -83,43 +172,50 @@ func newServerSession(ctx context.Context, config *Config) (*Session, error) {
 				if ctx.Err() != nil {
 					break
 				}
-				Error("Failed to accept connection: %v", err)
+				sessionLog.Error("Failed to accept connection: %v", err)
 				continue
 			}
 
 			// Handle each connection in a separate goroutine
 			go func(conn quic.Connection) {
 				// Perform session negotiation handshake on the control stream.
-				if err := performServerHandshake(ctx, conn); err != nil {
+				result, err := performServerHandshake(ctx, conn, config.Brutal, nil)
+				if err != nil {
 					conn.CloseWithError(0, "handshake failed")
-					Error("Handshake failed: %v", err)
+					defaultMetrics.HandshakeFailed(handshakeFailureReason(err))
+					sessionLog.Log(ErrorLevel, "Handshake failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
 					return
 				}
 
-				Info("Server accepted connection from %s", conn.RemoteAddr().String())
-				
 				// Create a session for this connection
-				session := &Session{conn: conn}
+				session := newSession(conn, result.sessionID)
+				session.brutalRates = result.brutalRates
+				session.startRateLimiting(config, result.user)
+				defer session.stopRateLimiting()
+				session.log.Info("Server accepted connection from %s", conn.RemoteAddr().String())
+				defaultMetrics.SessionOpened()
+				defer defaultMetrics.SessionClosed()
+				session.startAccounting(result.user, trafficAccountant.Load())
+				defer session.stopAccounting()
 				
 				// Accept telemetry stream
 				telemetryStream, err := session.AcceptTelemetryStream(ctx)
 				if err != nil {
 					// Log the error but don't fail the session
-					Warn("Failed to accept telemetry stream: %v", err)
+					session.log.Warn("Failed to accept telemetry stream: %v", err)
 				} else {
 					// Create and start telemetry manager
-					session.telemetryManager = NewTelemetryManager(conn, telemetryStream, 1*time.Second)
-					session.telemetryManager.Start()
-					defer session.telemetryManager.Stop()
+					session.startTelemetry(telemetryStream)
+					defer session.stopTelemetry()
 				}
 				
 				// Accept multiple interactive streams in a loop
 				for {
 					stream, err := session.AcceptInteractiveStream(ctx)
 					if err != nil {
-						Error("Failed to accept interactive stream: %v", err)
-						// If the session is closed, break out of the loop
-						if ctx.Err() != nil {
+						session.log.Error("Failed to accept interactive stream: %v", err)
+						// If the server or the connection is closed, break out of the loop
+						if ctx.Err() != nil || conn.Context().Err() != nil {
 							break
 						}
 						// Otherwise, continue accepting streams
@@ -129,20 +225,21 @@ func newServerSession(ctx context.Context, config *Config) (*Session, error) {
 					// Handle each stream in a separate goroutine
 					go func(s quic.Stream) {
 						defer s.Close()
-						Info("Accepted interactive stream, echoing data...")
+						streamLog := session.log.With("stream_id", int64(s.StreamID()))
+						streamLog.Info("Accepted interactive stream, echoing data...")
 						buf := make([]byte, 1024)
 						for {
 							n, err := s.Read(buf)
 							if err != nil {
-								Error("Read error: %v", err)
+								streamLog.Error("Read error: %v", err)
 								break
 							}
 							if _, err := s.Write(buf[:n]); err != nil {
-								Error("Write error: %v", err)
+								streamLog.Error("Write error: %v", err)
 								break
 							}
 						}
-						Info("Finished handling interactive stream")
+						streamLog.Info("Finished handling interactive stream")
 					}(stream)
 				}
 			}(conn)
@@ -151,26 +248,85 @@ func newServerSession(ctx context.Context, config *Config) (*Session, error) {
 
 	// Return a session with a nil conn for server mode
 	// This is a workaround to allow the server to start
-	return &Session{conn: nil}, nil
+	return newSession(nil, ""), nil
+}
+
+// newSession creates a session on a connection with the ID assigned during
+// the handshake.
+func newSession(conn quic.Connection, id string) *Session {
+	log := sessionLog
+	if id != "" {
+		log = log.With("session_id", id)
+	}
+	return &Session{
+		conn:      conn,
+		id:        id,
+		log:       log,
+		scheduler: NewSendScheduler(),
+	}
+}
+
+// newSessionID returns a random session ID.
+func newSessionID() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// handshakeResult holds what was negotiated in the handshake.
+type handshakeResult struct {
+	// sessionID is the session ID assigned by the server. It is empty if
+	// the server does not assign one.
+	sessionID string
+	// user is the identity of the client. It is only set on the server.
+	user string
+	// brutalRates are the negotiated brutal mode rates.
+	brutalRates BandwidthRates
+	// multipathID is the ID of the multipath session the path belongs to.
+	// It is only set on clients that asked for multipath, and is empty if
+	// the server does not support it.
+	multipathID string
+	// multipathSession is the multipath session the connection is a path
+	// of. It is only set on servers that group paths.
+	multipathSession *MultipathSession
+}
+
+// multipathRequest is what a multipath client presents in the handshake.
+type multipathRequest struct {
+	// id is the ID of the multipath session the path joins, or empty on
+	// the first path.
+	id string
 }
 
 // performClientHandshake performs the client side of the session negotiation handshake.
-func performClientHandshake(ctx context.Context, conn quic.Connection) error {
+// The token, if any, identifies the client to the server.
+// The client declares its brutal mode rates, which the server may lower.
+// A multipath client passes multipath to get or present the multipath
+// session ID; it is nil otherwise.
+func performClientHandshake(ctx context.Context, conn quic.Connection, token []byte, brutal BandwidthRates, multipath *multipathRequest) (handshakeResult, error) {
 	stream, err := conn.OpenStreamSync(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to open control stream: %w", err)
+		return handshakeResult{}, newHandshakeError("control_stream", fmt.Errorf("failed to open control stream: %w", err))
 	}
 	defer stream.Close()
 
 	// Send SessionInit message
 	initPayload := &SessionInitPayload{
 		Version:           1,
-		Token:             nil, // TODO: Add token support
+		Token:             token,
 		SupportedFeatures: []string{}, // TODO: Add feature support
+		UpRate:            brutal.Up,
+		DownRate:          brutal.Down,
+	}
+	if multipath != nil {
+		initPayload.SupportedFeatures = append(initPayload.SupportedFeatures, FeatureMultipath)
+		initPayload.MultipathSessionID = multipath.id
 	}
 	initData, err := EncodeSessionInit(initPayload)
 	if err != nil {
-		return fmt.Errorf("failed to encode SessionInit: %w", err)
+		return handshakeResult{}, newHandshakeError("encode", fmt.Errorf("failed to encode SessionInit: %w", err))
 	}
 
 	msg := &Message{
@@ -179,66 +335,132 @@ func performClientHandshake(ctx context.Context, conn quic.Connection) error {
 	}
 	
 	if err := WriteMessage(stream, msg); err != nil {
-		return fmt.Errorf("failed to send SessionInit: %w", err)
+		return handshakeResult{}, newHandshakeError("write", fmt.Errorf("failed to send SessionInit: %w", err))
 	}
 
 	// Receive SessionAccept message
 	receivedMsg, err := ReadMessage(stream)
 	if err != nil {
-		return fmt.Errorf("failed to read SessionAccept: %w", err)
+		return handshakeResult{}, newHandshakeError("read", fmt.Errorf("failed to read SessionAccept: %w", err))
 	}
 
 	if receivedMsg.Type != SessionAccept {
-		return fmt.Errorf("expected SessionAccept, got %d", receivedMsg.Type)
+		return handshakeResult{}, newHandshakeError("unexpected_message", fmt.Errorf("expected SessionAccept, got %d", receivedMsg.Type))
 	}
 
 	acceptPayload, err := DecodeSessionAccept(receivedMsg.Data)
 	if err != nil {
-		return fmt.Errorf("failed to decode SessionAccept payload: %w", err)
+		return handshakeResult{}, newHandshakeError("decode", fmt.Errorf("failed to decode SessionAccept payload: %w", err))
 	}
 
 	if !acceptPayload.Accepted {
-		return fmt.Errorf("server rejected session: %s", acceptPayload.Reason)
+		return handshakeResult{}, newHandshakeError("rejected", fmt.Errorf("server rejected session: %s", acceptPayload.Reason))
 	}
 
-	Info("Session handshake completed successfully")
-	return nil
+	rates := BandwidthRates{Up: acceptPayload.UpRate, Down: acceptPayload.DownRate}
+	sessionLog.Log(InfoLevel, "Session handshake completed successfully", "session_id", acceptPayload.SessionID,
+		"brutal_up", rates.Up, "brutal_down", rates.Down)
+	return handshakeResult{
+		sessionID:   acceptPayload.SessionID,
+		brutalRates: rates,
+		multipathID: acceptPayload.MultipathSessionID,
+	}, nil
 }
 
 // performServerHandshake performs the server side of the session negotiation handshake.
-func performServerHandshake(ctx context.Context, conn quic.Connection) error {
+// It assigns the session ID, which is sent to the client, and identifies the
+// client. Clients over quota are rejected if a traffic accountant is set.
+// The brutal mode rates the client declares are capped at limits.
+// If multipath is not nil, the connection is grouped into a multipath
+// session: a new one, whose ID is issued to clients supporting
+// FeatureMultipath, or the one whose ID the client presents.
+func performServerHandshake(ctx context.Context, conn quic.Connection, limits BandwidthRates, multipath *multipathRegistry) (handshakeResult, error) {
 	stream, err := conn.AcceptStream(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to accept control stream: %w", err)
+		return handshakeResult{}, newHandshakeError("control_stream", fmt.Errorf("failed to accept control stream: %w", err))
 	}
 	defer stream.Close()
 
 	// Receive SessionInit message
 	receivedMsg, err := ReadMessage(stream)
 	if err != nil {
-		return fmt.Errorf("failed to read SessionInit: %w", err)
+		return handshakeResult{}, newHandshakeError("read", fmt.Errorf("failed to read SessionInit: %w", err))
 	}
 
 	if receivedMsg.Type != SessionInit {
-		return fmt.Errorf("expected SessionInit, got %d", receivedMsg.Type)
+		return handshakeResult{}, newHandshakeError("unexpected_message", fmt.Errorf("expected SessionInit, got %d", receivedMsg.Type))
 	}
 
 	initPayload, err := DecodeSessionInit(receivedMsg.Data)
 	if err != nil {
-		return fmt.Errorf("failed to decode SessionInit payload: %w", err)
+		return handshakeResult{}, newHandshakeError("decode", fmt.Errorf("failed to decode SessionInit payload: %w", err))
 	}
 
-	Info("Received SessionInit: Version=%d, Token=%v, Features=%v", initPayload.Version, initPayload.Token, initPayload.SupportedFeatures)
+	user := clientIdentity(initPayload.Token, conn.RemoteAddr())
+	rates := negotiateBrutalRates(BandwidthRates{Up: initPayload.UpRate, Down: initPayload.DownRate}, limits)
+	
+	// Additional paths of a multipath session keep its session ID
+	var rejection error
+	var multipathSession *MultipathSession
+	var sessionID string
+	if initPayload.MultipathSessionID != "" {
+		if multipath == nil {
+			rejection = newHandshakeError("multipath", fmt.Errorf("multipath is not supported"))
+		} else if multipathSession, err = multipath.join(initPayload.MultipathSessionID); err != nil {
+			rejection = newHandshakeError("multipath", err)
+		} else {
+			// The path's traffic counts for the user who created the session
+			sessionID = multipathSession.sessionID
+			user = multipathSession.user
+		}
+	}
+	if sessionID == "" {
+		if sessionID, err = newSessionID(); err != nil {
+			return handshakeResult{}, newHandshakeError("session_id", err)
+		}
+	}
+	log := sessionLog.With("session_id", sessionID)
+	log = log.With("user", user)
+	log.Info("Received SessionInit: Version=%d, Features=%v", initPayload.Version, initPayload.SupportedFeatures)
+
+	if rejection == nil {
+		if accountant := trafficAccountant.Load(); accountant != nil {
+			if err := accountant.Admit(user); err != nil {
+				rejection = newHandshakeError("quota", err)
+			}
+		}
+	}
 
 	// Send SessionAccept message
 	acceptPayload := &SessionAcceptPayload{
 		Accepted:       true,
 		Reason:         "",
-		ServerFeatures: []string{}, // TODO: Add feature support
+		ServerFeatures: []string{},
+		SessionID:      sessionID,
+		UpRate:         rates.Up,
+		DownRate:       rates.Down,
+	}
+	created := false
+	if rejection != nil {
+		acceptPayload = &SessionAcceptPayload{
+			Accepted: false,
+			Reason:   rejection.Error(),
+		}
+	} else if multipath != nil {
+		acceptPayload.ServerFeatures = append(acceptPayload.ServerFeatures, FeatureMultipath)
+		if multipathSession == nil {
+			if multipathSession, err = multipath.create(sessionID, user); err != nil {
+				return handshakeResult{}, newHandshakeError("multipath", err)
+			}
+			created = true
+		}
+		if hasFeature(initPayload.SupportedFeatures, FeatureMultipath) {
+			acceptPayload.MultipathSessionID = multipathSession.id
+		}
 	}
 	acceptData, err := EncodeSessionAccept(acceptPayload)
 	if err != nil {
-		return fmt.Errorf("failed to encode SessionAccept: %w", err)
+		return handshakeResult{}, newHandshakeError("encode", fmt.Errorf("failed to encode SessionAccept: %w", err))
 	}
 
 	responseMsg := &Message{
@@ -247,28 +469,472 @@ func performServerHandshake(ctx context.Context, conn quic.Connection) error {
 	}
 	
 	if err := WriteMessage(stream, responseMsg); err != nil {
-		return fmt.Errorf("failed to send SessionAccept: %w", err)
+		if created {
+			multipath.remove(multipathSession)
+		}
+		return handshakeResult{}, newHandshakeError("write", fmt.Errorf("failed to send SessionAccept: %w", err))
+	}
+
+	if rejection != nil {
+		log.Warn("Rejected session: %v", rejection)
+		// Wait for the client to close its side after reading the reason,
+		// since the connection is closed as soon as the handshake fails
+		stream.SetReadDeadline(time.Now().Add(rejectionLinger))
+		io.Copy(io.Discard, stream)
+		return handshakeResult{}, rejection
+	}
+
+	log.Log(InfoLevel, "Session handshake completed successfully", "brutal_up", rates.Up, "brutal_down", rates.Down)
+	return handshakeResult{
+		sessionID:        sessionID,
+		user:             user,
+		brutalRates:      rates,
+		multipathSession: multipathSession,
+	}, nil
+}
+
+// hasFeature reports whether features lists feature.
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
 	}
+	return false
+}
 
-	Info("Session handshake completed successfully")
+// handshakeError is a handshake failure tagged with the reason reported in
+// the handshake failure metrics.
+type handshakeError struct {
+	// reason is a short machine-readable failure reason.
+	reason string
+	// err is the underlying error.
+	err error
+}
+
+// newHandshakeError creates a handshake error with the given reason.
+func newHandshakeError(reason string, err error) error {
+	return &handshakeError{reason: reason, err: err}
+}
+
+// Error returns the underlying error message.
+func (e *handshakeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+// handshakeFailureReason returns the metrics reason of a handshake error.
+func handshakeFailureReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var hsErr *handshakeError
+	if errors.As(err, &hsErr) {
+		return hsErr.reason
+	}
+	return "unknown"
+}
+
+// startTelemetry starts the telemetry manager on the given stream and
+// makes its history visible on the debug endpoint.
+func (s *Session) startTelemetry(stream quic.Stream) {
+	s.telemetryManager = NewTelemetryManager(s.conn, stream, 1*time.Second)
+	s.telemetryManager.SetSessionID(s.id)
+	if s.brutalController != nil {
+		s.telemetryManager.OnSample(s.compensateBrutalLoss)
+	}
+	s.telemetryManager.Start()
+
+	// Sessions of servers that do not assign IDs are known by their address
+	id := s.id
+	if id == "" {
+		id = s.conn.RemoteAddr().String()
+	}
+	s.unregisterHistory = registerTelemetryHistory("session", id, s.telemetryManager.History())
+}
+
+// startAccounting accounts the traffic of a server session for a user. It
+// does nothing if accountant is nil.
+func (s *Session) startAccounting(user string, accountant *TrafficAccountant) {
+	s.user = user
+	s.accountant = accountant
+}
+
+// stopAccounting stops accounting the traffic of the session and logs its
+// totals.
+func (s *Session) stopAccounting() {
+	if s.accountant == nil {
+		return
+	}
+	usage := s.accountant.EndSession(s.id)
+	s.Logger().Log(InfoLevel, "Session traffic", "user", s.user, "up", usage.Up, "down", usage.Down)
+}
+
+// startRateLimiting sets up the pacing and scheduling of the data sent on
+// the session. With a rate limit tree, the session gets a node below the
+// user's node, or below the root if user is empty.
+func (s *Session) startRateLimiting(config *Config, user string) {
+	liveRateLimits.add(s, config)
+	
+	// In brutal mode the session paces at the negotiated rate
+	tree := config.RateLimitTree
+	if rate := s.brutalSendRate(config.IsServer); rate > 0 {
+		switch {
+		case config.IsServer:
+			// A server session's node is capped at the rate, so the
+			// server's and the user's limits still hold
+			s.brutalLimit = rate
+			s.brutalController = NewBrutalRateController(rate)
+			if tree == nil {
+				tree = brutalRateLimitTree(config.RateLimiter)
+			}
+		case config.RateLimiter == nil:
+			s.rateLimiter = NewTokenBucket(rate, rateLimitCapacity(rate))
+			return
+		default:
+			// A client's bucket is its own, so it is set to the rate
+			config.RateLimiter.SetRate(rate)
+		}
+	}
+
+	if tree == nil {
+		s.rateLimiter = config.RateLimiter
+		return
+	}
+
+	// The nodes are created under the lock, so a reloaded policy is either
+	// used to create them or applied to them
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+	
+	name := s.id
+	if name == "" {
+		name = s.conn.RemoteAddr().String()
+	}
+	s.rateLimitNode = sessionRateLimitNode(tree, user, name, s.rateLimitPolicy, s.sessionLimit(s.rateLimitPolicy))
+}
+
+// sessionRateLimitNode creates the node of a session in the rate limit
+// tree: below the user's node, or below the root if user is empty.
+func sessionRateLimitNode(tree *RateLimitNode, user, name string, policy RateLimitPolicy, limit float64) *RateLimitNode {
+	parent := tree
+	if user != "" {
+		parent = parent.Child(user, 1, policy.UserRate)
+	}
+	return parent.Child(name, 1, limit)
+}
+
+// applyNodePolicy updates the limit of a session's node, the limit of its
+// user's node and the weights of its stream types to a reloaded policy.
+func applyNodePolicy(node *RateLimitNode, limit float64, policy RateLimitPolicy) {
+	node.setLimit(1, limit)
+	if user := node.parent; user.parent != nil {
+		user.setLimit(1, policy.UserRate)
+	}
+	for _, streamType := range []uint8{StreamTypeInteractive, StreamTypeBulk, StreamTypeTelemetry} {
+		node.setChildLimit(streamTypeName(streamType), policy.streamWeight(streamType), 0)
+	}
+}
+
+// brutalRateLimitTrees holds the rate limit trees of the server brutal
+// sessions of configurations without a tree, by the bucket at their root.
+// The sessions drawing from one bucket share a tree, so the user limits
+// and the bucket's rate hold across them.
+var brutalRateLimitTrees = struct {
+	trees map[*TokenBucket]*RateLimitNode
+	mutex sync.Mutex
+}{trees: make(map[*TokenBucket]*RateLimitNode)}
+
+// brutalRateLimitTree returns the shared rate limit tree of the server
+// brutal sessions drawing from bucket, creating it if needed.
+func brutalRateLimitTree(bucket *TokenBucket) *RateLimitNode {
+	brutalRateLimitTrees.mutex.Lock()
+	defer brutalRateLimitTrees.mutex.Unlock()
+
+	tree, ok := brutalRateLimitTrees.trees[bucket]
+	if !ok {
+		tree = NewRateLimitTree(bucket)
+		brutalRateLimitTrees.trees[bucket] = tree
+	}
+	return tree
+}
+
+// sessionLimit returns the limit of the session's node under policy: the
+// policy's session rate, lowered to the brutal mode rate if there is one.
+func (s *Session) sessionLimit(policy RateLimitPolicy) float64 {
+	return brutalSessionLimit(s.brutalLimit, policy)
+}
+
+// brutalSessionLimit returns the policy's session rate, lowered to
+// brutalLimit if it is not zero.
+func brutalSessionLimit(brutalLimit float64, policy RateLimitPolicy) float64 {
+	if brutalLimit > 0 && (policy.SessionRate == 0 || brutalLimit < policy.SessionRate) {
+		return brutalLimit
+	}
+	return policy.SessionRate
+}
+
+// compensateBrutalLoss raises the brutal mode cap of a server session's
+// node to make up for the loss of a telemetry sample, or of the peer's
+// latest report if the peer observes more.
+func (s *Session) compensateBrutalLoss(data *TelemetryData) {
+	if peer := s.PeerTelemetry(); peer != nil && peer.ReceiveLoss > data.Loss {
+		adjusted := *data
+		adjusted.Loss = peer.ReceiveLoss
+		data = &adjusted
+	}
+
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+	limit, reason := s.brutalController.Update(s.brutalLimit, data)
+	if reason == "" {
+		return
+	}
+	s.brutalLimit = limit
+	if s.rateLimitNode != nil {
+		s.rateLimitNode.setLimit(1, s.sessionLimit(s.rateLimitPolicy))
+	}
+}
+
+// liveRateLimits holds the sessions that are rate limited, so that a
+// reloaded policy is applied to them.
+var liveRateLimits = &rateLimitRegistry{sessions: make(map[rateLimitedSession]struct{})}
+
+// rateLimitedSession is a session whose rate limit policy is replaced when
+// it is reloaded.
+type rateLimitedSession interface {
+	// applyRateLimitPolicy sets the policy and stream priorities of the
+	// session.
+	applyRateLimitPolicy(policy RateLimitPolicy, priorities map[uint8]StreamPriority)
+}
+
+// rateLimitRegistry tracks rate limited sessions and the policy set with
+// SetRateLimitPolicy.
+type rateLimitRegistry struct {
+	// sessions holds the rate limited sessions.
+	sessions map[rateLimitedSession]struct{}
+	// policy and priorities replace those of the Config once reloaded is
+	// set.
+	policy     RateLimitPolicy
+	priorities map[uint8]StreamPriority
+	reloaded   bool
+	// mutex protects the registry.
+	mutex sync.Mutex
+}
+
+// add registers a session and sets the policy and stream priorities it
+// starts with: those of config, unless they were reloaded since.
+func (r *rateLimitRegistry) add(s rateLimitedSession, config *Config) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.sessions[s] = struct{}{}
+	if r.reloaded {
+		s.applyRateLimitPolicy(r.policy, r.priorities)
+	} else {
+		s.applyRateLimitPolicy(config.RateLimitPolicy, config.StreamPriorities)
+	}
+}
+
+// remove unregisters a session.
+func (r *rateLimitRegistry) remove(s rateLimitedSession) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, s)
+}
+
+// SetRateLimitPolicy replaces the rate limit policy and stream priorities
+// of the running sessions and of the sessions started from now on. The
+// user and session limits and the stream type weights of the rate limit
+// tree take effect at its next rebalance; the priorities apply to streams
+// opened from now on.
+func SetRateLimitPolicy(policy RateLimitPolicy, priorities map[uint8]StreamPriority) {
+	liveRateLimits.mutex.Lock()
+	defer liveRateLimits.mutex.Unlock()
+
+	liveRateLimits.policy = policy
+	liveRateLimits.priorities = priorities
+	liveRateLimits.reloaded = true
+	for s := range liveRateLimits.sessions {
+		s.applyRateLimitPolicy(policy, priorities)
+	}
+}
+
+// applyRateLimitPolicy updates the policy and stream priorities of the
+// session, and the limits of its nodes in the rate limit tree.
+func (s *Session) applyRateLimitPolicy(policy RateLimitPolicy, priorities map[uint8]StreamPriority) {
+	s.policyMutex.Lock()
+	defer s.policyMutex.Unlock()
+
+	s.rateLimitPolicy = policy
+	s.streamPriorities = priorities
+	if s.rateLimitNode != nil {
+		applyNodePolicy(s.rateLimitNode, s.sessionLimit(policy), policy)
+	}
+}
+
+// policy returns the rate limit policy and stream priorities of the
+// session.
+func (s *Session) policy() (RateLimitPolicy, map[uint8]StreamPriority) {
+	s.policyMutex.RLock()
+	defer s.policyMutex.RUnlock()
+	return s.rateLimitPolicy, s.streamPriorities
+}
+
+// brutalSendRate returns the negotiated brutal mode rate the session sends
+// at (bytes per second), or zero if its sending is left to rate control.
+func (s *Session) brutalSendRate(isServer bool) float64 {
+	if isServer {
+		return float64(s.brutalRates.Down)
+	}
+	return float64(s.brutalRates.Up)
+}
+
+// BrutalRates returns the brutal mode rates negotiated in the handshake.
+// Zero rates mean the direction is left to rate control.
+func (s *Session) BrutalRates() BandwidthRates {
+	return s.brutalRates
+}
+
+// stopRateLimiting removes the session from the rate limit tree.
+func (s *Session) stopRateLimiting() {
+	liveRateLimits.remove(s)
+	if s.rateLimitNode != nil {
+		s.rateLimitNode.Remove()
+	}
+}
+
+// streamLimiter returns the rate limiter of a stream type, or nil if
+// sending is not rate limited.
+func (s *Session) streamLimiter(streamType uint8) RateLimiter {
+	if s.rateLimitNode != nil {
+		policy, _ := s.policy()
+		return s.rateLimitNode.Child(streamTypeName(streamType), policy.streamWeight(streamType), 0)
+	}
+	if s.rateLimiter != nil {
+		return s.rateLimiter
+	}
 	return nil
 }
 
-// Close closes the underlying QUIC connection and stops the telemetry manager.
-func (s *Session) Close() error {
-	// Stop the telemetry manager if it exists
+// stopTelemetry stops the telemetry manager if it exists.
+func (s *Session) stopTelemetry() {
 	if s.telemetryManager != nil {
 		s.telemetryManager.Stop()
 	}
+	if s.unregisterHistory != nil {
+		s.unregisterHistory()
+	}
+}
+
+// Close closes the underlying QUIC connection and stops the telemetry manager.
+func (s *Session) Close() error {
+	// Stop the telemetry manager if it exists
+	s.stopTelemetry()
+	s.stopRateLimiting()
 	
 	// Close the connection if it exists
+	var err error
 	if s.conn != nil {
-		return s.conn.CloseWithError(0, "")
+		s.closeOnce.Do(func() {
+			defaultMetrics.SessionClosed()
+			err = s.conn.CloseWithError(0, "")
+		})
 	}
 	
+	return err
+}
+
+// PeerTelemetry returns the latest telemetry report received from the peer,
+// or nil if there is none yet or the session has no telemetry stream.
+func (s *Session) PeerTelemetry() *TelemetryData {
+	if s.telemetryManager == nil {
+		return nil
+	}
+	return s.telemetryManager.PeerTelemetry()
+}
+
+// TelemetryHistory returns the telemetry samples collected at or after
+// since, oldest first. The history covers the last ten minutes at most.
+// It returns nil if the session has no telemetry stream.
+func (s *Session) TelemetryHistory(since time.Time) []TelemetryData {
+	if s.telemetryManager == nil {
+		return nil
+	}
+	return s.telemetryManager.History().Since(since)
+}
+
+// ID returns the session ID assigned by the server during the handshake. It
+// is empty if the server did not assign one.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Logger returns the session logger, whose records carry the session ID.
+func (s *Session) Logger() *Logger {
+	if s.log == nil {
+		return sessionLog
+	}
+	return s.log
+}
+
+// Context returns the context of the connection carrying the session
+// logger, or a background context carrying it if there is no connection.
+func (s *Session) Context() context.Context {
+	ctx := context.Background()
+	if s.conn != nil {
+		ctx = s.conn.Context()
+	}
+	return ContextWithLogger(ctx, s.Logger())
+}
+
+// SendDatagram sends an unreliable datagram on the session. Its bytes are
+// rate limited and accounted like stream data, and it blocks until ctx is
+// done if tokens are not available.
+func (s *Session) SendDatagram(ctx context.Context, p []byte) error {
+	if s.accountant != nil {
+		if err := s.accountant.throttle(ctx, s.user, len(p)); err != nil {
+			return err
+		}
+	}
+	// Datagrams draw from the session's node, next to the streams
+	var limiter RateLimiter
+	if s.rateLimitNode != nil {
+		limiter = s.rateLimitNode
+	} else if s.rateLimiter != nil {
+		limiter = s.rateLimiter
+	}
+	var err error
+	if limiter != nil {
+		err = NewRateLimitedDatagramSender(s.conn, limiter).SendDatagram(ctx, p)
+	} else {
+		err = s.conn.SendDatagram(p)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send datagram: %w", err)
+	}
+	if s.accountant != nil {
+		s.accountant.Add(s.user, s.id, 0, uint64(len(p)))
+	}
 	return nil
 }
 
+// ReceiveDatagram receives an unreliable datagram from the session.
+func (s *Session) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	p, err := s.conn.ReceiveDatagram(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive datagram: %w", err)
+	}
+	if s.accountant != nil {
+		s.accountant.Add(s.user, s.id, uint64(len(p)), 0)
+	}
+	return p, nil
+}
+
 // Connection returns the underlying QUIC connection.
 func (s *Session) Connection() quic.Connection {
 	return s.conn
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandshakeAssignsSessionID(t *testing.T) {
+	client, server := newTestConnectionPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type result struct {
+		handshakeResult
+		err error
+	}
+	serverResult := make(chan result, 1)
+	go func() {
+		r, err := performServerHandshake(ctx, server, BandwidthRates{}, nil)
+		serverResult <- result{r, err}
+	}()
+
+	clientResult, err := performClientHandshake(ctx, client, nil, BandwidthRates{}, nil)
+	if err != nil {
+		t.Fatalf("Client handshake failed: %v", err)
+	}
+	res := <-serverResult
+	if res.err != nil {
+		t.Fatalf("Server handshake failed: %v", res.err)
+	}
+
+	clientID := clientResult.sessionID
+	if len(clientID) != 16 {
+		t.Errorf("Expected a 16 character session ID, got %q", clientID)
+	}
+	if clientID != res.sessionID {
+		t.Errorf("Expected both sides to agree on the session ID, got %q and %q", clientID, res.sessionID)
+	}
+
+	session := newSession(client, clientID)
+	if session.ID() != clientID {
+		t.Errorf("Expected session ID %q, got %q", clientID, session.ID())
+	}
+	if LoggerFromContext(session.Context()) != session.Logger() {
+		t.Error("Expected the session context to carry the session logger")
+	}
+}
+
+func TestSessionLogsCarryIDs(t *testing.T) {
+	old := defaultLogConfig.Load()
+	defer func() {
+		if config := defaultLogConfig.Swap(old); config != nil && config.closer != nil {
+			config.closer.Close()
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "vantun.log")
+	if err := ConfigureLogger(LogOptions{Level: "debug", Format: "json", File: path}); err != nil {
+		t.Fatalf("Failed to configure logger: %v", err)
+	}
+
+	session := newSession(&MockQUICConnection{}, "0123456789abcdef")
+	session.trackStream(&MockQUICStream{streamID: 4}, StreamTypeBulk, PriorityDefault)
+
+	records := readJSONLogs(t, path)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %v", records)
+	}
+	record := records[0]
+	if record["msg"] != "Stream opened" || record["session_id"] != "0123456789abcdef" || record["stream_id"] != 4.0 {
+		t.Errorf("Unexpected stream record %v", record)
+	}
+
+	data := &TelemetryData{SessionID: session.ID()}
+	fields := (&TelemetryReportedEvent{Data: data}).Fields()
+	if last := fields[len(fields)-1]; last.Key != "session_id" || last.Value != session.ID() {
+		t.Errorf("Expected the telemetry event to carry the session ID, got %v", last)
+	}
+}
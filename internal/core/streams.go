@@ -16,35 +16,7 @@ const (
 
 // OpenInteractiveStream opens a new interactive stream.
 func (s *Session) OpenInteractiveStream(ctx context.Context) (quic.Stream, error) {
-	// Stream type 1 is for interactive data.
-	stream, err := s.conn.OpenStreamSync(ctx)
-	if err != nil {
-		Error("Failed to open interactive stream: %v", err)
-		return nil, err
-	}
-	
-	// Send stream type identifier on the stream.
-	payload := &StreamTypePayload{
-		Type: StreamTypeInteractive,
-	}
-	data, err := EncodeStreamType(payload)
-	if err != nil {
-		stream.Close()
-		Error("Failed to encode stream type: %v", err)
-		return nil, fmt.Errorf("failed to encode stream type: %w", err)
-	}
-	
-	msg := &Message{
-		Type: StreamType,
-		Data: data,
-	}
-	
-	if err := WriteMessage(stream, msg); err != nil {
-		stream.Close()
-		return nil, fmt.Errorf("failed to send stream type: %w", err)
-	}
-	
-	return stream, nil
+	return s.OpenStreamWithPriority(ctx, StreamTypeInteractive, PriorityDefault)
 }
 
 // AcceptInteractiveStream accepts a new interactive stream.
@@ -77,44 +49,54 @@ func (s *Session) AcceptInteractiveStream(ctx context.Context) (quic.Stream, err
 		return nil, fmt.Errorf("expected interactive stream type, got %d", payload.Type)
 	}
 	
-	return stream, nil
+	return s.trackStream(stream, StreamTypeInteractive, payload.Priority), nil
 }
 
 // OpenBulkStream opens a new bulk stream.
 func (s *Session) OpenBulkStream(ctx context.Context) (quic.Stream, error) {
-	// Stream type 2 is for bulk data.
-	stream, err := s.conn.OpenStreamSync(ctx)
+	return s.OpenStreamWithPriority(ctx, StreamTypeBulk, PriorityDefault)
+}
+
+// AcceptBulkStream accepts a new bulk stream.
+func (s *Session) AcceptBulkStream(ctx context.Context) (quic.Stream, error) {
+	stream, err := s.conn.AcceptStream(ctx)
 	if err != nil {
-		Error("Failed to open bulk stream: %v", err)
 		return nil, err
 	}
 	
-	// Send stream type identifier on the stream.
-	payload := &StreamTypePayload{
-		Type: StreamTypeBulk,
-	}
-	data, err := EncodeStreamType(payload)
+	// Read and verify stream type identifier from the stream.
+	msg, err := ReadMessage(stream)
 	if err != nil {
 		stream.Close()
-		Error("Failed to encode stream type: %v", err)
-		return nil, fmt.Errorf("failed to encode stream type: %w", err)
+		return nil, fmt.Errorf("failed to read stream type: %w", err)
 	}
 	
-	msg := &Message{
-		Type: StreamType,
-		Data: data,
+	if msg.Type != StreamType {
+		stream.Close()
+		return nil, fmt.Errorf("expected StreamType message, got %d", msg.Type)
 	}
 	
-	if err := WriteMessage(stream, msg); err != nil {
+	payload, err := DecodeStreamType(msg.Data)
+	if err != nil {
 		stream.Close()
-		return nil, fmt.Errorf("failed to send stream type: %w", err)
+		return nil, fmt.Errorf("failed to decode stream type payload: %w", err)
 	}
 	
-	return stream, nil
+	if payload.Type != StreamTypeBulk {
+		stream.Close()
+		return nil, fmt.Errorf("expected bulk stream type, got %d", payload.Type)
+	}
+	
+	return s.trackStream(stream, StreamTypeBulk, payload.Priority), nil
 }
 
-// AcceptBulkStream accepts a new bulk stream.
-func (s *Session) AcceptBulkStream(ctx context.Context) (quic.Stream, error) {
+// OpenTelemetryStream opens a new telemetry stream.
+func (s *Session) OpenTelemetryStream(ctx context.Context) (quic.Stream, error) {
+	return s.OpenStreamWithPriority(ctx, StreamTypeTelemetry, PriorityDefault)
+}
+
+// AcceptTelemetryStream accepts a new telemetry stream.
+func (s *Session) AcceptTelemetryStream(ctx context.Context) (quic.Stream, error) {
 	stream, err := s.conn.AcceptStream(ctx)
 	if err != nil {
 		return nil, err
@@ -138,31 +120,34 @@ func (s *Session) AcceptBulkStream(ctx context.Context) (quic.Stream, error) {
 		return nil, fmt.Errorf("failed to decode stream type payload: %w", err)
 	}
 	
-	if payload.Type != StreamTypeBulk {
+	if payload.Type != StreamTypeTelemetry {
 		stream.Close()
-		return nil, fmt.Errorf("expected bulk stream type, got %d", payload.Type)
+		return nil, fmt.Errorf("expected telemetry stream type, got %d", payload.Type)
 	}
 	
-	return stream, nil
+	return s.trackStream(stream, StreamTypeTelemetry, payload.Priority), nil
 }
 
-// OpenTelemetryStream opens a new telemetry stream.
-func (s *Session) OpenTelemetryStream(ctx context.Context) (quic.Stream, error) {
-	// Stream type 3 is for telemetry data.
+// OpenStreamWithPriority opens a new stream of the given type whose writes
+// are scheduled at priority. PriorityDefault uses the priority configured
+// for the stream type. The peer schedules its writes on the stream at the
+// same priority.
+func (s *Session) OpenStreamWithPriority(ctx context.Context, streamType uint8, priority StreamPriority) (quic.Stream, error) {
 	stream, err := s.conn.OpenStreamSync(ctx)
 	if err != nil {
-		Error("Failed to open telemetry stream: %v", err)
+		s.Logger().Error("Failed to open %s stream: %v", streamTypeName(streamType), err)
 		return nil, err
 	}
 	
 	// Send stream type identifier on the stream.
 	payload := &StreamTypePayload{
-		Type: StreamTypeTelemetry,
+		Type:     streamType,
+		Priority: priority,
 	}
 	data, err := EncodeStreamType(payload)
 	if err != nil {
 		stream.Close()
-		Error("Failed to encode stream type: %v", err)
+		s.Logger().Error("Failed to encode stream type: %v", err)
 		return nil, fmt.Errorf("failed to encode stream type: %w", err)
 	}
 	
@@ -176,38 +161,32 @@ func (s *Session) OpenTelemetryStream(ctx context.Context) (quic.Stream, error)
 		return nil, fmt.Errorf("failed to send stream type: %w", err)
 	}
 	
-	return stream, nil
+	return s.trackStream(stream, streamType, priority), nil
 }
 
-// AcceptTelemetryStream accepts a new telemetry stream.
-func (s *Session) AcceptTelemetryStream(ctx context.Context) (quic.Stream, error) {
-	stream, err := s.conn.AcceptStream(ctx)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Read and verify stream type identifier from the stream.
-	msg, err := ReadMessage(stream)
-	if err != nil {
-		stream.Close()
-		return nil, fmt.Errorf("failed to read stream type: %w", err)
-	}
-	
-	if msg.Type != StreamType {
-		stream.Close()
-		return nil, fmt.Errorf("expected StreamType message, got %d", msg.Type)
-	}
-	
-	payload, err := DecodeStreamType(msg.Data)
-	if err != nil {
-		stream.Close()
-		return nil, fmt.Errorf("failed to decode stream type payload: %w", err)
-	}
-	
-	if payload.Type != StreamTypeTelemetry {
-		stream.Close()
-		return nil, fmt.Errorf("expected telemetry stream type, got %d", payload.Type)
-	}
-	
-	return stream, nil
-}
\ No newline at end of file
+// trackStream records a new stream of the given type in the default metrics
+// and wraps it so its bytes are counted for the session's user and path,
+// its writes are scheduled at priority and paced by the session's rate
+// limiter, and its bytes are accounted against the user's quota on server
+// sessions.
+func (s *Session) trackStream(stream quic.Stream, streamType uint8, priority StreamPriority) quic.Stream {
+	policy, priorities := s.policy()
+	priority = streamPriority(streamType, priority, priorities)
+	s.Logger().Log(DebugLevel, "Stream opened", "stream_id", int64(stream.StreamID()), "stream_type", streamType, "priority", priority)
+	defaultMetrics.StreamOpened(streamType)
+	limiter := s.streamLimiter(streamType)
+	if s.scheduler != nil {
+		flow := s.scheduler.NewFlow(priority, policy.streamWeight(streamType))
+		stream = newScheduledStream(stream, limiter, flow)
+	} else if limiter != nil {
+		stream = newRateLimitedStream(stream, limiter)
+	}
+	if s.accountant != nil {
+		stream = newAccountedStream(stream, s.accountant, s.user, s.id)
+	}
+	path := s.conn.RemoteAddr().String()
+	s.meterOnce.Do(func() {
+		defaultMetrics.meterConnection(s.conn, s.user, path)
+	})
+	return newMeteredStream(stream, defaultMetrics, s.user, path)
+}
@@ -1,15 +1,19 @@
 package core
 
 import (
+	"bufio"
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
-	"github.com/fxamacker/cbor/v2"
 	"github.com/quic-go/quic-go"
 )
 
+// telemetryLog logs telemetry exchange events.
+var telemetryLog = ComponentLogger("telemetry")
+
 // TelemetryData represents telemetry data collected from a session.
 type TelemetryData struct {
 	// RTT is the round-trip time.
@@ -26,12 +30,22 @@ type TelemetryData struct {
 	BytesInFlight uint64
 	// DeliveryRate is the estimated delivery rate.
 	DeliveryRate uint64
+	// ReceiveLoss is the loss rate (0.0 - 1.0) observed on packets received
+	// from the peer.
+	ReceiveLoss float64
+	// FEC holds the forward error correction effectiveness counters.
+	FEC *FECStats
+	// SessionID is the ID of the session the telemetry was collected on.
+	SessionID string
 }
 
 // TelemetryCollector collects telemetry data from a session.
 type TelemetryCollector struct {
 	// conn is the QUIC connection to collect statistics from.
 	conn quic.Connection
+	// stats is the stats tracker of the connection, or nil if the
+	// connection is not traced.
+	stats *ConnectionStatsTracker
 	// lastTime is the time when the last statistics were collected.
 	lastTime time.Time
 	// lastBytesSent is the number of bytes sent at the last collection.
@@ -40,157 +54,289 @@ type TelemetryCollector struct {
 	lastPacketsSent uint64
 	// lastPacketsLost is the number of packets lost at the last collection.
 	lastPacketsLost uint64
+	// lastBytesAcked is the number of bytes acknowledged at the last collection.
+	lastBytesAcked uint64
+	// lastPacketsReceived is the number of packets received at the last collection.
+	lastPacketsReceived uint64
+	// lastPacketsMissing is the number of missing packets at the last collection.
+	lastPacketsMissing uint64
+	// fecMetrics is the source of the FEC effectiveness counters.
+	fecMetrics *FECMetrics
+	// sessionID is the session ID set on collected telemetry.
+	sessionID string
 }
 
 // NewTelemetryCollector creates a new TelemetryCollector.
 func NewTelemetryCollector(conn quic.Connection) *TelemetryCollector {
+	return newTelemetryCollector(conn, ConnectionStatsFor(conn))
+}
+
+// newTelemetryCollector creates a new TelemetryCollector that reads
+// connection statistics from the given tracker.
+func newTelemetryCollector(conn quic.Connection, stats *ConnectionStatsTracker) *TelemetryCollector {
 	return &TelemetryCollector{
-		conn: conn,
+		conn:       conn,
+		stats:      stats,
+		lastTime:   time.Now(),
+		fecMetrics: defaultFECMetrics,
 	}
 }
 
+// SetFECMetrics sets the FEC metrics included in collected telemetry.
+func (tc *TelemetryCollector) SetFECMetrics(metrics *FECMetrics) {
+	tc.fecMetrics = metrics
+}
+
+// SetSessionID sets the session ID included in collected telemetry.
+func (tc *TelemetryCollector) SetSessionID(id string) {
+	tc.sessionID = id
+}
+
 // Collect collects telemetry data from a session.
-// This implementation gathers data from the QUIC connection statistics.
+// RTT, congestion window and bytes in flight are the latest values reported
+// by the connection tracer. Loss and delivery rate are computed over the
+// interval since the previous call. Bandwidth is the highest recent
+// delivery rate measured by the tracker. If the connection is not traced,
+// only the timestamp and FEC counters are set.
 func (tc *TelemetryCollector) Collect() *TelemetryData {
-	// Since quic-go Connection interface doesn't provide Stats method in all versions,
-	// we'll use placeholder values for telemetry data
 	now := time.Now()
+	data := &TelemetryData{
+		Timestamp: now,
+		SessionID: tc.sessionID,
+	}
 	
-	// Use placeholder values for all telemetry metrics
-	rtt := 50 * time.Millisecond // Default value
-	loss := 0.01 // 1% loss
-	bandwidth := uint64(1000000) // 1 MB/s
-	cwnd := uint64(10000) // Default value
-	bytesInFlight := uint64(1000) // Default value
-	deliveryRate := uint64(1000000) // 1 MB/s default
-	
-	// Update last collection values
+	if tc.stats != nil {
+		stats := tc.stats.Stats()
+		elapsed := now.Sub(tc.lastTime)
+		
+		data.RTT = stats.SmoothedRTT
+		data.CongestionWindow = stats.CongestionWindow
+		data.BytesInFlight = stats.BytesInFlight
+		
+		// Loss is the fraction of packets sent in this interval that were lost
+		packetsSent := stats.PacketsSent - tc.lastPacketsSent
+		packetsLost := stats.PacketsLost - tc.lastPacketsLost
+		if packetsSent > 0 {
+			data.Loss = float64(packetsLost) / float64(packetsSent)
+			if data.Loss > 1 {
+				data.Loss = 1
+			}
+		}
+		
+		// Receive loss is the fraction of packets expected from the peer in
+		// this interval that did not arrive. Late packets can make the
+		// missing count shrink.
+		packetsReceived := stats.PacketsReceived - tc.lastPacketsReceived
+		packetsMissing := int64(stats.PacketsMissing) - int64(tc.lastPacketsMissing)
+		if packetsMissing > 0 {
+			data.ReceiveLoss = float64(packetsMissing) / float64(packetsReceived+uint64(packetsMissing))
+		}
+		
+		// Delivery rate is the number of bytes acknowledged in this interval
+		if elapsed > 0 {
+			bytesAcked := stats.BytesAcked - tc.lastBytesAcked
+			data.DeliveryRate = uint64(float64(bytesAcked) / elapsed.Seconds())
+		}
+		
+		// Until the first delivery rate sample, bandwidth is estimated as
+		// one congestion window per round trip
+		if stats.Bandwidth > 0 {
+			data.Bandwidth = stats.Bandwidth
+		} else if stats.SmoothedRTT > 0 {
+			data.Bandwidth = uint64(float64(stats.CongestionWindow) / stats.SmoothedRTT.Seconds())
+		}
+		
+		// Update last collection values
+		tc.lastBytesSent = stats.BytesSent
+		tc.lastPacketsSent = stats.PacketsSent
+		tc.lastPacketsLost = stats.PacketsLost
+		tc.lastBytesAcked = stats.BytesAcked
+		tc.lastPacketsReceived = stats.PacketsReceived
+		tc.lastPacketsMissing = stats.PacketsMissing
+	}
 	tc.lastTime = now
-	// Note: We can't update bytes/packets stats without Stats() method
 	
-	// Create telemetry data
-	return &TelemetryData{
-		RTT:              rtt,
-		Loss:             loss,
-		Bandwidth:        bandwidth,
-		Timestamp:        now,
-		CongestionWindow: cwnd,
-		BytesInFlight:    bytesInFlight,
-		DeliveryRate:     deliveryRate,
+	// Include FEC effectiveness counters
+	if tc.fecMetrics != nil {
+		stats := tc.fecMetrics.Snapshot()
+		data.FEC = &stats
 	}
+	
+	return data
 }
 
 // TelemetryReporter reports telemetry data to a stream.
 type TelemetryReporter struct {
 	// stream is the telemetry stream to report data to.
 	stream quic.Stream
+	// events receives an event for every report.
+	events *EventBus
+	// writeErr is the error the stream failed with. Once set, no further
+	// reports are written.
+	writeErr error
+	// mutex protects writeErr.
+	mutex sync.Mutex
 }
 
 // NewTelemetryReporter creates a new TelemetryReporter.
 func NewTelemetryReporter(stream quic.Stream) *TelemetryReporter {
 	return &TelemetryReporter{
 		stream: stream,
+		events: defaultEventBus,
 	}
 }
 
+// SetEventBus sets the bus the reporter publishes its events on.
+func (tr *TelemetryReporter) SetEventBus(events *EventBus) {
+	tr.events = events
+}
+
 // Report reports telemetry data to a stream.
-// This implementation encodes and sends the data over a telemetry stream.
+// The data is sent as a Telemetry message carrying a versioned payload.
+// A TelemetryReportedEvent or TelemetryReportFailedEvent is published for
+// every call until the stream fails. Once a write has failed, the stream is
+// not written again and Report returns ErrTelemetryStreamFailed without
+// publishing an event.
 func (tr *TelemetryReporter) Report(data *TelemetryData) error {
-	// Encode the telemetry data using CBOR
-	encodedData, err := cbor.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to encode telemetry data: %w", err)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	if tr.writeErr != nil {
+		return fmt.Errorf("%w: %v", ErrTelemetryStreamFailed, tr.writeErr)
+	}
+	if err := tr.report(data); err != nil {
+		tr.events.Publish(&TelemetryReportFailedEvent{Err: err})
+		return err
 	}
 
-	// Write the length of the encoded data as a prefix
-	length := uint32(len(encodedData))
-	lengthBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBuf, length)
+	tr.events.Publish(&TelemetryReportedEvent{Data: data})
+	return nil
+}
 
-	// Write the length prefix and the encoded data to the stream
-	if _, err := tr.stream.Write(lengthBuf); err != nil {
-		return fmt.Errorf("failed to write length prefix: %w", err)
+// report encodes and writes a telemetry report.
+func (tr *TelemetryReporter) report(data *TelemetryData) error {
+	payload, err := EncodeTelemetry(&TelemetryPayload{
+		Version: TelemetryVersion,
+		Data:    data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry data: %w", err)
 	}
 
-	if _, err := tr.stream.Write(encodedData); err != nil {
+	msg := &Message{
+		Type: Telemetry,
+		Data: payload,
+	}
+	if err := WriteMessage(tr.stream, msg); err != nil {
+		tr.writeErr = err
 		return fmt.Errorf("failed to write telemetry data: %w", err)
 	}
 
-	// Also print to stdout for visibility
-	fmt.Printf("Telemetry: RTT=%v, Loss=%.2f%%, Bandwidth=%d B/s, CWND=%d, InFlight=%d, DeliveryRate=%d B/s\n",
-		data.RTT, data.Loss*100, data.Bandwidth, data.CongestionWindow, data.BytesInFlight, data.DeliveryRate)
-
 	return nil
 }
 
+// ErrTelemetryStreamFailed is returned by TelemetryReporter.Report once a
+// report could not be written to the stream.
+var ErrTelemetryStreamFailed = errors.New("telemetry stream failed")
+
+// ErrInvalidTelemetry is returned by TelemetryReceiver.Receive for a
+// well-framed message that is not a valid telemetry report. The stream is
+// still usable and the next report can be received.
+var ErrInvalidTelemetry = errors.New("invalid telemetry report")
+
 // TelemetryReceiver receives telemetry data from a stream.
 type TelemetryReceiver struct {
 	// stream is the telemetry stream to receive data from.
 	stream quic.Stream
+	// reader buffers reads from the stream across reports.
+	reader *bufio.Reader
 }
 
 // NewTelemetryReceiver creates a new TelemetryReceiver.
 func NewTelemetryReceiver(stream quic.Stream) *TelemetryReceiver {
 	return &TelemetryReceiver{
 		stream: stream,
+		reader: bufio.NewReader(stream),
 	}
 }
 
 // Receive receives telemetry data from a stream.
+// It blocks until a complete report has been read. Messages larger than
+// maxTelemetryMessageSize are rejected without being read. Other message
+// types, unsupported versions and out-of-range values are reported as
+// ErrInvalidTelemetry.
 func (tr *TelemetryReceiver) Receive() (*TelemetryData, error) {
-	// Read the length of the encoded data
-	lengthBuf := make([]byte, 4)
-	if _, err := tr.stream.Read(lengthBuf); err != nil {
-		return nil, fmt.Errorf("failed to read length prefix: %w", err)
+	msg, err := readMessage(tr.reader, maxTelemetryMessageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry message: %w", err)
 	}
+	return decodeTelemetryMessage(msg)
+}
 
-	length := binary.BigEndian.Uint32(lengthBuf)
-
-	// Read the encoded data
-	encodedData := make([]byte, length)
-	if _, err := tr.stream.Read(encodedData); err != nil {
-		return nil, fmt.Errorf("failed to read telemetry data: %w", err)
+// decodeTelemetryMessage decodes the telemetry data of a Telemetry message.
+func decodeTelemetryMessage(msg *Message) (*TelemetryData, error) {
+	if msg.Type != Telemetry {
+		return nil, fmt.Errorf("%w: unexpected message type %d", ErrInvalidTelemetry, msg.Type)
 	}
 
-	// Decode the telemetry data using CBOR
-	var data TelemetryData
-	if err := cbor.Unmarshal(encodedData, &data); err != nil {
-		return nil, fmt.Errorf("failed to decode telemetry data: %w", err)
+	payload, err := DecodeTelemetry(msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTelemetry, err)
 	}
 
-	return &data, nil
+	return payload.Data, nil
 }
 
 // TelemetryStream represents a telemetry stream.
 type TelemetryStream struct {
 	quic.Stream
+	// reporter writes reports to the stream. It is kept across calls so
+	// that a stream that failed is not written again.
+	reporter *TelemetryReporter
+	// receiver reads reports from the stream. It is kept across calls so
+	// that buffered data is not lost.
+	receiver *TelemetryReceiver
 }
 
 // NewTelemetryStream creates a new TelemetryStream.
 func NewTelemetryStream(stream quic.Stream) *TelemetryStream {
 	return &TelemetryStream{
-		Stream: stream,
+		Stream:   stream,
+		reporter: NewTelemetryReporter(stream),
 	}
 }
 
-// WriteTelemetry writes telemetry data to the stream.
+// WriteTelemetry writes telemetry data to the stream. Once a write has
+// failed, it returns ErrTelemetryStreamFailed without writing again.
 func (ts *TelemetryStream) WriteTelemetry(data *TelemetryData) error {
-	reporter := NewTelemetryReporter(ts.Stream)
-	return reporter.Report(data)
+	return ts.reporter.Report(data)
 }
 
 // ReadTelemetry reads telemetry data from the stream.
 func (ts *TelemetryStream) ReadTelemetry() (*TelemetryData, error) {
-	receiver := NewTelemetryReceiver(ts.Stream)
-	return receiver.Receive()
+	if ts.receiver == nil {
+		ts.receiver = NewTelemetryReceiver(ts.Stream)
+	}
+	return ts.receiver.Receive()
 }
 
-// TelemetryManager manages telemetry collection and reporting.
+// TelemetryManager manages telemetry collection and reporting, and
+// consumes the reports the peer sends on the same stream.
 type TelemetryManager struct {
 	// collector is the telemetry collector.
 	collector *TelemetryCollector
 	// reporter is the telemetry reporter.
 	reporter *TelemetryReporter
+	// receiver receives the peer's telemetry reports.
+	receiver *TelemetryReceiver
+	// peer is the latest telemetry report received from the peer.
+	peer *TelemetryData
+	// peerMutex protects peer.
+	peerMutex sync.RWMutex
+	// history keeps the recently collected samples.
+	history *TelemetryHistory
+	// onSample is called with each collected sample, or is nil.
+	onSample func(*TelemetryData)
 	// ctx is the context for the manager.
 	ctx context.Context
 	// cancel is the cancel function for the manager.
@@ -206,15 +352,32 @@ func NewTelemetryManager(conn quic.Connection, stream quic.Stream, interval time
 	return &TelemetryManager{
 		collector: NewTelemetryCollector(conn),
 		reporter:  NewTelemetryReporter(stream),
+		receiver:  NewTelemetryReceiver(stream),
+		history:   NewTelemetryHistory(defaultTelemetryHistorySize),
 		ctx:       ctx,
 		cancel:    cancel,
 		interval:  interval,
 	}
 }
 
+// SetSessionID sets the session ID included in collected telemetry. It must
+// be called before Start.
+func (tm *TelemetryManager) SetSessionID(id string) {
+	tm.collector.SetSessionID(id)
+}
+
+// OnSample sets a function called with each collected sample. It must be
+// called before Start.
+func (tm *TelemetryManager) OnSample(fn func(*TelemetryData)) {
+	tm.onSample = fn
+}
+
 // Start starts the telemetry manager.
-// It periodically collects and reports telemetry data.
+// It periodically collects and reports telemetry data, and receives the
+// peer's reports until the stream is closed.
 func (tm *TelemetryManager) Start() {
+	go tm.receivePeerTelemetry()
+	
 	go func() {
 		ticker := time.NewTicker(tm.interval)
 		defer ticker.Stop()
@@ -226,16 +389,59 @@ func (tm *TelemetryManager) Start() {
 			case <-ticker.C:
 				// Collect telemetry data
 				data := tm.collector.Collect()
-
-				// Report telemetry data
-				if err := tm.reporter.Report(data); err != nil {
-					fmt.Printf("Failed to report telemetry: %v\n", err)
+				defaultMetrics.ObserveTelemetry(data)
+				tm.history.Add(data)
+				if tm.onSample != nil {
+					tm.onSample(data)
 				}
+
+				// Report telemetry data; failures are published as events,
+				// and reporting stops once the stream has failed
+				tm.reporter.Report(data)
 			}
 		}
 	}()
 }
 
+// receivePeerTelemetry stores the peer's reports as they arrive.
+func (tm *TelemetryManager) receivePeerTelemetry() {
+	for {
+		data, err := tm.receiver.Receive()
+		if errors.Is(err, ErrInvalidTelemetry) {
+			telemetryLog.Debug("Ignoring peer telemetry: %v", err)
+			continue
+		}
+		if err != nil {
+			if tm.ctx.Err() == nil {
+				telemetryLog.Debug("Stopped receiving peer telemetry: %v", err)
+			}
+			return
+		}
+
+		tm.peerMutex.Lock()
+		tm.peer = data
+		tm.peerMutex.Unlock()
+	}
+}
+
+// PeerTelemetry returns a copy of the latest telemetry report received from
+// the peer, or nil if none has been received yet.
+func (tm *TelemetryManager) PeerTelemetry() *TelemetryData {
+	tm.peerMutex.RLock()
+	defer tm.peerMutex.RUnlock()
+
+	if tm.peer == nil {
+		return nil
+	}
+	data := *tm.peer
+	return &data
+}
+
+// History returns the history of the collected samples.
+func (tm *TelemetryManager) History() *TelemetryHistory {
+	return tm.history
+}
+
 // Stop stops the telemetry manager.
 func (tm *TelemetryManager) Stop() {
 	tm.cancel()
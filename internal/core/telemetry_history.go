@@ -0,0 +1,303 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultTelemetryHistorySize is the number of samples kept per session or
+// path: ten minutes at the default one second collection interval.
+const defaultTelemetryHistorySize = 600
+
+// TelemetryHistory is a bounded ring buffer of telemetry samples. When it is
+// full, the oldest sample is overwritten. It is safe for concurrent use.
+type TelemetryHistory struct {
+	// samples holds the samples in insertion order, starting at start.
+	samples []TelemetryData
+	// start is the index of the oldest sample.
+	start int
+	// count is the number of samples held.
+	count int
+	// mutex protects samples, start and count.
+	mutex sync.RWMutex
+}
+
+// NewTelemetryHistory creates a new TelemetryHistory that keeps up to
+// capacity samples.
+func NewTelemetryHistory(capacity int) *TelemetryHistory {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TelemetryHistory{
+		samples: make([]TelemetryData, capacity),
+	}
+}
+
+// Add appends a copy of a sample to the history.
+func (th *TelemetryHistory) Add(data *TelemetryData) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	index := (th.start + th.count) % len(th.samples)
+	th.samples[index] = *data
+	if th.count < len(th.samples) {
+		th.count++
+	} else {
+		th.start = (th.start + 1) % len(th.samples)
+	}
+}
+
+// Since returns the samples taken at or after since, oldest first. A zero
+// since returns the whole history.
+func (th *TelemetryHistory) Since(since time.Time) []TelemetryData {
+	th.mutex.RLock()
+	defer th.mutex.RUnlock()
+
+	// Samples are in time order, so skip the older ones
+	first := sort.Search(th.count, func(i int) bool {
+		return !th.samples[(th.start+i)%len(th.samples)].Timestamp.Before(since)
+	})
+
+	result := make([]TelemetryData, 0, th.count-first)
+	for i := first; i < th.count; i++ {
+		result = append(result, th.samples[(th.start+i)%len(th.samples)])
+	}
+	return result
+}
+
+// Len returns the number of samples held.
+func (th *TelemetryHistory) Len() int {
+	th.mutex.RLock()
+	defer th.mutex.RUnlock()
+	return th.count
+}
+
+// StatSummary summarizes the values of one telemetry field.
+type StatSummary struct {
+	// Min is the smallest value.
+	Min float64 `json:"min"`
+	// Avg is the mean value.
+	Avg float64 `json:"avg"`
+	// P95 is the 95th percentile value.
+	P95 float64 `json:"p95"`
+	// Max is the largest value.
+	Max float64 `json:"max"`
+}
+
+// TelemetrySummary summarizes a series of telemetry samples.
+type TelemetrySummary struct {
+	// Samples is the number of samples summarized.
+	Samples int `json:"samples"`
+	// From is the timestamp of the oldest sample.
+	From time.Time `json:"from"`
+	// To is the timestamp of the newest sample.
+	To time.Time `json:"to"`
+	// RTTMillis summarizes the round-trip time in milliseconds. Samples
+	// without an RTT measurement are skipped.
+	RTTMillis StatSummary `json:"rtt_ms"`
+	// Loss summarizes the send loss rate.
+	Loss StatSummary `json:"loss"`
+	// ReceiveLoss summarizes the receive loss rate.
+	ReceiveLoss StatSummary `json:"receive_loss"`
+	// Bandwidth summarizes the estimated bandwidth in bytes per second.
+	Bandwidth StatSummary `json:"bandwidth"`
+	// DeliveryRate summarizes the delivery rate in bytes per second.
+	DeliveryRate StatSummary `json:"delivery_rate"`
+}
+
+// SummarizeTelemetry computes min/avg/p95/max summaries of samples.
+func SummarizeTelemetry(samples []TelemetryData) TelemetrySummary {
+	summary := TelemetrySummary{Samples: len(samples)}
+	if len(samples) == 0 {
+		return summary
+	}
+	summary.From = samples[0].Timestamp
+	summary.To = samples[len(samples)-1].Timestamp
+
+	var rtt, loss, receiveLoss, bandwidth, deliveryRate []float64
+	for _, s := range samples {
+		if s.RTT > 0 {
+			rtt = append(rtt, float64(s.RTT)/float64(time.Millisecond))
+		}
+		loss = append(loss, s.Loss)
+		receiveLoss = append(receiveLoss, s.ReceiveLoss)
+		bandwidth = append(bandwidth, float64(s.Bandwidth))
+		deliveryRate = append(deliveryRate, float64(s.DeliveryRate))
+	}
+
+	summary.RTTMillis = summarize(rtt)
+	summary.Loss = summarize(loss)
+	summary.ReceiveLoss = summarize(receiveLoss)
+	summary.Bandwidth = summarize(bandwidth)
+	summary.DeliveryRate = summarize(deliveryRate)
+	return summary
+}
+
+// summarize computes the summary of values, sorting them in place.
+// The percentile uses the nearest-rank method.
+func summarize(values []float64) StatSummary {
+	if len(values) == 0 {
+		return StatSummary{}
+	}
+	sort.Float64s(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	rank := int(math.Ceil(0.95*float64(len(values)))) - 1
+
+	return StatSummary{
+		Min: values[0],
+		Avg: sum / float64(len(values)),
+		P95: values[rank],
+		Max: values[len(values)-1],
+	}
+}
+
+// telemetryHistories holds the histories of the live sessions and paths,
+// keyed by kind and ID, for the debug endpoint.
+var telemetryHistories = struct {
+	entries map[telemetryHistoryKey]*TelemetryHistory
+	mutex   sync.RWMutex
+}{
+	entries: make(map[telemetryHistoryKey]*TelemetryHistory),
+}
+
+// telemetryHistoryKey identifies a registered history.
+type telemetryHistoryKey struct {
+	// kind is "session" or "path".
+	kind string
+	// id identifies the session or path.
+	id string
+}
+
+// registerTelemetryHistory makes a history visible on the debug endpoint
+// and returns a function that removes it again.
+func registerTelemetryHistory(kind, id string, history *TelemetryHistory) func() {
+	key := telemetryHistoryKey{kind: kind, id: id}
+
+	telemetryHistories.mutex.Lock()
+	telemetryHistories.entries[key] = history
+	telemetryHistories.mutex.Unlock()
+
+	return func() {
+		telemetryHistories.mutex.Lock()
+		if telemetryHistories.entries[key] == history {
+			delete(telemetryHistories.entries, key)
+		}
+		telemetryHistories.mutex.Unlock()
+	}
+}
+
+// telemetryHistoryReport is the JSON representation of one history on the
+// debug endpoint.
+type telemetryHistoryReport struct {
+	Kind    string            `json:"kind"`
+	ID      string            `json:"id"`
+	Summary TelemetrySummary  `json:"summary"`
+	Samples []telemetrySample `json:"samples,omitempty"`
+}
+
+// telemetrySample is the JSON representation of a telemetry sample on the
+// debug endpoint.
+type telemetrySample struct {
+	Timestamp        time.Time `json:"timestamp"`
+	RTTMillis        float64   `json:"rtt_ms"`
+	Loss             float64   `json:"loss"`
+	ReceiveLoss      float64   `json:"receive_loss"`
+	Bandwidth        uint64    `json:"bandwidth"`
+	DeliveryRate     uint64    `json:"delivery_rate"`
+	CongestionWindow uint64    `json:"cwnd"`
+	BytesInFlight    uint64    `json:"bytes_in_flight"`
+}
+
+// TelemetryHistoryHandler serves the telemetry history of all live sessions
+// and paths as JSON. The optional query parameters are:
+//
+//	since    how far back to look, as a duration such as "5m" (default: all)
+//	kind     only report "session" or "path" histories
+//	id       only report the history with this ID
+//	samples  set to "false" to only report the summaries
+func TelemetryHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since time.Time
+	if s := query.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	withSamples := query.Get("samples") != "false"
+
+	telemetryHistories.mutex.RLock()
+	reports := make([]telemetryHistoryReport, 0, len(telemetryHistories.entries))
+	for key, history := range telemetryHistories.entries {
+		if kind := query.Get("kind"); kind != "" && kind != key.kind {
+			continue
+		}
+		if id := query.Get("id"); id != "" && id != key.id {
+			continue
+		}
+
+		samples := history.Since(since)
+		report := telemetryHistoryReport{
+			Kind:    key.kind,
+			ID:      key.id,
+			Summary: SummarizeTelemetry(samples),
+		}
+		if withSamples {
+			report.Samples = make([]telemetrySample, len(samples))
+			for i, s := range samples {
+				report.Samples[i] = telemetrySample{
+					Timestamp:        s.Timestamp,
+					RTTMillis:        float64(s.RTT) / float64(time.Millisecond),
+					Loss:             s.Loss,
+					ReceiveLoss:      s.ReceiveLoss,
+					Bandwidth:        s.Bandwidth,
+					DeliveryRate:     s.DeliveryRate,
+					CongestionWindow: s.CongestionWindow,
+					BytesInFlight:    s.BytesInFlight,
+				}
+			}
+		}
+		reports = append(reports, report)
+	}
+	telemetryHistories.mutex.RUnlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Kind != reports[j].Kind {
+			return reports[i].Kind < reports[j].Kind
+		}
+		return reports[i].ID < reports[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		telemetryLog.Warn("Failed to write telemetry history: %v", err)
+	}
+}
+
+// StartDebugServer starts an HTTP server on addr that serves the telemetry
+// history on /debug/telemetry. It is meant to be bound to a local address.
+// The server runs until it is closed.
+func StartDebugServer(addr string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/telemetry", TelemetryHistoryHandler)
+
+	server, err := startHTTPServer(addr, mux)
+	if err != nil {
+		return nil, err
+	}
+
+	telemetryLog.Info("Serving telemetry history on http://%s/debug/telemetry", server.Addr)
+	return server, nil
+}
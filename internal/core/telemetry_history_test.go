@@ -0,0 +1,126 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTelemetryHistoryRingBuffer(t *testing.T) {
+	history := NewTelemetryHistory(5)
+	start := time.Now().Add(-time.Minute)
+
+	// Eight samples into five slots keep the last five
+	for i := 0; i < 8; i++ {
+		history.Add(&TelemetryData{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			RTT:       time.Duration(i+1) * time.Millisecond,
+		})
+	}
+	if history.Len() != 5 {
+		t.Fatalf("Expected 5 samples, got %d", history.Len())
+	}
+
+	all := history.Since(time.Time{})
+	if len(all) != 5 {
+		t.Fatalf("Expected 5 samples, got %d", len(all))
+	}
+	for i, s := range all {
+		if expected := time.Duration(i+4) * time.Millisecond; s.RTT != expected {
+			t.Errorf("Sample %d: expected RTT %v, got %v", i, expected, s.RTT)
+		}
+	}
+
+	recent := history.Since(start.Add(6 * time.Second))
+	if len(recent) != 2 || recent[0].RTT != 7*time.Millisecond {
+		t.Errorf("Expected the last 2 samples, got %d", len(recent))
+	}
+	if len(history.Since(time.Now())) != 0 {
+		t.Error("Expected no samples in the future")
+	}
+}
+
+func TestSummarizeTelemetry(t *testing.T) {
+	var samples []TelemetryData
+	start := time.Now()
+	for i := 1; i <= 20; i++ {
+		samples = append(samples, TelemetryData{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			RTT:       time.Duration(i) * time.Millisecond,
+			Loss:      float64(i) / 100,
+			Bandwidth: uint64(i * 1000),
+		})
+	}
+	// A sample without an RTT measurement does not count towards the RTT
+	samples = append(samples, TelemetryData{Timestamp: start.Add(21 * time.Second)})
+
+	summary := SummarizeTelemetry(samples)
+	if summary.Samples != 21 {
+		t.Errorf("Expected 21 samples, got %d", summary.Samples)
+	}
+	if !summary.From.Equal(samples[0].Timestamp) || !summary.To.Equal(samples[20].Timestamp) {
+		t.Errorf("Unexpected time range %v - %v", summary.From, summary.To)
+	}
+	if summary.RTTMillis.Min != 1 || summary.RTTMillis.Max != 20 || summary.RTTMillis.Avg != 10.5 || summary.RTTMillis.P95 != 19 {
+		t.Errorf("Unexpected RTT summary %+v", summary.RTTMillis)
+	}
+	if summary.Loss.Min != 0 || summary.Loss.P95 != 0.19 {
+		t.Errorf("Unexpected loss summary %+v", summary.Loss)
+	}
+	if summary.Bandwidth.Max != 20000 {
+		t.Errorf("Unexpected bandwidth summary %+v", summary.Bandwidth)
+	}
+
+	if empty := SummarizeTelemetry(nil); empty.Samples != 0 || empty.RTTMillis != (StatSummary{}) {
+		t.Errorf("Unexpected summary of no samples %+v", empty)
+	}
+}
+
+func TestTelemetryHistoryHandler(t *testing.T) {
+	history := NewTelemetryHistory(10)
+	history.Add(&TelemetryData{Timestamp: time.Now().Add(-time.Hour), RTT: 100 * time.Millisecond})
+	history.Add(&TelemetryData{Timestamp: time.Now(), RTT: 20 * time.Millisecond, Loss: 0.05})
+	unregister := registerTelemetryHistory("session", "198.51.100.7:4242", history)
+	defer unregister()
+	unregisterPath := registerTelemetryHistory("path", "203.0.113.1:4242", NewTelemetryHistory(10))
+	defer unregisterPath()
+
+	recorder := httptest.NewRecorder()
+	TelemetryHistoryHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/telemetry?since=5m&kind=session", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", recorder.Code)
+	}
+
+	var reports []telemetryHistoryReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reports); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(reports) != 1 || reports[0].ID != "198.51.100.7:4242" {
+		t.Fatalf("Expected the session history only, got %+v", reports)
+	}
+	if len(reports[0].Samples) != 1 || reports[0].Samples[0].RTTMillis != 20 {
+		t.Errorf("Expected the recent sample only, got %+v", reports[0].Samples)
+	}
+	if reports[0].Summary.Loss.Max != 0.05 {
+		t.Errorf("Unexpected summary %+v", reports[0].Summary)
+	}
+
+	recorder = httptest.NewRecorder()
+	TelemetryHistoryHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/telemetry?since=yesterday", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid since, got %d", recorder.Code)
+	}
+
+	// Unregistered histories are no longer reported
+	unregister()
+	recorder = httptest.NewRecorder()
+	TelemetryHistoryHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/telemetry?kind=session", nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reports); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("Expected no session histories, got %+v", reports)
+	}
+}
@@ -2,12 +2,29 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
 	"testing"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
 )
 
+// eofStream is a mock stream that ends with io.EOF once its data is read,
+// so framing errors surface instead of reads spinning on an empty stream.
+type eofStream struct {
+	MockQUICStream
+}
+
+func (s *eofStream) Read(p []byte) (int, error) {
+	if len(s.readData) == 0 {
+		return 0, io.EOF
+	}
+	return s.MockQUICStream.Read(p)
+}
+
 func TestTelemetryDataSerialization(t *testing.T) {
 	// Create test telemetry data
 	data := &TelemetryData{
@@ -107,4 +124,207 @@ func TestTelemetryReporterAndReceiver(t *testing.T) {
 	if receivedData.Bandwidth != data.Bandwidth {
 		t.Errorf("Bandwidth mismatch: expected %d, got %d", data.Bandwidth, receivedData.Bandwidth)
 	}
-}
\ No newline at end of file
+}
+
+func TestTelemetryReceiverMultipleReports(t *testing.T) {
+	// Write two reports back to back
+	out := &MockQUICStream{}
+	reporter := NewTelemetryReporter(out)
+	for _, rtt := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		if err := reporter.Report(&TelemetryData{RTT: rtt, Timestamp: time.Now()}); err != nil {
+			t.Fatalf("Failed to report telemetry: %v", err)
+		}
+	}
+
+	// Both are read back in order, even though they arrive in a single read
+	receiver := NewTelemetryReceiver(&eofStream{MockQUICStream{readData: out.writeData}})
+	for _, expected := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		data, err := receiver.Receive()
+		if err != nil {
+			t.Fatalf("Failed to receive telemetry: %v", err)
+		}
+		if data.RTT != expected {
+			t.Errorf("Expected RTT %v, got %v", expected, data.RTT)
+		}
+	}
+}
+
+// encodeTestTelemetryMessage returns a framed Telemetry message.
+func encodeTestTelemetryMessage(t testing.TB, msgType MessageType, payload *TelemetryPayload) []byte {
+	t.Helper()
+	data, err := EncodeTelemetry(payload)
+	if err != nil {
+		t.Fatalf("Failed to encode telemetry payload: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, &Message{Type: msgType, Data: data}); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTelemetryReceiverRejectsInvalidReports(t *testing.T) {
+	var stream []byte
+	stream = append(stream, encodeTestTelemetryMessage(t, StreamType, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{}})...)
+	stream = append(stream, encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion + 1, Data: &TelemetryData{}})...)
+	stream = append(stream, encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion})...)
+	stream = append(stream, encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{Loss: 1.5}})...)
+	stream = append(stream, encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{RTT: -time.Second}})...)
+	stream = append(stream, encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{RTT: 15 * time.Millisecond}})...)
+
+	// Invalid reports are skipped without losing the framing
+	receiver := NewTelemetryReceiver(&eofStream{MockQUICStream{readData: stream}})
+	for i := 0; i < 5; i++ {
+		if _, err := receiver.Receive(); !errors.Is(err, ErrInvalidTelemetry) {
+			t.Errorf("Report %d: expected ErrInvalidTelemetry, got %v", i, err)
+		}
+	}
+	data, err := receiver.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive valid report: %v", err)
+	}
+	if data.RTT != 15*time.Millisecond {
+		t.Errorf("Expected RTT 15ms, got %v", data.RTT)
+	}
+
+	// An oversized length prefix is rejected before the body is read
+	lengthBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthBuf, maxTelemetryMessageSize+1)
+	receiver = NewTelemetryReceiver(&eofStream{MockQUICStream{readData: lengthBuf}})
+	if _, err := receiver.Receive(); err == nil || errors.Is(err, ErrInvalidTelemetry) {
+		t.Errorf("Expected a framing error for an oversized report, got %v", err)
+	}
+
+	// A truncated report is a framing error
+	valid := encodeTestTelemetryMessage(t, Telemetry, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{}})
+	receiver = NewTelemetryReceiver(&eofStream{MockQUICStream{readData: valid[:len(valid)-1]}})
+	if _, err := receiver.Receive(); err == nil || errors.Is(err, ErrInvalidTelemetry) {
+		t.Errorf("Expected a framing error for a truncated report, got %v", err)
+	}
+}
+
+func FuzzDecodeTelemetry(f *testing.F) {
+	for _, data := range []*TelemetryData{
+		{},
+		{RTT: 50 * time.Millisecond, Loss: 0.01, Bandwidth: 1000000, Timestamp: time.Unix(1700000000, 0)},
+		{ReceiveLoss: 0.2, FEC: &FECStats{BlocksEncoded: 10, AvgReconstructionLatency: time.Millisecond}},
+	} {
+		payload, err := EncodeTelemetry(&TelemetryPayload{Version: TelemetryVersion, Data: data})
+		if err != nil {
+			f.Fatalf("Failed to encode seed: %v", err)
+		}
+		f.Add(payload)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0xa2, 0x01, 0x02})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		payload, err := DecodeTelemetry(data)
+		if err != nil {
+			return
+		}
+		if payload.Data == nil {
+			t.Fatal("Decoded payload without data")
+		}
+		if err := validateTelemetryData(payload.Data); err != nil {
+			t.Fatalf("Decoded invalid telemetry: %v", err)
+		}
+
+		// Accepted payloads survive a round trip
+		encoded, err := EncodeTelemetry(payload)
+		if err != nil {
+			t.Fatalf("Failed to re-encode payload: %v", err)
+		}
+		if _, err := DecodeTelemetry(encoded); err != nil {
+			t.Fatalf("Failed to decode re-encoded payload: %v", err)
+		}
+	})
+}
+
+func FuzzTelemetryReceiver(f *testing.F) {
+	f.Add(encodeTestTelemetryMessage(f, Telemetry, &TelemetryPayload{Version: TelemetryVersion, Data: &TelemetryData{RTT: time.Millisecond}}))
+	f.Add(append(encodeTestTelemetryMessage(f, StreamType, &TelemetryPayload{}), 0, 0, 0, 1, 0))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		receiver := NewTelemetryReceiver(&eofStream{MockQUICStream{readData: stream}})
+		// Every report consumes at least its length prefix
+		for i := 0; i <= len(stream)/4; i++ {
+			data, err := receiver.Receive()
+			if errors.Is(err, ErrInvalidTelemetry) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if data == nil {
+				t.Fatal("Received nil telemetry without an error")
+			}
+		}
+	})
+}
+
+func TestTelemetryManagerExchange(t *testing.T) {
+	client, server := newTestConnectionPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientStream, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open telemetry stream: %v", err)
+	}
+	clientManager := NewTelemetryManager(client, clientStream, 50*time.Millisecond)
+	clientManager.Start()
+	defer clientManager.Stop()
+
+	serverStream, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("Failed to accept telemetry stream: %v", err)
+	}
+	serverManager := NewTelemetryManager(server, serverStream, 50*time.Millisecond)
+	serverManager.Start()
+	defer serverManager.Stop()
+
+	// Each side consumes the other's reports
+	for clientManager.PeerTelemetry() == nil || serverManager.PeerTelemetry() == nil {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for peer telemetry")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	peer := serverManager.PeerTelemetry()
+	if peer.Timestamp.IsZero() {
+		t.Error("Expected the client report to carry a timestamp")
+	}
+
+	// Collected samples are kept in the history
+	if clientManager.History().Len() == 0 {
+		t.Error("Expected the client history to hold samples")
+	}
+}
+
+func TestTokenBucketControllerPeerLoss(t *testing.T) {
+	controller := NewTokenBucketController(NewTokenBucket(1000, 1000), nil, nil)
+	local := &TelemetryData{Loss: 0.01}
+
+	if got := controller.withPeerLoss(local); got != local {
+		t.Error("Expected local data without a peer source")
+	}
+
+	peer := &TelemetryData{ReceiveLoss: 0.08}
+	controller.SetPeerTelemetrySource(func() *TelemetryData { return peer })
+	if got := controller.withPeerLoss(local); got.Loss != 0.08 {
+		t.Errorf("Expected peer-observed loss 0.08, got %f", got.Loss)
+	}
+	if local.Loss != 0.01 {
+		t.Error("Expected local data to be left unchanged")
+	}
+
+	peer.ReceiveLoss = 0.001
+	if got := controller.withPeerLoss(local); got.Loss != 0.01 {
+		t.Errorf("Expected local loss when it is higher, got %f", got.Loss)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
@@ -289,7 +290,7 @@ func createTestServer() (string, *Session, error) {
 				defer c.CloseWithError(0, "test completed")
 				
 				// Perform session negotiation handshake on the control stream.
-				if err := performServerHandshake(ctx, c); err != nil {
+				if _, err := performServerHandshake(ctx, c, BandwidthRates{}, nil); err != nil {
 					Error("Handshake failed: %v", err)
 					return
 				}
@@ -318,8 +319,23 @@ func createTestServer() (string, *Session, error) {
 						// If it's a stream type message, just acknowledge it by echoing it back
 						if msg.Type == StreamType {
 							Info("Received stream type message, echoing it back")
-							// Echo the stream type message back
-							if err := WriteMessage(s, msg); err != nil {
+							// Streams with FEC carry FEC frames from here on
+							if payload, err := DecodeStreamType(msg.Data); err == nil && payload.FEC {
+								codec, err := NewXORFEC(maxXORDataShards)
+								if err != nil {
+									Error("Failed to create XOR FEC: %v", err)
+									return
+								}
+								s = NewFECStreamWithCodec(s, codec)
+							}
+							// Echo the stream type message back in one write,
+							// so FEC streams carry it in one block
+							var echo bytes.Buffer
+							if err := WriteMessage(&echo, msg); err != nil {
+								Error("Failed to encode stream type echo: %v", err)
+								return
+							}
+							if _, err := s.Write(echo.Bytes()); err != nil {
 								Error("Failed to write stream type echo: %v", err)
 								return
 							}
@@ -2,7 +2,7 @@ package core
 
 import (
 	"context"
-	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -15,10 +15,18 @@ type TokenBucket struct {
 	rate float64
 	// capacity is the maximum number of tokens the bucket can hold.
 	capacity float64
-	// tokens is the current number of tokens in the bucket.
+	// tokens is the current number of tokens in the bucket. It is negative
+	// while tokens reserved ahead of time are being paid back.
 	tokens float64
+	// refilled counts the tokens added to the bucket so far, including
+	// those dropped at the capacity. Waits use it to tell when the tokens
+	// they reserved are paid back.
+	refilled float64
 	// lastUpdate is the time when the token count was last updated.
 	lastUpdate time.Time
+	// changed is closed and replaced when the rate or capacity changes, to
+	// wake up waiters.
+	changed chan struct{}
 	// mutex protects the token bucket fields.
 	mutex sync.Mutex
 }
@@ -30,6 +38,7 @@ func NewTokenBucket(rate, capacity float64) *TokenBucket {
 		capacity:   capacity,
 		tokens:     capacity, // Start with a full bucket
 		lastUpdate: time.Now(),
+		changed:    make(chan struct{}),
 	}
 }
 
@@ -40,6 +49,7 @@ func (tb *TokenBucket) updateTokens() {
 	tokensToAdd := elapsed * tb.rate
 	
 	tb.tokens += tokensToAdd
+	tb.refilled += tokensToAdd
 	if tb.tokens > tb.capacity {
 		tb.tokens = tb.capacity
 	}
@@ -47,6 +57,13 @@ func (tb *TokenBucket) updateTokens() {
 	tb.lastUpdate = now
 }
 
+// notifyChanged wakes up the waiters after a rate or capacity change.
+// The caller must hold the mutex.
+func (tb *TokenBucket) notifyChanged() {
+	close(tb.changed)
+	tb.changed = make(chan struct{})
+}
+
 // Consume attempts to consume the specified number of tokens.
 // It returns true if there were enough tokens, false otherwise.
 func (tb *TokenBucket) Consume(tokens float64) bool {
@@ -63,6 +80,94 @@ func (tb *TokenBucket) Consume(tokens float64) bool {
 	return false
 }
 
+// Wait blocks until the specified number of tokens has been consumed, or
+// until ctx is done. If there are not enough tokens, they are reserved by
+// going into debt, as Reserve does, and the wait lasts until the debt of
+// this and earlier waits has been paid back. Waiters are thus served in
+// order, and waits larger than the capacity are not starved by smaller
+// ones. If the rate changes while waiting, the wait is recomputed. At a
+// zero rate nothing is reserved until the rate is raised. When ctx is
+// done, the reserved tokens are not returned.
+func (tb *TokenBucket) Wait(ctx context.Context, tokens float64) error {
+	if tokens <= 0 {
+		return nil
+	}
+	
+	reserved := false
+	// paidAt is the refill count at which the reserved tokens are paid.
+	var paidAt float64
+	for {
+		tb.mutex.Lock()
+		tb.updateTokens()
+		
+		if !reserved {
+			if tb.tokens >= tokens {
+				tb.tokens -= tokens
+				tb.mutex.Unlock()
+				return nil
+			}
+			if tb.rate > 0 {
+				paidAt = tb.refilled + tokens - tb.tokens
+				tb.tokens -= tokens
+				reserved = true
+			}
+		}
+		if reserved && tb.refilled >= paidAt {
+			tb.mutex.Unlock()
+			return nil
+		}
+		
+		// Sleep until the debt is paid at the current rate, or until the
+		// rate or capacity changes
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if reserved && tb.rate > 0 {
+			timer = time.NewTimer(time.Duration((paidAt - tb.refilled) / tb.rate * float64(time.Second)))
+			timeout = timer.C
+		}
+		changed := tb.changed
+		tb.mutex.Unlock()
+		
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-timeout:
+		case <-changed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Reserve consumes the specified number of tokens right away, going into
+// debt if there are not enough, and returns how long the caller should wait
+// before using them. Reservations larger than the capacity are allowed.
+// The delay assumes the current rate; later tokens are only available once
+// the debt is paid back. If the rate is zero and there are not enough
+// tokens, nothing is reserved and the maximum duration is returned.
+func (tb *TokenBucket) Reserve(tokens float64) time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	
+	tb.updateTokens()
+	
+	if tb.tokens >= tokens {
+		tb.tokens -= tokens
+		return 0
+	}
+	if tb.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	
+	tb.tokens -= tokens
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
 // GetRate returns the current rate of the token bucket.
 func (tb *TokenBucket) GetRate() float64 {
 	tb.mutex.Lock()
@@ -70,23 +175,56 @@ func (tb *TokenBucket) GetRate() float64 {
 	return tb.rate
 }
 
-// SetRate sets the rate of the token bucket.
+// SetRate sets the rate of the token bucket. Tokens accumulated so far
+// are credited at the old rate, and waiters are woken up to wait at the
+// new rate.
 func (tb *TokenBucket) SetRate(rate float64) {
 	tb.mutex.Lock()
 	defer tb.mutex.Unlock()
+	
+	tb.updateTokens()
 	tb.rate = rate
+	tb.notifyChanged()
+}
+
+// GetCapacity returns the capacity of the token bucket.
+func (tb *TokenBucket) GetCapacity() float64 {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return tb.capacity
+}
+
+// SetCapacity sets the capacity of the token bucket. Tokens above the new
+// capacity are dropped, and waiters are woken up.
+func (tb *TokenBucket) SetCapacity(capacity float64) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	
+	tb.updateTokens()
+	tb.capacity = capacity
+	if tb.tokens > capacity {
+		tb.tokens = capacity
+	}
+	tb.notifyChanged()
 }
 
 // TokenBucketController controls the rate of data transmission based on telemetry data.
 type TokenBucketController struct {
 	// bucket is the token bucket being controlled.
 	bucket *TokenBucket
-	// collector is the telemetry collector.
+	// rateController decides the rate of the bucket.
+	rateController RateController
+	// rateControllerMutex protects rateController.
+	rateControllerMutex sync.Mutex
+	// collector is the telemetry collector. The telemetry is reported to the
+	// peer by the session's TelemetryManager, not by the controller.
 	collector *TelemetryCollector
-	// reporter is the telemetry reporter.
-	reporter *TelemetryReporter
 	// adaptiveFEC is the adaptive FEC controller.
 	adaptiveFEC *AdaptiveFEC
+	// peerTelemetry returns the latest telemetry reported by the peer, if set.
+	peerTelemetry func() *TelemetryData
+	// events receives rate changes and FEC adjustment failures.
+	events *EventBus
 	// ctx is the context for the controller.
 	ctx context.Context
 	// cancel is the cancel function for the controller.
@@ -97,23 +235,77 @@ type TokenBucketController struct {
 func NewTokenBucketController(bucket *TokenBucket, adaptiveFEC *AdaptiveFEC, conn quic.Connection) *TokenBucketController {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &TokenBucketController{
-		bucket:      bucket,
-		collector:   NewTelemetryCollector(conn),
-		reporter:    NewTelemetryReporter(nil), // Stream will be set later
-		adaptiveFEC: adaptiveFEC,
+		bucket:         bucket,
+		rateController: NewAIMDRateController(),
+		collector:      newControllerCollector(conn, adaptiveFEC),
+		adaptiveFEC:    adaptiveFEC,
+		events:      defaultEventBus,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
 }
 
-// SetTelemetryStream sets the telemetry stream for reporting.
-func (tbc *TokenBucketController) SetTelemetryStream(stream quic.Stream) {
-	tbc.reporter = NewTelemetryReporter(stream)
+// Bucket returns the controlled token bucket.
+func (tbc *TokenBucketController) Bucket() *TokenBucket {
+	return tbc.bucket
+}
+
+// SetRateController sets the algorithm that decides the rate of the bucket.
+// The default is AIMD. It may be changed while the controller is running,
+// e.g. once brutal mode has been negotiated.
+func (tbc *TokenBucketController) SetRateController(rateController RateController) {
+	tbc.rateControllerMutex.Lock()
+	defer tbc.rateControllerMutex.Unlock()
+	tbc.rateController = rateController
+}
+
+// RateController returns the algorithm that decides the rate of the bucket.
+func (tbc *TokenBucketController) RateController() RateController {
+	tbc.rateControllerMutex.Lock()
+	defer tbc.rateControllerMutex.Unlock()
+	return tbc.rateController
+}
+
+// SetEventBus sets the bus the controller publishes its events on.
+func (tbc *TokenBucketController) SetEventBus(events *EventBus) {
+	tbc.events = events
 }
 
 // UpdateConnection updates the connection used for telemetry collection.
 func (tbc *TokenBucketController) UpdateConnection(conn quic.Connection) {
-	tbc.collector = NewTelemetryCollector(conn)
+	tbc.collector = newControllerCollector(conn, tbc.adaptiveFEC)
+}
+
+// SetPeerTelemetrySource sets the source of the peer's telemetry reports.
+// When the peer observes more loss on the packets it receives than is
+// detected locally, the controller steers on the peer's loss.
+func (tbc *TokenBucketController) SetPeerTelemetrySource(source func() *TelemetryData) {
+	tbc.peerTelemetry = source
+}
+
+// withPeerLoss returns data with its loss raised to the loss the peer
+// observes on the packets it receives, if that is higher.
+func (tbc *TokenBucketController) withPeerLoss(data *TelemetryData) *TelemetryData {
+	if tbc.peerTelemetry == nil {
+		return data
+	}
+	peer := tbc.peerTelemetry()
+	if peer == nil || peer.ReceiveLoss <= data.Loss {
+		return data
+	}
+	adjusted := *data
+	adjusted.Loss = peer.ReceiveLoss
+	return &adjusted
+}
+
+// newControllerCollector creates a telemetry collector that reports the
+// FEC metrics of the controlled AdaptiveFEC, if any.
+func newControllerCollector(conn quic.Connection, adaptiveFEC *AdaptiveFEC) *TelemetryCollector {
+	collector := NewTelemetryCollector(conn)
+	if adaptiveFEC != nil {
+		collector.SetFECMetrics(adaptiveFEC.Metrics())
+	}
+	return collector
 }
 
 // Start starts the token bucket controller.
@@ -131,34 +323,21 @@ func (tbc *TokenBucketController) Start() {
 				// Collect telemetry data
 				data := tbc.collector.Collect()
 				
-				// Report telemetry data
-				if err := tbc.reporter.Report(data); err != nil {
-					fmt.Printf("Failed to report telemetry: %v\n", err)
-				}
+				// Steer on the receiver-observed loss if it is worse
+				data = tbc.withPeerLoss(data)
 				
 				// Adjust token bucket rate based on telemetry data
-				// This is a simple example. In a real implementation, you would
-				// use a more sophisticated algorithm.
-				if data.Loss > 0.05 { // If loss is greater than 5%
-					// Reduce rate by 10%
-					newRate := tbc.bucket.GetRate() * 0.9
+				oldRate := tbc.bucket.GetRate()
+				rateController := tbc.RateController()
+				if newRate, reason := rateController.Update(oldRate, data); reason != "" {
 					tbc.bucket.SetRate(newRate)
-					fmt.Printf("Reducing rate to %f due to high loss\n", newRate)
-				} else if data.Loss < 0.01 { // If loss is less than 1%
-					// Increase rate by 10%
-					newRate := tbc.bucket.GetRate() * 1.1
-					// Ensure rate doesn't exceed a reasonable maximum
-					// In a real implementation, this would be based on bandwidth estimation
-					if newRate < 10000000 { // 10 MB/s limit
-						tbc.bucket.SetRate(newRate)
-						fmt.Printf("Increasing rate to %f due to low loss\n", newRate)
-					}
+					tbc.events.Publish(&RateChangedEvent{OldRate: oldRate, NewRate: newRate, Loss: data.Loss, Reason: reason, Algorithm: rateController.Name()})
 				}
 				
 				// Adjust FEC parameters based on telemetry data
 				if tbc.adaptiveFEC != nil {
 					if err := tbc.adaptiveFEC.Adjust(data); err != nil {
-						fmt.Printf("Failed to adjust FEC: %v\n", err)
+						tbc.events.Publish(&FECAdjustFailedEvent{Err: err})
 					}
 				}
 			}
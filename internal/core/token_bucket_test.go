@@ -1,6 +1,9 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"math"
 	"testing"
 	"time"
 )
@@ -54,6 +57,142 @@ func TestTokenBucketRate(t *testing.T) {
 	}
 }
 
+func TestTokenBucketWait(t *testing.T) {
+	bucket := NewTokenBucket(1000, 100)
+
+	// A full bucket serves the first wait at once
+	start := time.Now()
+	if err := bucket.Wait(context.Background(), 100); err != nil {
+		t.Fatalf("Failed to wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("Expected the first wait to return at once, took %v", elapsed)
+	}
+
+	// 300 tokens exceed the capacity and take 300ms at 1000 tokens/s
+	start = time.Now()
+	if err := bucket.Wait(context.Background(), 300); err != nil {
+		t.Fatalf("Failed to wait for more than the capacity: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected the wait to take about 300ms, took %v", elapsed)
+	}
+
+	// Nothing arrives at a zero rate, so the wait ends with the context
+	bucket.SetRate(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bucket.Wait(ctx, 50); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
+
+func TestTokenBucketSetRateDuringWait(t *testing.T) {
+	bucket := NewTokenBucket(0, 100)
+	bucket.Consume(100)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bucket.Wait(context.Background(), 50)
+	}()
+
+	// Time spent at the zero rate earns no tokens
+	time.Sleep(100 * time.Millisecond)
+	bucket.SetRate(1000)
+	if bucket.Consume(50) {
+		t.Error("Expected no tokens to be credited for the time at the old rate")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed to wait: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not pick up the new rate")
+	}
+}
+
+func TestTokenBucketWaitWithoutCapacity(t *testing.T) {
+	bucket := NewTokenBucket(1000, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := bucket.Wait(ctx, 100); err != nil {
+		t.Fatalf("Failed to wait on a bucket without capacity: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected the wait to take about 100ms, took %v", elapsed)
+	}
+}
+
+func TestTokenBucketWaitIsNotStarved(t *testing.T) {
+	bucket := NewTokenBucket(10000, 1000)
+	bucket.Consume(1000)
+
+	// Small waiters keep taking tokens as they arrive
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 8; i++ {
+		go func() {
+			for bucket.Wait(ctx, 10) == nil {
+			}
+		}()
+	}
+
+	// A wait for the whole capacity still gets its turn
+	done := make(chan error, 1)
+	go func() {
+		done <- bucket.Wait(ctx, 1000)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed to wait: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the wait for the capacity not to be starved")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	bucket := NewTokenBucket(1000, 100)
+
+	if delay := bucket.Reserve(100); delay != 0 {
+		t.Errorf("Expected no delay for available tokens, got %v", delay)
+	}
+
+	// 200 tokens more than the capacity are paid back over 200ms
+	delay := bucket.Reserve(200)
+	if delay < 190*time.Millisecond || delay > 210*time.Millisecond {
+		t.Errorf("Expected a delay of about 200ms, got %v", delay)
+	}
+	if bucket.Consume(1) {
+		t.Error("Expected no tokens while the reservation is paid back")
+	}
+
+	bucket.SetRate(0)
+	if delay := bucket.Reserve(1); delay != time.Duration(math.MaxInt64) {
+		t.Errorf("Expected an infinite delay at a zero rate, got %v", delay)
+	}
+}
+
+func TestTokenBucketSetCapacity(t *testing.T) {
+	bucket := NewTokenBucket(0, 100)
+	bucket.SetCapacity(50)
+
+	if bucket.GetCapacity() != 50 {
+		t.Errorf("Expected capacity 50, got %f", bucket.GetCapacity())
+	}
+	if bucket.Consume(60) {
+		t.Error("Expected tokens above the new capacity to be dropped")
+	}
+	if !bucket.Consume(50) {
+		t.Error("Expected to consume the new capacity")
+	}
+}
+
 func TestAdaptiveFEC(t *testing.T) {
 	// Create an adaptive FEC with 10 data shards and 3 parity shards
 	adaptiveFEC, err := NewAdaptiveFEC(10, 3, 1, 5)
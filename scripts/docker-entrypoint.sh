@@ -28,7 +28,10 @@ if [ ! -f "$DEFAULT_CONFIG" ]; then
   "fec_data": 10,
   "fec_parity": 3,
   "token_bucket_rate": 1000000,
-  "token_bucket_capacity": 5000000
+  "token_bucket_capacity": 5000000,
+  "rate_control": "aimd",
+  "metrics_address": "127.0.0.1:8080",
+  "accounting_file": "/var/lib/vantun/traffic.json"
 }
 EOF
             ;;
@@ -45,6 +48,8 @@ EOF
   "fec_parity": 3,
   "token_bucket_rate": 1000000,
   "token_bucket_capacity": 5000000,
+  "rate_control": "aimd",
+  "metrics_address": "127.0.0.1:8080",
   "local_addr": "127.0.0.1:1080",
   "socks5": true
 }